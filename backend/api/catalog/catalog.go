@@ -0,0 +1,294 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types lists the TYPE values understood by the itzg/minecraft-server image.
+var Types = []string{
+	"VANILLA",
+	"PAPER",
+	"FOLIA",
+	"PURPUR",
+	"SPIGOT",
+	"BUKKIT",
+	"FABRIC",
+	"QUILT",
+	"FORGE",
+	"NEOFORGE",
+	"MAGMA",
+	"MOHIST",
+	"SPONGEVANILLA",
+	"CUSTOM",
+}
+
+// MaxAge is how old the cached manifest may get before it is refreshed in
+// the background.
+const MaxAge = 24 * time.Hour
+
+// staleCheckInterval is how often Watch checks the manifest's age.
+const staleCheckInterval = time.Hour
+
+// Manifest is the cached list of versions available per server type. Versions
+// are ordered newest first.
+type Manifest struct {
+	UpdatedAt time.Time           `json:"updatedAt"`
+	Versions  map[string][]string `json:"versions"`
+}
+
+// ValidationError reports a type or version that does not exist.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Catalog validates server types and versions against a manifest cached on
+// disk. In offline mode the manifest file is never refreshed from the
+// network, so it must be provided by the administrator.
+type Catalog struct {
+	mu       sync.RWMutex
+	path     string
+	offline  bool
+	manifest Manifest
+}
+
+// New loads the manifest cached at path. A missing file is not an error; the
+// catalog then accepts only LATEST and SNAPSHOT until it is refreshed.
+func New(path string, offline bool) (*Catalog, error) {
+	c := &Catalog{
+		path:     path,
+		offline:  offline,
+		manifest: Manifest{Versions: map[string][]string{}},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Version catalog %s not found", path)
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version catalog: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &c.manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse version catalog: %v", err)
+	}
+	if c.manifest.Versions == nil {
+		c.manifest.Versions = map[string][]string{}
+	}
+
+	log.Printf("Loaded version catalog from %s (updated %s)", path, c.manifest.UpdatedAt.Format(time.RFC3339))
+	return c, nil
+}
+
+// Offline reports whether network refreshes are disabled.
+func (c *Catalog) Offline() bool {
+	return c.offline
+}
+
+// Stale reports whether the manifest is missing or older than MaxAge.
+func (c *Catalog) Stale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Since(c.manifest.UpdatedAt) > MaxAge
+}
+
+// Manifest returns a copy of the current manifest.
+func (c *Catalog) Manifest() Manifest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	versions := make(map[string][]string, len(c.manifest.Versions))
+	for serverType, list := range c.manifest.Versions {
+		versions[serverType] = append([]string(nil), list...)
+	}
+	return Manifest{UpdatedAt: c.manifest.UpdatedAt, Versions: versions}
+}
+
+// Versions returns the known versions for a server type, newest first.
+func (c *Catalog) Versions(serverType string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.manifest.Versions[strings.ToUpper(serverType)]...)
+}
+
+// Refresh fetches the versions for every type from upstream and writes the
+// manifest to disk. Types whose source fails keep their cached versions.
+func (c *Catalog) Refresh(ctx context.Context) error {
+	if c.offline {
+		return fmt.Errorf("version catalog is in offline mode")
+	}
+
+	fetched, err := fetchAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for serverType, versions := range fetched {
+		c.manifest.Versions[serverType] = versions
+	}
+	c.manifest.UpdatedAt = time.Now()
+	manifest := c.manifest
+	c.mu.Unlock()
+
+	return c.save(manifest)
+}
+
+// Watch refreshes the manifest whenever it is older than MaxAge, checking
+// every staleCheckInterval so a failed refresh is retried. It returns when
+// ctx is done and does nothing in offline mode.
+func (c *Catalog) Watch(ctx context.Context) {
+	if c.offline {
+		return
+	}
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+	for {
+		if c.Stale() {
+			log.Printf("Refreshing version catalog")
+			if err := c.Refresh(ctx); err != nil {
+				log.Printf("Error refreshing version catalog: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Catalog) save(manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write version catalog: %v", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// NormalizeType returns the canonical TYPE value, defaulting to VANILLA.
+func NormalizeType(serverType string) string {
+	if serverType == "" {
+		return "VANILLA"
+	}
+	return strings.ToUpper(serverType)
+}
+
+// Validate checks that serverType is a known TYPE and that version is
+// available for it. Explicit versions of a type the catalog has no versions
+// for yet are refused; CUSTOM servers bring their own jar and take any
+// version.
+func (c *Catalog) Validate(serverType, version string) error {
+	serverType = NormalizeType(serverType)
+	if !knownType(serverType) {
+		return &ValidationError{fmt.Sprintf("unknown server type %q, expected one of %s", serverType, strings.Join(Types, ", "))}
+	}
+
+	switch strings.ToUpper(version) {
+	case "LATEST":
+		return nil
+	case "SNAPSHOT":
+		if serverType != "VANILLA" {
+			return &ValidationError{fmt.Sprintf("SNAPSHOT is only available for VANILLA servers, not %s", serverType)}
+		}
+		return nil
+	}
+
+	if serverType == "CUSTOM" {
+		return nil
+	}
+	versions := c.Versions(serverType)
+	if len(versions) == 0 {
+		return &ValidationError{fmt.Sprintf("no %s versions are known yet; use LATEST or refresh the version catalog", serverType)}
+	}
+	for _, v := range versions {
+		if v == version {
+			return nil
+		}
+	}
+	return &ValidationError{fmt.Sprintf("version %s is not available for server type %s", version, serverType)}
+}
+
+// Resolve returns the version LATEST or SNAPSHOT currently stands for, the
+// newest release or the newest version of any kind. Other versions, and
+// aliases the catalog has no versions for, are returned as they are.
+func (c *Catalog) Resolve(serverType, version string) string {
+	serverType = NormalizeType(serverType)
+	var versions []string
+	switch strings.ToUpper(version) {
+	case "", "LATEST":
+		versions = c.Versions(serverType)
+		if serverType == "VANILLA" {
+			// Vanilla lists snapshots too; the release types only list releases
+			versions = c.Versions(releaseTypes[0])
+		}
+	case "SNAPSHOT":
+		versions = c.Versions(serverType)
+	}
+	if len(versions) == 0 {
+		return version
+	}
+	return versions[0]
+}
+
+// Compare orders two versions of a server type by release, after resolving
+// LATEST and SNAPSHOT, returning -1 if a is older than b, 1 if newer and 0
+// if equal. ok is false when either version isn't in the catalog.
+func (c *Catalog) Compare(serverType, a, b string) (cmp int, ok bool) {
+	a, b = c.Resolve(serverType, a), c.Resolve(serverType, b)
+	versions := c.Versions(NormalizeType(serverType))
+	ia, ib := -1, -1
+	for i, v := range versions {
+		if v == a {
+			ia = i
+		}
+		if v == b {
+			ib = i
+		}
+	}
+	switch {
+	case ia == -1 || ib == -1:
+		return 0, false
+	case ia == ib:
+		return 0, true
+	case ia > ib:
+		// Newest first, so a lower index is a newer version
+		return -1, true
+	}
+	return 1, true
+}
+
+func knownType(serverType string) bool {
+	i := sort.SearchStrings(sortedTypes, serverType)
+	return i < len(sortedTypes) && sortedTypes[i] == serverType
+}
+
+var sortedTypes = func() []string {
+	types := append([]string(nil), Types...)
+	sort.Strings(types)
+	return types
+}()
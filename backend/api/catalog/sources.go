@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	mojangManifestURL = "https://piston-meta.mojang.com/mc/game/version_manifest_v2.json"
+	paperProjectURL   = "https://api.papermc.io/v2/projects/%s"
+	purpurProjectURL  = "https://api.purpurmc.org/v2/purpur"
+	fabricGameURL     = "https://meta.fabricmc.net/v2/versions/game"
+	quiltGameURL      = "https://meta.quiltmc.org/v3/versions/game"
+	forgePromosURL    = "https://files.minecraftforge.net/net/minecraftforge/forge/promotions_slim.json"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// releaseTypes have no version API of their own and follow vanilla releases.
+var releaseTypes = []string{"SPIGOT", "BUKKIT", "NEOFORGE", "MAGMA", "MOHIST", "SPONGEVANILLA"}
+
+// fetchAll builds a fresh version map from upstream APIs. Vanilla must be
+// reachable; other sources are skipped with a log message when they fail.
+func fetchAll(ctx context.Context) (map[string][]string, error) {
+	all, releases, err := fetchVanilla(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vanilla versions: %v", err)
+	}
+
+	versions := map[string][]string{"VANILLA": all}
+	for _, serverType := range releaseTypes {
+		versions[serverType] = releases
+	}
+
+	sources := map[string]func(context.Context) ([]string, error){
+		"PAPER":  func(ctx context.Context) ([]string, error) { return fetchPaper(ctx, "paper") },
+		"FOLIA":  func(ctx context.Context) ([]string, error) { return fetchPaper(ctx, "folia") },
+		"PURPUR": fetchPurpur,
+		"FABRIC": func(ctx context.Context) ([]string, error) { return fetchGameVersions(ctx, fabricGameURL) },
+		"QUILT":  func(ctx context.Context) ([]string, error) { return fetchGameVersions(ctx, quiltGameURL) },
+		"FORGE":  func(ctx context.Context) ([]string, error) { return fetchForge(ctx, releases) },
+	}
+	for serverType, fetch := range sources {
+		list, err := fetch(ctx)
+		if err != nil {
+			log.Printf("Error fetching %s versions: %v", serverType, err)
+			continue
+		}
+		versions[serverType] = list
+	}
+
+	return versions, nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "mboxmini")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchVanilla returns all versions and release versions, newest first.
+func fetchVanilla(ctx context.Context) (all, releases []string, err error) {
+	var manifest struct {
+		Versions []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"versions"`
+	}
+	if err := getJSON(ctx, mojangManifestURL, &manifest); err != nil {
+		return nil, nil, err
+	}
+
+	for _, v := range manifest.Versions {
+		all = append(all, v.ID)
+		if v.Type == "release" {
+			releases = append(releases, v.ID)
+		}
+	}
+	return all, releases, nil
+}
+
+func fetchPaper(ctx context.Context, project string) ([]string, error) {
+	var resp struct {
+		Versions []string `json:"versions"`
+	}
+	if err := getJSON(ctx, fmt.Sprintf(paperProjectURL, project), &resp); err != nil {
+		return nil, err
+	}
+	return reversed(resp.Versions), nil
+}
+
+func fetchPurpur(ctx context.Context) ([]string, error) {
+	var resp struct {
+		Versions []string `json:"versions"`
+	}
+	if err := getJSON(ctx, purpurProjectURL, &resp); err != nil {
+		return nil, err
+	}
+	return reversed(resp.Versions), nil
+}
+
+// fetchGameVersions reads the Fabric/Quilt meta format, which is already
+// ordered newest first.
+func fetchGameVersions(ctx context.Context, url string) ([]string, error) {
+	var resp []struct {
+		Version string `json:"version"`
+	}
+	if err := getJSON(ctx, url, &resp); err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(resp))
+	for _, v := range resp {
+		versions = append(versions, v.Version)
+	}
+	return versions, nil
+}
+
+// fetchForge lists the Minecraft versions that have a promoted Forge build,
+// ordered like the vanilla releases.
+func fetchForge(ctx context.Context, releases []string) ([]string, error) {
+	var resp struct {
+		Promos map[string]string `json:"promos"`
+	}
+	if err := getJSON(ctx, forgePromosURL, &resp); err != nil {
+		return nil, err
+	}
+
+	supported := map[string]bool{}
+	for key := range resp.Promos {
+		// Keys look like "1.20.1-latest" or "1.20.1-recommended"
+		if i := strings.LastIndex(key, "-"); i > 0 {
+			supported[key[:i]] = true
+		}
+	}
+
+	var versions []string
+	for _, v := range releases {
+		if supported[v] {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func reversed(list []string) []string {
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[len(list)-1-i] = v
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditWorldDownload = "world_download"
+)
+
+// AuditEntry records who did something sensitive to a server and when.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	Action     string                 `json:"action"`
+	UserID     int64                  `json:"userId,omitempty"`
+	ServerID   string                 `json:"serverId,omitempty"`
+	Details    map[string]interface{} `json:"details"`
+	RemoteAddr string                 `json:"remoteAddr,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+}
+
+// AuditFilter narrows ListAudit. Empty fields match everything.
+type AuditFilter struct {
+	Action   string
+	ServerID string
+	UserID   int64
+}
+
+func (db *DB) RecordAudit(entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO audit_log (action, user_id, server_id, details, remote_addr, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, entry.Action, nullOwner(entry.UserID), entry.ServerID, string(details), entry.RemoteAddr, now)
+	if err != nil {
+		return err
+	}
+
+	entry.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.CreatedAt = now
+	return nil
+}
+
+// ListAudit returns the most recent entries matching filter, newest first.
+func (db *DB) ListAudit(filter AuditFilter, limit int) ([]AuditEntry, error) {
+	query := `SELECT id, action, user_id, server_id, details, remote_addr, created_at FROM audit_log WHERE 1 = 1`
+	args := []interface{}{}
+	if filter.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, filter.Action)
+	}
+	if filter.ServerID != "" {
+		query += ` AND server_id = ?`
+		args = append(args, filter.ServerID)
+	}
+	if filter.UserID != 0 {
+		query += ` AND user_id = ?`
+		args = append(args, filter.UserID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var userID sql.NullInt64
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.Action, &userID, &entry.ServerID, &details, &entry.RemoteAddr, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.UserID = userID.Int64
+		if err := json.Unmarshal([]byte(details), &entry.Details); err != nil || entry.Details == nil {
+			entry.Details = map[string]interface{}{}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Job statuses
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running operation such as creating, cloning, backing up or
+// upgrading a server. Progress is the completion percentage of the current
+// step.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	ServerID   string          `json:"serverId,omitempty"`
+	Status     string          `json:"status"`
+	Step       string          `json:"step"`
+	Progress   int             `json:"progress"`
+	Message    string          `json:"message,omitempty"`
+	Steps      []JobStep       `json:"steps"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+type JobStep struct {
+	Name       string     `json:"name"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Done reports whether the job has reached a terminal status.
+func (j *Job) Done() bool {
+	switch j.Status {
+	case JobSucceeded, JobFailed, JobCancelled:
+		return true
+	}
+	return false
+}
+
+func NewJobID() (string, error) {
+	return newUUID()
+}
+
+func (db *DB) CreateJob(job *Job) error {
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if job.Steps == nil {
+		job.Steps = []JobStep{}
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO jobs (id, kind, server_id, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Kind, job.ServerID, job.Status, now, now)
+	return err
+}
+
+// SaveJob persists the mutable fields of a job.
+func (db *DB) SaveJob(job *Job) error {
+	steps, err := json.Marshal(job.Steps)
+	if err != nil {
+		return err
+	}
+	result := job.Result
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+
+	job.UpdatedAt = time.Now()
+	_, err = db.Exec(`
+		UPDATE jobs
+		SET server_id = ?, status = ?, step = ?, progress = ?, message = ?, steps = ?,
+		    result = ?, error = ?, updated_at = ?, finished_at = ?
+		WHERE id = ?
+	`, job.ServerID, job.Status, job.Step, job.Progress, job.Message, string(steps),
+		string(result), job.Error, job.UpdatedAt, job.FinishedAt, job.ID)
+	return err
+}
+
+const jobColumns = `id, kind, server_id, status, step, progress, message, steps, result, error,
+	created_at, updated_at, finished_at`
+
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	var steps, result string
+	var finishedAt sql.NullTime
+	if err := row.Scan(
+		&job.ID, &job.Kind, &job.ServerID, &job.Status, &job.Step, &job.Progress, &job.Message,
+		&steps, &result, &job.Error, &job.CreatedAt, &job.UpdatedAt, &finishedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(steps), &job.Steps); err != nil {
+		return nil, err
+	}
+	if result != "null" {
+		job.Result = json.RawMessage(result)
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+func (db *DB) GetJob(id string) (*Job, error) {
+	job, err := scanJob(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ListJobs returns the most recent jobs, optionally limited to one server.
+func (db *DB) ListJobs(serverID string, limit int) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	args := []interface{}{}
+	if serverID != "" {
+		query += ` WHERE server_id = ?`
+		args = append(args, serverID)
+	}
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// FailInterruptedJobs marks jobs that were still pending or running when the
+// API stopped as failed.
+func (db *DB) FailInterruptedJobs() error {
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = ?, error = 'interrupted by API restart', updated_at = ?, finished_at = ?
+		WHERE status IN (?, ?)
+	`, JobFailed, time.Now(), time.Now(), JobPending, JobRunning)
+	return err
+}
@@ -0,0 +1,64 @@
+package database
+
+import "fmt"
+
+// columnMigrations lists columns added to tables after they first shipped.
+// CREATE TABLE IF NOT EXISTS leaves existing tables alone, so these are
+// added with ALTER TABLE when missing, then backfill runs if set.
+var columnMigrations = []struct {
+	table, column, definition, backfill string
+}{
+	{"servers", "owner_id", "INTEGER REFERENCES users(id) ON DELETE SET NULL", ""},
+	{"servers", "restart_required_at", "DATETIME", ""},
+	{"player_sessions", "last_seen", "DATETIME", ""},
+	{"network_servers", "online_mode", "TEXT", ""},
+	{"user_quotas", "max_cpus", "REAL", ""},
+	// Existing installs keep an admin: their first user
+	{"users", "is_admin", "INTEGER NOT NULL DEFAULT 0", `UPDATE users SET is_admin = 1 WHERE id = (SELECT MIN(id) FROM users)`},
+}
+
+func (db *DB) migrate() error {
+	for _, m := range columnMigrations {
+		exists, err := db.hasColumn(m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %v", m.table, m.column, err)
+		}
+		if m.backfill != "" {
+			if _, err := db.Exec(m.backfill); err != nil {
+				return fmt.Errorf("failed to backfill %s.%s: %v", m.table, m.column, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, kind string
+			notNull    int
+			dflt       interface{}
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Network is a group of servers behind one proxy container. Players connect
+// to the proxy, which reaches the member servers over DockerNetwork.
+type Network struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	ProxyType        string    `json:"proxyType"`
+	ContainerName    string    `json:"containerName"`
+	DockerNetwork    string    `json:"dockerNetwork"`
+	ForwardingSecret string    `json:"-"`
+	LobbyServerID    string    `json:"lobbyServerId,omitempty"`
+	OwnerID          int64     `json:"ownerId,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// NetworkServer is a server's membership in a network. Alias is the name the
+// proxy knows it by. OnlineMode is the server's ONLINE_MODE before it joined,
+// restored when it leaves, or nil if it wasn't set.
+type NetworkServer struct {
+	NetworkID  string    `json:"networkId"`
+	ServerID   string    `json:"serverId"`
+	Alias      string    `json:"alias"`
+	OnlineMode *string   `json:"-"`
+	JoinedAt   time.Time `json:"joinedAt"`
+}
+
+func scanNetworkServer(row rowScanner) (*NetworkServer, error) {
+	var member NetworkServer
+	var onlineMode sql.NullString
+	if err := row.Scan(&member.NetworkID, &member.ServerID, &member.Alias, &onlineMode, &member.JoinedAt); err != nil {
+		return nil, err
+	}
+	if onlineMode.Valid {
+		member.OnlineMode = &onlineMode.String
+	}
+	return &member, nil
+}
+
+func NewNetworkID() (string, error) {
+	return newUUID()
+}
+
+func (db *DB) CreateNetwork(network *Network) error {
+	network.CreatedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO networks (id, name, proxy_type, container_name, docker_network, forwarding_secret, lobby_server_id, owner_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, network.ID, network.Name, network.ProxyType, network.ContainerName, network.DockerNetwork,
+		network.ForwardingSecret, nullString(network.LobbyServerID), nullOwner(network.OwnerID), network.CreatedAt)
+	return err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+const networkColumns = `id, name, proxy_type, container_name, docker_network, forwarding_secret, lobby_server_id, owner_id, created_at`
+
+func scanNetworkRow(row rowScanner) (*Network, error) {
+	var network Network
+	var lobby sql.NullString
+	var ownerID sql.NullInt64
+	if err := row.Scan(&network.ID, &network.Name, &network.ProxyType, &network.ContainerName, &network.DockerNetwork,
+		&network.ForwardingSecret, &lobby, &ownerID, &network.CreatedAt); err != nil {
+		return nil, err
+	}
+	network.LobbyServerID = lobby.String
+	network.OwnerID = ownerID.Int64
+	return &network, nil
+}
+
+func (db *DB) GetNetwork(id string) (*Network, error) {
+	network, err := scanNetworkRow(db.QueryRow(`SELECT `+networkColumns+` FROM networks WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return network, err
+}
+
+func (db *DB) GetNetworkByName(name string) (*Network, error) {
+	network, err := scanNetworkRow(db.QueryRow(`SELECT `+networkColumns+` FROM networks WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return network, err
+}
+
+func (db *DB) ListNetworks() ([]Network, error) {
+	rows, err := db.Query(`SELECT ` + networkColumns + ` FROM networks ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	networks := []Network{}
+	for rows.Next() {
+		network, err := scanNetworkRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, *network)
+	}
+	return networks, rows.Err()
+}
+
+// SetNetworkLobby sets the server players join first. Empty clears it.
+func (db *DB) SetNetworkLobby(networkID, serverID string) error {
+	_, err := db.Exec(`UPDATE networks SET lobby_server_id = ? WHERE id = ?`, nullString(serverID), networkID)
+	return err
+}
+
+// DeleteNetwork removes a network and its memberships.
+func (db *DB) DeleteNetwork(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM network_servers WHERE network_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM networks WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) AddNetworkServer(member *NetworkServer) error {
+	member.JoinedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO network_servers (server_id, network_id, alias, online_mode, joined_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, member.ServerID, member.NetworkID, member.Alias, member.OnlineMode, member.JoinedAt)
+	return err
+}
+
+// RemoveNetworkServer removes a server from its network, clearing it as the
+// lobby if it was one.
+func (db *DB) RemoveNetworkServer(serverID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM network_servers WHERE server_id = ?`, serverID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE networks SET lobby_server_id = NULL WHERE lobby_server_id = ?`, serverID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetServerNetwork returns the network membership of a server, or nil if it
+// is not in a network.
+func (db *DB) GetServerNetwork(serverID string) (*NetworkServer, error) {
+	member, err := scanNetworkServer(db.QueryRow(`
+		SELECT network_id, server_id, alias, online_mode, joined_at
+		FROM network_servers
+		WHERE server_id = ?
+	`, serverID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return member, err
+}
+
+// ListNetworkServers returns the members of a network in the order they
+// joined.
+func (db *DB) ListNetworkServers(networkID string) ([]NetworkServer, error) {
+	rows, err := db.Query(`
+		SELECT network_id, server_id, alias, online_mode, joined_at
+		FROM network_servers
+		WHERE network_id = ?
+		ORDER BY joined_at, alias
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []NetworkServer{}
+	for rows.Next() {
+		member, err := scanNetworkServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, rows.Err()
+}
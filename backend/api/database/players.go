@@ -0,0 +1,206 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PlayerActivity sums up a player's sessions, on all servers or on one.
+// Playtime is in seconds and includes sessions still in progress.
+type PlayerActivity struct {
+	Name      string    `json:"name"`
+	ServerID  string    `json:"serverId,omitempty"`
+	Servers   int       `json:"servers,omitempty"`
+	Sessions  int       `json:"sessions"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Playtime  int64     `json:"playtime"`
+	Online    bool      `json:"online"`
+}
+
+// likePattern matches names containing s, treating LIKE wildcards in s
+// literally; underscores are common in player names.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// ListPlayers returns the players whose names contain search, most recently
+// seen first, and how many there are in total.
+func (db *DB) ListPlayers(search string, limit, offset int) ([]PlayerActivity, int, error) {
+	var total int
+	if err := db.QueryRow(`
+		SELECT COUNT(DISTINCT player_name COLLATE NOCASE)
+		FROM player_sessions
+		WHERE player_name LIKE ? ESCAPE '\'
+	`, likePattern(search)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	players, err := db.playerActivity(`
+		SELECT MIN(id) AS first_id, MAX(id) AS last_id, COUNT(*) AS sessions,
+		       COUNT(DISTINCT server_id) AS servers, SUM(duration) AS playtime, '' AS server_id
+		FROM player_sessions
+		WHERE player_name LIKE ? ESCAPE '\'
+		GROUP BY player_name COLLATE NOCASE
+		ORDER BY last_id DESC
+		LIMIT ? OFFSET ?
+	`, likePattern(search), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return players, total, nil
+}
+
+// PlayerServers returns a player's activity on each server they have been
+// seen on, most recently seen first.
+func (db *DB) PlayerServers(name string) ([]PlayerActivity, error) {
+	return db.playerActivity(`
+		SELECT MIN(id) AS first_id, MAX(id) AS last_id, COUNT(*) AS sessions,
+		       0 AS servers, SUM(duration) AS playtime, server_id
+		FROM player_sessions
+		WHERE player_name = ? COLLATE NOCASE
+		GROUP BY server_id
+		ORDER BY last_id DESC
+	`, name)
+}
+
+// playerActivity runs a query grouping sessions into first_id, last_id,
+// sessions, servers, playtime and server_id, then fills in the times from
+// the first and last sessions and adds the time of sessions in progress.
+func (db *DB) playerActivity(groups string, args ...interface{}) ([]PlayerActivity, error) {
+	rows, err := db.Query(`
+		WITH grouped AS (`+groups+`)
+		SELECT l.player_name, g.server_id, g.sessions, g.servers, g.playtime,
+		       f.join_time, l.join_time, l.leave_time, l.last_seen
+		FROM grouped g
+		JOIN player_sessions f ON f.id = g.first_id
+		JOIN player_sessions l ON l.id = g.last_id
+		ORDER BY g.last_id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := []PlayerActivity{}
+	for rows.Next() {
+		var p PlayerActivity
+		var lastJoin time.Time
+		var leaveTime, lastSeen sql.NullTime
+		if err := rows.Scan(&p.Name, &p.ServerID, &p.Sessions, &p.Servers, &p.Playtime,
+			&p.FirstSeen, &lastJoin, &leaveTime, &lastSeen); err != nil {
+			return nil, err
+		}
+		p.LastSeen = lastJoin
+		if lastSeen.Valid {
+			p.LastSeen = lastSeen.Time
+		}
+		if leaveTime.Valid {
+			p.LastSeen = leaveTime.Time
+		}
+		players = append(players, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	open, err := db.OpenPlayerSessions()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range players {
+		for _, session := range open {
+			if !strings.EqualFold(session.PlayerName, players[i].Name) {
+				continue
+			}
+			if players[i].ServerID != "" && session.ServerID != players[i].ServerID {
+				continue
+			}
+			players[i].Online = true
+			players[i].LastSeen = now
+			players[i].Playtime += int64(now.Sub(session.JoinTime).Seconds())
+		}
+	}
+	return players, nil
+}
+
+// PlayerSessions returns a player's sessions on all servers, newest first,
+// and how many there are in total.
+func (db *DB) PlayerSessions(name string, limit, offset int) ([]PlayerSession, int, error) {
+	var total int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM player_sessions WHERE player_name = ? COLLATE NOCASE
+	`, name).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	sessions, err := db.queryPlayerSessions(`
+		WHERE player_name = ? COLLATE NOCASE
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, name, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sessions, total, nil
+}
+
+// OpenPlayerSessions returns the sessions of players who are online, or were
+// when they were last checked.
+func (db *DB) OpenPlayerSessions() ([]PlayerSession, error) {
+	return db.queryPlayerSessions(`WHERE leave_time IS NULL ORDER BY id`)
+}
+
+func (db *DB) queryPlayerSessions(where string, args ...interface{}) ([]PlayerSession, error) {
+	rows, err := db.Query(`
+		SELECT id, server_id, player_name, join_time, leave_time, duration, last_seen
+		FROM player_sessions
+	`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []PlayerSession{}
+	for rows.Next() {
+		var s PlayerSession
+		var leaveTime, lastSeen sql.NullTime
+		if err := rows.Scan(&s.ID, &s.ServerID, &s.PlayerName, &s.JoinTime, &leaveTime, &s.Duration, &lastSeen); err != nil {
+			return nil, err
+		}
+		if leaveTime.Valid {
+			s.LeaveTime = &leaveTime.Time
+		}
+		if lastSeen.Valid {
+			s.LastSeen = &lastSeen.Time
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchPlayerSessions records that the players with open sessions on a
+// server were still online at a time.
+func (db *DB) TouchPlayerSessions(serverID string, at time.Time) error {
+	_, err := db.Exec(`
+		UPDATE player_sessions SET last_seen = ?
+		WHERE server_id = ? AND leave_time IS NULL
+	`, at, serverID)
+	return err
+}
+
+// EndPlayerSession closes a session at a time.
+func (db *DB) EndPlayerSession(session PlayerSession, at time.Time) error {
+	duration := at.Sub(session.JoinTime)
+	if duration < 0 {
+		duration = 0
+	}
+	_, err := db.Exec(`
+		UPDATE player_sessions SET leave_time = ?, duration = ?
+		WHERE id = ?
+	`, at, int64(duration.Seconds()), session.ID)
+	return err
+}
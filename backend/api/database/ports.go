@@ -0,0 +1,89 @@
+package database
+
+import "time"
+
+// PortAllocation reserves a host port for a server. Reservations outlive the
+// container so a stopped or recreated server keeps its port.
+type PortAllocation struct {
+	Port        int       `json:"port"`
+	Protocol    string    `json:"protocol"`
+	ServerID    string    `json:"serverId"`
+	AllocatedAt time.Time `json:"allocatedAt"`
+}
+
+// AllocatePort reserves port for serverID. It returns false if the port is
+// already reserved.
+func (db *DB) AllocatePort(port int, protocol, serverID string) (bool, error) {
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO port_allocations (port, protocol, server_id, allocated_at)
+		VALUES (?, ?, ?, ?)
+	`, port, protocol, serverID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// GetPortAllocation returns the reservation of a port, or nil if it is free.
+func (db *DB) GetPortAllocation(port int, protocol string) (*PortAllocation, error) {
+	allocations, err := db.queryPortAllocations(`WHERE port = ? AND protocol = ?`, port, protocol)
+	if err != nil || len(allocations) == 0 {
+		return nil, err
+	}
+	return &allocations[0], nil
+}
+
+func (db *DB) ListPortAllocations() ([]PortAllocation, error) {
+	return db.queryPortAllocations(`ORDER BY protocol, port`)
+}
+
+func (db *DB) ListServerPorts(serverID string) ([]PortAllocation, error) {
+	return db.queryPortAllocations(`WHERE server_id = ? ORDER BY protocol, port`, serverID)
+}
+
+func (db *DB) queryPortAllocations(where string, args ...interface{}) ([]PortAllocation, error) {
+	rows, err := db.Query(`SELECT port, protocol, server_id, allocated_at FROM port_allocations `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := []PortAllocation{}
+	for rows.Next() {
+		var a PortAllocation
+		if err := rows.Scan(&a.Port, &a.Protocol, &a.ServerID, &a.AllocatedAt); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+func (db *DB) ReleasePort(port int, protocol string) error {
+	_, err := db.Exec(`DELETE FROM port_allocations WHERE port = ? AND protocol = ?`, port, protocol)
+	return err
+}
+
+// ReleaseServerPorts frees every port reserved for a server.
+func (db *DB) ReleaseServerPorts(serverID string) error {
+	_, err := db.Exec(`DELETE FROM port_allocations WHERE server_id = ?`, serverID)
+	return err
+}
+
+// ReleaseOrphanedPorts frees reservations of servers and network proxies
+// that no longer exist.
+func (db *DB) ReleaseOrphanedPorts() (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM port_allocations
+		WHERE server_id NOT IN (SELECT id FROM servers)
+		AND server_id NOT IN (SELECT id FROM networks)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PlayerProfile caches the UUID of a Java Edition player name so files can
+// be edited without asking Mojang every time.
+type PlayerProfile struct {
+	Name      string
+	UUID      string
+	FetchedAt time.Time
+}
+
+// GetPlayerProfile returns the cached profile for a name, matched without
+// regard to case, or nil if there is none.
+func (db *DB) GetPlayerProfile(name string) (*PlayerProfile, error) {
+	var profile PlayerProfile
+	err := db.QueryRow(`
+		SELECT display_name, uuid, fetched_at FROM player_profiles WHERE name = ?
+	`, name).Scan(&profile.Name, &profile.UUID, &profile.FetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (db *DB) SavePlayerProfile(profile *PlayerProfile) error {
+	profile.FetchedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO player_profiles (name, uuid, display_name, fetched_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET uuid = excluded.uuid, display_name = excluded.display_name, fetched_at = excluded.fetched_at
+	`, profile.Name, profile.UUID, profile.Name, profile.FetchedAt)
+	return err
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserQuota overrides the default quota settings for one user. Nil fields
+// fall back to the defaults; zero or empty values mean unlimited.
+type UserQuota struct {
+	UserID     int64     `json:"userId"`
+	MaxServers *int      `json:"maxServers"`
+	MaxMemory  *string   `json:"maxMemory"`
+	MaxDisk    *string   `json:"maxDisk"`
+	MaxRunning *int      `json:"maxRunning"`
+	MaxCPUs    *float64  `json:"maxCpus"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func (db *DB) GetUserQuota(userID int64) (*UserQuota, error) {
+	var quota UserQuota
+	var maxServers, maxRunning sql.NullInt64
+	var maxMemory, maxDisk sql.NullString
+	var maxCPUs sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT user_id, max_servers, max_memory, max_disk, max_running, max_cpus, updated_at
+		FROM user_quotas
+		WHERE user_id = ?
+	`, userID).Scan(&quota.UserID, &maxServers, &maxMemory, &maxDisk, &maxRunning, &maxCPUs, &quota.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if maxServers.Valid {
+		n := int(maxServers.Int64)
+		quota.MaxServers = &n
+	}
+	if maxMemory.Valid {
+		quota.MaxMemory = &maxMemory.String
+	}
+	if maxDisk.Valid {
+		quota.MaxDisk = &maxDisk.String
+	}
+	if maxRunning.Valid {
+		n := int(maxRunning.Int64)
+		quota.MaxRunning = &n
+	}
+	if maxCPUs.Valid {
+		quota.MaxCPUs = &maxCPUs.Float64
+	}
+	return &quota, nil
+}
+
+// SetUserQuota stores the overrides for a user, replacing any previous ones.
+func (db *DB) SetUserQuota(quota *UserQuota) error {
+	quota.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO user_quotas (user_id, max_servers, max_memory, max_disk, max_running, max_cpus, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			max_servers = excluded.max_servers,
+			max_memory = excluded.max_memory,
+			max_disk = excluded.max_disk,
+			max_running = excluded.max_running,
+			max_cpus = excluded.max_cpus,
+			updated_at = excluded.updated_at
+	`, quota.UserID, quota.MaxServers, quota.MaxMemory, quota.MaxDisk, quota.MaxRunning, quota.MaxCPUs, quota.UpdatedAt)
+	return err
+}
+
+func (db *DB) DeleteUserQuota(userID int64) error {
+	_, err := db.Exec(`DELETE FROM user_quotas WHERE user_id = ?`, userID)
+	return err
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Schedule run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+// Schedule run sources
+const (
+	RunScheduled = "schedule"
+	RunCatchUp   = "catch_up"
+	RunManual    = "manual"
+)
+
+// Schedule is a task run on a server on a cron schedule. Cron is evaluated in
+// Timezone; Warning is how many seconds players are warned before a restart
+// or stop, and CatchUp what happens to runs missed while the API was down.
+type Schedule struct {
+	ID        int64      `json:"id"`
+	ServerID  string     `json:"serverId"`
+	Name      string     `json:"name"`
+	Cron      string     `json:"cron"`
+	Timezone  string     `json:"timezone"`
+	Action    string     `json:"action"`
+	Commands  []string   `json:"commands"`
+	Warning   int        `json:"warning"`
+	CatchUp   string     `json:"catchUp"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	CreatedBy int64      `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// ScheduleRun is one run of a schedule. ScheduledAt is the time the run was
+// due, which for catch-up runs is before StartedAt.
+type ScheduleRun struct {
+	ID          int64      `json:"id"`
+	ScheduleID  int64      `json:"scheduleId"`
+	ServerID    string     `json:"serverId"`
+	Source      string     `json:"source"`
+	ScheduledAt time.Time  `json:"scheduledAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Status      string     `json:"status"`
+	Output      string     `json:"output,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+const scheduleColumns = `id, server_id, name, cron, timezone, action, commands, warning,
+	catch_up, enabled, next_run_at, last_run_at, created_by, created_at, updated_at`
+
+func scanSchedule(row rowScanner) (*Schedule, error) {
+	var s Schedule
+	var commands string
+	var nextRun, lastRun sql.NullTime
+	var createdBy sql.NullInt64
+	if err := row.Scan(
+		&s.ID, &s.ServerID, &s.Name, &s.Cron, &s.Timezone, &s.Action, &commands, &s.Warning,
+		&s.CatchUp, &s.Enabled, &nextRun, &lastRun, &createdBy, &s.CreatedAt, &s.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(commands), &s.Commands); err != nil || s.Commands == nil {
+		s.Commands = []string{}
+	}
+	if nextRun.Valid {
+		s.NextRunAt = &nextRun.Time
+	}
+	if lastRun.Valid {
+		s.LastRunAt = &lastRun.Time
+	}
+	s.CreatedBy = createdBy.Int64
+	return &s, nil
+}
+
+func (db *DB) CreateSchedule(s *Schedule) error {
+	commands, err := json.Marshal(s.Commands)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO schedules (server_id, name, cron, timezone, action, commands, warning,
+			catch_up, enabled, next_run_at, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.ServerID, s.Name, s.Cron, s.Timezone, s.Action, string(commands), s.Warning,
+		s.CatchUp, s.Enabled, nullTime(s.NextRunAt), nullOwner(s.CreatedBy), now, now)
+	if err != nil {
+		return err
+	}
+	if s.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	s.CreatedAt, s.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateSchedule saves a schedule's settings and next run.
+func (db *DB) UpdateSchedule(s *Schedule) error {
+	commands, err := json.Marshal(s.Commands)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := db.Exec(`
+		UPDATE schedules
+		SET name = ?, cron = ?, timezone = ?, action = ?, commands = ?, warning = ?,
+			catch_up = ?, enabled = ?, next_run_at = ?, updated_at = ?
+		WHERE id = ?
+	`, s.Name, s.Cron, s.Timezone, s.Action, string(commands), s.Warning,
+		s.CatchUp, s.Enabled, nullTime(s.NextRunAt), now, s.ID); err != nil {
+		return err
+	}
+	s.UpdatedAt = now
+	return nil
+}
+
+// AdvanceSchedule records when a schedule last ran and when it runs next.
+func (db *DB) AdvanceSchedule(id int64, lastRun, nextRun *time.Time) error {
+	_, err := db.Exec(`
+		UPDATE schedules SET last_run_at = COALESCE(?, last_run_at), next_run_at = ?
+		WHERE id = ?
+	`, nullTime(lastRun), nullTime(nextRun), id)
+	return err
+}
+
+func (db *DB) GetSchedule(id int64) (*Schedule, error) {
+	s, err := scanSchedule(db.QueryRow(`SELECT `+scheduleColumns+` FROM schedules WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// ListSchedules returns a server's schedules, or every schedule if serverID
+// is empty.
+func (db *DB) ListSchedules(serverID string) ([]Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules`
+	args := []interface{}{}
+	if serverID != "" {
+		query += ` WHERE server_id = ?`
+		args = append(args, serverID)
+	}
+	rows, err := db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, rows.Err()
+}
+
+// DeleteSchedule removes a schedule and its run history.
+func (db *DB) DeleteSchedule(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM schedule_runs WHERE schedule_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM schedules WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) CreateScheduleRun(run *ScheduleRun) error {
+	result, err := db.Exec(`
+		INSERT INTO schedule_runs (schedule_id, server_id, source, scheduled_at, started_at, finished_at, status, output, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.ScheduleID, run.ServerID, run.Source, run.ScheduledAt, nullTime(run.StartedAt),
+		nullTime(run.FinishedAt), run.Status, run.Output, run.Error)
+	if err != nil {
+		return err
+	}
+	run.ID, err = result.LastInsertId()
+	return err
+}
+
+// FinishScheduleRun saves the outcome of a run.
+func (db *DB) FinishScheduleRun(run *ScheduleRun) error {
+	_, err := db.Exec(`
+		UPDATE schedule_runs SET finished_at = ?, status = ?, output = ?, error = ?
+		WHERE id = ?
+	`, nullTime(run.FinishedAt), run.Status, run.Output, run.Error, run.ID)
+	return err
+}
+
+// ListScheduleRuns returns a schedule's most recent runs, newest first.
+func (db *DB) ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	rows, err := db.Query(`
+		SELECT id, schedule_id, server_id, source, scheduled_at, started_at, finished_at, status, output, error
+		FROM schedule_runs
+		WHERE schedule_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var run ScheduleRun
+		var startedAt, finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ServerID, &run.Source, &run.ScheduledAt,
+			&startedAt, &finishedAt, &run.Status, &run.Output, &run.Error); err != nil {
+			return nil, err
+		}
+		if startedAt.Valid {
+			run.StartedAt = &startedAt.Time
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// FailInterruptedScheduleRuns marks runs that were still going when the API
+// stopped as failed.
+func (db *DB) FailInterruptedScheduleRuns() error {
+	_, err := db.Exec(`
+		UPDATE schedule_runs
+		SET status = ?, error = 'interrupted by API restart', finished_at = ?
+		WHERE status = ?
+	`, RunFailed, time.Now(), RunRunning)
+	return err
+}
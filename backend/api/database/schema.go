@@ -13,6 +13,7 @@
     username TEXT UNIQUE NOT NULL,
     password_hash TEXT NOT NULL,
     api_key TEXT UNIQUE NOT NULL,
+    is_admin INTEGER NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     last_login DATETIME
 );
@@ -42,7 +43,168 @@
     player_name TEXT NOT NULL,
     join_time DATETIME NOT NULL,
     leave_time DATETIME,
-    duration INTEGER DEFAULT 0
+    duration INTEGER DEFAULT 0,
+    last_seen DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_player_sessions_player ON player_sessions(player_name COLLATE NOCASE, server_id);
+
+CREATE TABLE IF NOT EXISTS server_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    server_type TEXT NOT NULL DEFAULT 'VANILLA',
+    version TEXT NOT NULL DEFAULT 'LATEST',
+    memory TEXT NOT NULL DEFAULT '2G',
+    env TEXT NOT NULL DEFAULT '{}',
+    properties TEXT NOT NULL DEFAULT '{}',
+    datapacks TEXT NOT NULL DEFAULT '[]',
+    plugins TEXT NOT NULL DEFAULT '[]',
+    builtin INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS servers (
+    id TEXT PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    container_name TEXT UNIQUE NOT NULL,
+    owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    restart_required_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_quotas (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_servers INTEGER,
+    max_memory TEXT,
+    max_disk TEXT,
+    max_running INTEGER,
+    max_cpus REAL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS settings_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS port_allocations (
+    port INTEGER NOT NULL,
+    protocol TEXT NOT NULL DEFAULT 'tcp',
+    server_id TEXT NOT NULL,
+    allocated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (port, protocol)
+);
+
+CREATE TABLE IF NOT EXISTS networks (
+    id TEXT PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    proxy_type TEXT NOT NULL,
+    container_name TEXT UNIQUE NOT NULL,
+    docker_network TEXT NOT NULL,
+    forwarding_secret TEXT NOT NULL,
+    lobby_server_id TEXT,
+    owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS network_servers (
+    server_id TEXT PRIMARY KEY,
+    network_id TEXT NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    alias TEXT NOT NULL,
+    online_mode TEXT,
+    joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (network_id, alias)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_server_stats_server_id ON server_stats(server_id);
+
+CREATE TABLE IF NOT EXISTS server_upgrades (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id TEXT NOT NULL,
+    from_version TEXT NOT NULL,
+    to_version TEXT NOT NULL,
+    backup_path TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    server_id TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '{}',
+    remote_addr TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_server ON audit_log(server_id, id);
+
+CREATE TABLE IF NOT EXISTS player_profiles (
+    name TEXT PRIMARY KEY COLLATE NOCASE,
+    uuid TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    fetched_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    cron TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    action TEXT NOT NULL,
+    commands TEXT NOT NULL DEFAULT '[]',
+    warning INTEGER NOT NULL DEFAULT 0,
+    catch_up TEXT NOT NULL DEFAULT 'skip',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    next_run_at DATETIME,
+    last_run_at DATETIME,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedules_server ON schedules(server_id);
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    schedule_id INTEGER NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+    server_id TEXT NOT NULL,
+    source TEXT NOT NULL,
+    scheduled_at DATETIME NOT NULL,
+    started_at DATETIME,
+    finished_at DATETIME,
+    status TEXT NOT NULL,
+    output TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule ON schedule_runs(schedule_id, id);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    server_id TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    step TEXT NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    message TEXT NOT NULL DEFAULT '',
+    steps TEXT NOT NULL DEFAULT '[]',
+    result TEXT NOT NULL DEFAULT 'null',
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
 );
 `
 
@@ -65,13 +227,26 @@ func New(dbPath string) (*DB, error) {
         return nil, err
     }
 
-    return &DB{db}, nil
+    d := &DB{db}
+
+    // Add columns introduced after a table was first created
+    if err := d.migrate(); err != nil {
+        return nil, err
+    }
+
+    // Seed built-in server templates
+    if err := d.seedBuiltinTemplates(); err != nil {
+        return nil, err
+    }
+
+    return d, nil
 }
 
 type User struct {
     ID          int64
     Username    string
     APIKey      string
+    IsAdmin     bool
     CreatedAt   time.Time
     LastLogin   *time.Time
 }
@@ -95,10 +270,11 @@ type ServerStats struct {
 }
 
 type PlayerSession struct {
-    ID         int64
-    ServerID   string
-    PlayerName string
-    JoinTime   time.Time
-    LeaveTime  *time.Time
-    Duration   int64
+    ID         int64      `json:"id"`
+    ServerID   string     `json:"serverId"`
+    PlayerName string     `json:"playerName"`
+    JoinTime   time.Time  `json:"joinTime"`
+    LeaveTime  *time.Time `json:"leaveTime,omitempty"`
+    Duration   int64      `json:"duration"`
+    LastSeen   *time.Time `json:"lastSeen,omitempty"`
 } 
\ No newline at end of file
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Server links a stable server UUID to its mutable display name and the
+// Docker container (and data directory) that back it. OwnerID is the user
+// whose quota the server counts against, or 0 for servers without an owner.
+type Server struct {
+	ID            string
+	Name          string
+	ContainerName string
+	OwnerID       int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func NewServerID() (string, error) {
+	return newUUID()
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func (db *DB) CreateServer(server *Server) error {
+	now := time.Now()
+	_, err := db.Exec(`
+		INSERT INTO servers (id, name, container_name, owner_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, server.ID, server.Name, server.ContainerName, nullOwner(server.OwnerID), now, now)
+	if err != nil {
+		return err
+	}
+
+	server.CreatedAt = now
+	server.UpdatedAt = now
+	return db.CreateOrUpdateServerStats(server.ID, server.Name)
+}
+
+func nullOwner(ownerID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: ownerID, Valid: ownerID != 0}
+}
+
+const serverColumns = `id, name, container_name, owner_id, created_at, updated_at`
+
+func scanServerRow(row rowScanner) (*Server, error) {
+	var server Server
+	var ownerID sql.NullInt64
+	if err := row.Scan(&server.ID, &server.Name, &server.ContainerName, &ownerID, &server.CreatedAt, &server.UpdatedAt); err != nil {
+		return nil, err
+	}
+	server.OwnerID = ownerID.Int64
+	return &server, nil
+}
+
+func (db *DB) scanServer(row *sql.Row) (*Server, error) {
+	server, err := scanServerRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return server, err
+}
+
+func (db *DB) queryServers(query string, args ...interface{}) ([]Server, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []Server
+	for rows.Next() {
+		server, err := scanServerRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, *server)
+	}
+	return servers, rows.Err()
+}
+
+func (db *DB) GetServer(id string) (*Server, error) {
+	return db.scanServer(db.QueryRow(`
+		SELECT `+serverColumns+`
+		FROM servers
+		WHERE id = ?
+	`, id))
+}
+
+func (db *DB) GetServerByContainerName(containerName string) (*Server, error) {
+	return db.scanServer(db.QueryRow(`
+		SELECT `+serverColumns+`
+		FROM servers
+		WHERE container_name = ?
+	`, containerName))
+}
+
+func (db *DB) GetServerByName(name string) (*Server, error) {
+	return db.scanServer(db.QueryRow(`
+		SELECT `+serverColumns+`
+		FROM servers
+		WHERE name = ?
+	`, name))
+}
+
+func (db *DB) ListServers() ([]Server, error) {
+	return db.queryServers(`SELECT ` + serverColumns + ` FROM servers ORDER BY name`)
+}
+
+// ListServersByOwner returns the servers counted against a user's quota.
+func (db *DB) ListServersByOwner(ownerID int64) ([]Server, error) {
+	return db.queryServers(`SELECT `+serverColumns+` FROM servers WHERE owner_id = ? ORDER BY name`, ownerID)
+}
+
+// SetServerOwner assigns a server to a user, or clears the owner with 0.
+func (db *DB) SetServerOwner(id string, ownerID int64) error {
+	result, err := db.Exec(`UPDATE servers SET owner_id = ?, updated_at = ? WHERE id = ?`, nullOwner(ownerID), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RenameServer changes the display name of a server. The container name and
+// data directory are left untouched.
+func (db *DB) RenameServer(id, name string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE servers SET name = ?, updated_at = ? WHERE id = ?`, name, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`UPDATE server_stats SET server_name = ? WHERE server_id = ?`, name, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkRestartRequired records that a server's files changed in a way it
+// only picks up when it starts.
+func (db *DB) MarkRestartRequired(id string) error {
+	_, err := db.Exec(`UPDATE servers SET restart_required_at = ? WHERE id = ?`, time.Now(), id)
+	return err
+}
+
+// RestartRequiredAt returns when a server last needed a restart, or nil if
+// it never did. Starts after that time have picked the change up.
+func (db *DB) RestartRequiredAt(id string) (*time.Time, error) {
+	var at sql.NullTime
+	err := db.QueryRow(`SELECT restart_required_at FROM servers WHERE id = ?`, id).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil || !at.Valid {
+		return nil, err
+	}
+	return &at.Time, nil
+}
+
+// DeleteServer removes a server from the registry along with its schedules.
+func (db *DB) DeleteServer(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM schedule_runs WHERE server_id = ?`,
+		`DELETE FROM schedules WHERE server_id = ?`,
+		`DELETE FROM servers WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AdoptServerHistory re-keys stats and player sessions recorded under a
+// server's container name or container ID to its UUID. It is used when
+// migrating servers that predate stable IDs.
+func (db *DB) AdoptServerHistory(id string, legacyIDs ...string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, legacyID := range legacyIDs {
+		if legacyID == "" || legacyID == id {
+			continue
+		}
+		if _, err := tx.Exec(`
+			UPDATE OR IGNORE server_stats SET server_id = ? WHERE server_id = ?
+		`, id, legacyID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE player_sessions SET server_id = ? WHERE server_id = ?
+		`, id, legacyID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
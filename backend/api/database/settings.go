@@ -64,38 +64,3 @@ func (db *DB) DeleteSetting(key string) error {
     _, err := db.Exec(`DELETE FROM settings WHERE key = ?`, key)
     return err
 }
-
-// Helper functions for common settings
-
-func (db *DB) SetMaxServersPerUser(count int) error {
-    return db.SetSetting("max_servers_per_user", count)
-}
-
-func (db *DB) GetMaxServersPerUser(defaultValue int) (int, error) {
-    value, err := db.GetSettingValue("max_servers_per_user", defaultValue)
-    if err != nil {
-        return defaultValue, err
-    }
-
-    // Convert from float64 (JSON number) to int
-    if num, ok := value.(float64); ok {
-        return int(num), nil
-    }
-    return defaultValue, nil
-}
-
-func (db *DB) SetDefaultMemory(memory string) error {
-    return db.SetSetting("default_memory", memory)
-}
-
-func (db *DB) GetDefaultMemory(defaultValue string) (string, error) {
-    value, err := db.GetSettingValue("default_memory", defaultValue)
-    if err != nil {
-        return defaultValue, err
-    }
-
-    if str, ok := value.(string); ok {
-        return str, nil
-    }
-    return defaultValue, nil
-} 
\ No newline at end of file
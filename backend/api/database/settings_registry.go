@@ -0,0 +1,459 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Setting types
+const (
+	SettingInt    = "int"
+	SettingFloat  = "float"
+	SettingString = "string"
+	SettingSize   = "size"
+	SettingEnum   = "enum"
+)
+
+// SettingDefinition describes a known setting. Min and Max bound numeric
+// settings, Options lists the values of an enum and Optional allows an empty
+// string for sizes that are unlimited when unset.
+type SettingDefinition struct {
+	Key         string      `json:"key"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Default     interface{} `json:"default"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	Options     []string    `json:"options,omitempty"`
+	Optional    bool        `json:"optional,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"`
+}
+
+// SettingValue is a setting's definition with its current value.
+type SettingValue struct {
+	SettingDefinition
+	Value     interface{} `json:"value"`
+	IsDefault bool        `json:"isDefault"`
+	UpdatedAt *time.Time  `json:"updatedAt,omitempty"`
+}
+
+// SettingChange is an entry in the settings history. A nil value means the
+// setting was at its default.
+type SettingChange struct {
+	ID        int64       `json:"id"`
+	Key       string      `json:"key"`
+	OldValue  interface{} `json:"oldValue"`
+	NewValue  interface{} `json:"newValue"`
+	ChangedBy int64       `json:"changedBy,omitempty"`
+	ChangedAt time.Time   `json:"changedAt"`
+}
+
+// Registration modes
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+)
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+var settingDefinitions = []SettingDefinition{
+	{Key: "default_memory", Type: SettingSize, Default: "2G",
+		Description: "JVM heap for servers that don't specify one"},
+	{Key: "default_image_tag", Type: SettingString, Default: "latest", Pattern: `^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`,
+		Description: "Tag of the itzg/minecraft-server image used for new servers"},
+	{Key: "port_range_start", Type: SettingInt, Default: 25565, Min: bound(1024), Max: bound(65535),
+		Description: "First host port allocated to servers"},
+	{Key: "port_range_end", Type: SettingInt, Default: 25665, Min: bound(1024), Max: bound(65535),
+		Description: "Last host port allocated to servers"},
+	{Key: "bedrock_port_range_start", Type: SettingInt, Default: 19132, Min: bound(1024), Max: bound(65535),
+		Description: "First UDP host port allocated to Bedrock servers"},
+	{Key: "bedrock_port_range_end", Type: SettingInt, Default: 19232, Min: bound(1024), Max: bound(65535),
+		Description: "Last UDP host port allocated to Bedrock servers"},
+	{Key: "public_host", Type: SettingString, Default: "", Pattern: `^([A-Za-z0-9.-]{1,253})?$`,
+		Description: "Host name or IP players connect to, used in the addresses servers report"},
+	{Key: "registration_mode", Type: SettingEnum, Default: RegistrationOpen, Options: []string{RegistrationOpen, RegistrationClosed},
+		Description: "Whether anyone can register an account or only admins can create users"},
+	{Key: "backup_retention", Type: SettingInt, Default: 0, Min: bound(0),
+		Description: "Number of backups kept per server, 0 keeps all"},
+	{Key: "max_servers_per_user", Type: SettingInt, Default: 0, Min: bound(0),
+		Description: "Default maximum number of servers per user, 0 for unlimited"},
+	{Key: "max_running_per_user", Type: SettingInt, Default: 0, Min: bound(0),
+		Description: "Default maximum number of running servers per user, 0 for unlimited"},
+	{Key: "max_memory_per_user", Type: SettingSize, Default: "", Optional: true,
+		Description: "Default total container memory per user, empty for unlimited"},
+	{Key: "max_disk_per_user", Type: SettingSize, Default: "", Optional: true,
+		Description: "Default total disk for data and backups per user, empty for unlimited"},
+	{Key: "stop_timeout", Type: SettingInt, Default: 120, Min: bound(10), Max: bound(3600),
+		Description: "Seconds a server gets to save and exit after its stop command before it is killed. Servers with an always or unless-stopped restart policy, the default, are stopped through Docker instead of the console, with the same timeout"},
+	{Key: "default_restart_policy", Type: SettingEnum, Default: "unless-stopped", Options: []string{"never", "on-failure", "always", "unless-stopped"},
+		Description: "Restart policy of new servers, which brings them back after a crash or host reboot"},
+	{Key: "max_world_size", Type: SettingSize, Default: "10G",
+		Description: "Largest world archive that can be uploaded, measured unpacked"},
+	{Key: "max_memory_limit", Type: SettingSize, Default: "", Optional: true,
+		Description: "Maximum container memory limit of a server, empty for unlimited"},
+	{Key: "max_cpus", Type: SettingFloat, Default: 0, Min: bound(0),
+		Description: "Maximum CPUs per server, 0 for unlimited"},
+	{Key: "max_pids", Type: SettingInt, Default: 0, Min: bound(0),
+		Description: "Maximum processes per server container, 0 for unlimited"},
+}
+
+var sizePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[KkMmGg]?$`)
+
+// settingPatterns holds the compiled Pattern of each string setting.
+var settingPatterns = func() map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	for _, def := range settingDefinitions {
+		if def.Pattern != "" {
+			patterns[def.Key] = regexp.MustCompile(def.Pattern)
+		}
+	}
+	return patterns
+}()
+
+// SettingDefinitions returns the known settings sorted by key.
+func SettingDefinitions() []SettingDefinition {
+	defs := append([]SettingDefinition(nil), settingDefinitions...)
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+	return defs
+}
+
+func LookupSetting(key string) (SettingDefinition, bool) {
+	for _, def := range settingDefinitions {
+		if def.Key == key {
+			return def, true
+		}
+	}
+	return SettingDefinition{}, false
+}
+
+// Validate checks a JSON-decoded value against the definition and returns
+// it normalized to the setting's Go type (int, float64 or string).
+func (def SettingDefinition) Validate(value interface{}) (interface{}, error) {
+	switch def.Type {
+	case SettingInt, SettingFloat:
+		num, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a number", def.Key)
+		}
+		if def.Type == SettingInt && num != float64(int64(num)) {
+			return nil, fmt.Errorf("%s must be a whole number", def.Key)
+		}
+		if def.Min != nil && num < *def.Min {
+			return nil, fmt.Errorf("%s must be at least %g", def.Key, *def.Min)
+		}
+		if def.Max != nil && num > *def.Max {
+			return nil, fmt.Errorf("%s must be at most %g", def.Key, *def.Max)
+		}
+		if def.Type == SettingInt {
+			return int(num), nil
+		}
+		return num, nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a string", def.Key)
+	}
+	switch def.Type {
+	case SettingSize:
+		if str == "" && def.Optional {
+			return str, nil
+		}
+		if !sizePattern.MatchString(str) {
+			return nil, fmt.Errorf("%s must be a size such as 2G or 512M", def.Key)
+		}
+	case SettingEnum:
+		for _, option := range def.Options {
+			if str == option {
+				return str, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be one of %v", def.Key, def.Options)
+	default:
+		if pattern := settingPatterns[def.Key]; pattern != nil && !pattern.MatchString(str) {
+			return nil, fmt.Errorf("%s has an invalid format", def.Key)
+		}
+	}
+	return str, nil
+}
+
+// ListSettingValues returns every known setting with its current value.
+func (db *DB) ListSettingValues() ([]SettingValue, error) {
+	values := []SettingValue{}
+	for _, def := range SettingDefinitions() {
+		value, err := db.settingValue(def)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, *value)
+	}
+	return values, nil
+}
+
+func (db *DB) GetSettingValueOf(key string) (*SettingValue, error) {
+	def, ok := LookupSetting(key)
+	if !ok {
+		return nil, fmt.Errorf("unknown setting %s", key)
+	}
+	return db.settingValue(def)
+}
+
+func (db *DB) settingValue(def SettingDefinition) (*SettingValue, error) {
+	value := &SettingValue{SettingDefinition: def, Value: def.Default, IsDefault: true}
+
+	setting, err := db.GetSetting(def.Key)
+	if err != nil {
+		return nil, err
+	}
+	if setting == nil {
+		return value, nil
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(setting.Value), &raw); err != nil {
+		return nil, err
+	}
+	normalized, err := def.Validate(raw)
+	if err != nil {
+		// Hand-edited rows that no longer validate fall back to the default
+		return value, nil
+	}
+	value.Value = normalized
+	value.IsDefault = false
+	value.UpdatedAt = &setting.UpdatedAt
+	return value, nil
+}
+
+// ValidateSettings checks a set of JSON-decoded values and returns them
+// normalized, as UpdateSettings would store them.
+func (db *DB) ValidateSettings(values map[string]interface{}) (map[string]interface{}, error) {
+	normalized := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		def, ok := LookupSetting(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+		v, err := def.Validate(value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[key] = v
+	}
+
+	for _, prefix := range []string{"port_range", "bedrock_port_range"} {
+		if err := db.validatePortRange(normalized, prefix); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+// UpdateSettings validates and stores several settings at once, recording
+// each change in the history. Nothing is stored if any value is invalid.
+func (db *DB) UpdateSettings(values map[string]interface{}, changedBy int64) error {
+	normalized, err := db.ValidateSettings(values)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for key, value := range normalized {
+		old, err := settingJSON(tx, key)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if old.Valid && old.String == string(data) {
+			continue
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO settings (key, value, updated_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET
+				value = excluded.value,
+				updated_at = excluded.updated_at
+		`, key, string(data), now); err != nil {
+			return err
+		}
+		if err := recordSettingChange(tx, key, old, sql.NullString{String: string(data), Valid: true}, changedBy, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ResetSetting removes a stored value so the default applies again.
+func (db *DB) ResetSetting(key string, changedBy int64) error {
+	if _, ok := LookupSetting(key); !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := settingJSON(tx, key)
+	if err != nil {
+		return err
+	}
+	if !old.Valid {
+		return nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM settings WHERE key = ?`, key); err != nil {
+		return err
+	}
+	if err := recordSettingChange(tx, key, old, sql.NullString{}, changedBy, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// validatePortRange checks the <prefix>_start and <prefix>_end port range
+// as it would be after the update.
+func (db *DB) validatePortRange(updates map[string]interface{}, prefix string) error {
+	startKey, endKey := prefix+"_start", prefix+"_end"
+	_, hasStart := updates[startKey]
+	_, hasEnd := updates[endKey]
+	if !hasStart && !hasEnd {
+		return nil
+	}
+
+	start, end := db.SettingInt(startKey), db.SettingInt(endKey)
+	if v, ok := updates[startKey]; ok {
+		start = v.(int)
+	}
+	if v, ok := updates[endKey]; ok {
+		end = v.(int)
+	}
+	if start > end {
+		return fmt.Errorf("%s (%d) must not be after %s (%d)", startKey, start, endKey, end)
+	}
+	return nil
+}
+
+func settingJSON(tx *sql.Tx, key string) (sql.NullString, error) {
+	var value sql.NullString
+	err := tx.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return sql.NullString{}, nil
+	}
+	return value, err
+}
+
+func recordSettingChange(tx *sql.Tx, key string, oldValue, newValue sql.NullString, changedBy int64, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO settings_history (key, old_value, new_value, changed_by, changed_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, key, oldValue, newValue, nullOwner(changedBy), at)
+	return err
+}
+
+// ListSettingHistory returns recent setting changes, optionally for one key.
+func (db *DB) ListSettingHistory(key string, limit int) ([]SettingChange, error) {
+	query := `SELECT id, key, old_value, new_value, changed_by, changed_at FROM settings_history`
+	args := []interface{}{}
+	if key != "" {
+		query += ` WHERE key = ?`
+		args = append(args, key)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []SettingChange{}
+	for rows.Next() {
+		var change SettingChange
+		var oldValue, newValue sql.NullString
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&change.ID, &change.Key, &oldValue, &newValue, &changedBy, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		change.OldValue = decodeSettingJSON(oldValue)
+		change.NewValue = decodeSettingJSON(newValue)
+		change.ChangedBy = changedBy.Int64
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func decodeSettingJSON(value sql.NullString) interface{} {
+	if !value.Valid {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value.String), &decoded); err != nil {
+		return value.String
+	}
+	return decoded
+}
+
+// SettingInt returns the current value of a known int setting, falling back
+// to its default if it can't be read.
+func (db *DB) SettingInt(key string) int {
+	switch v := db.setting(key).(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// SettingFloat returns the current value of a known numeric setting.
+func (db *DB) SettingFloat(key string) float64 {
+	switch v := db.setting(key).(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// SettingString returns the current value of a known string, size or enum
+// setting.
+func (db *DB) SettingString(key string) string {
+	if v, ok := db.setting(key).(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (db *DB) setting(key string) interface{} {
+	def, ok := LookupSetting(key)
+	if !ok {
+		log.Printf("Reading unknown setting %s", key)
+		return nil
+	}
+	value, err := db.settingValue(def)
+	if err != nil {
+		return def.Default
+	}
+	return value.Value
+}
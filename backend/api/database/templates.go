@@ -0,0 +1,232 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ServerTemplate is a named preset used to create servers with the same
+// type, version, memory, environment and initial content.
+type ServerTemplate struct {
+	ID          int64             `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Type        string            `json:"type"`
+	Version     string            `json:"version"`
+	Memory      string            `json:"memory"`
+	Env         map[string]string `json:"env"`
+	Properties  map[string]string `json:"properties"`
+	Datapacks   []string          `json:"datapacks"`
+	Plugins     []string          `json:"plugins"`
+	Builtin     bool              `json:"builtin"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+var builtinTemplates = []ServerTemplate{
+	{
+		Name:        "Vanilla Survival",
+		Description: "Plain vanilla server in survival mode",
+		Type:        "VANILLA",
+		Version:     "LATEST",
+		Memory:      "2G",
+		Env:         map[string]string{"DIFFICULTY": "normal", "MODE": "survival"},
+		Properties:  map[string]string{"spawn-protection": "16"},
+	},
+	{
+		Name:        "Paper SMP",
+		Description: "Paper server tuned for a small survival multiplayer community",
+		Type:        "PAPER",
+		Version:     "LATEST",
+		Memory:      "4G",
+		Env:         map[string]string{"DIFFICULTY": "normal", "MODE": "survival", "USE_AIKAR_FLAGS": "true"},
+		Properties:  map[string]string{"simulation-distance": "8"},
+	},
+	{
+		Name:        "Fabric Creative",
+		Description: "Fabric server in creative mode with peaceful mobs",
+		Type:        "FABRIC",
+		Version:     "LATEST",
+		Memory:      "3G",
+		Env:         map[string]string{"DIFFICULTY": "peaceful", "MODE": "creative"},
+		Properties:  map[string]string{"allow-flight": "true"},
+	},
+}
+
+func (db *DB) seedBuiltinTemplates() error {
+	for _, t := range builtinTemplates {
+		t.Builtin = true
+		env, properties, datapacks, plugins, err := marshalTemplateFields(&t)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`
+			INSERT OR IGNORE INTO server_templates
+				(name, description, server_type, version, memory, env, properties, datapacks, plugins, builtin)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		`, t.Name, t.Description, t.Type, t.Version, t.Memory, env, properties, datapacks, plugins)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalTemplateFields(t *ServerTemplate) (env, properties, datapacks, plugins string, err error) {
+	fields := []interface{}{t.Env, t.Properties, t.Datapacks, t.Plugins}
+	out := make([]string, len(fields))
+	for i, field := range fields {
+		b, err := json.Marshal(field)
+		if err != nil {
+			return "", "", "", "", err
+		}
+		out[i] = string(b)
+	}
+	return out[0], out[1], out[2], out[3], nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplate(row rowScanner) (*ServerTemplate, error) {
+	var t ServerTemplate
+	var env, properties, datapacks, plugins string
+	if err := row.Scan(
+		&t.ID, &t.Name, &t.Description, &t.Type, &t.Version, &t.Memory,
+		&env, &properties, &datapacks, &plugins, &t.Builtin, &t.CreatedAt, &t.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(env), &t.Env); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(properties), &t.Properties); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(datapacks), &t.Datapacks); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(plugins), &t.Plugins); err != nil {
+		return nil, err
+	}
+
+	if t.Env == nil {
+		t.Env = map[string]string{}
+	}
+	if t.Properties == nil {
+		t.Properties = map[string]string{}
+	}
+	if t.Datapacks == nil {
+		t.Datapacks = []string{}
+	}
+	if t.Plugins == nil {
+		t.Plugins = []string{}
+	}
+	return &t, nil
+}
+
+const templateColumns = `id, name, description, server_type, version, memory,
+	env, properties, datapacks, plugins, builtin, created_at, updated_at`
+
+func (db *DB) ListTemplates() ([]ServerTemplate, error) {
+	rows, err := db.Query(`SELECT ` + templateColumns + ` FROM server_templates ORDER BY builtin DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []ServerTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+func (db *DB) GetTemplate(id int64) (*ServerTemplate, error) {
+	t, err := scanTemplate(db.QueryRow(`SELECT `+templateColumns+` FROM server_templates WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (db *DB) CreateTemplate(t *ServerTemplate) error {
+	env, properties, datapacks, plugins, err := marshalTemplateFields(t)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO server_templates
+			(name, description, server_type, version, memory, env, properties, datapacks, plugins, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.Name, t.Description, t.Type, t.Version, t.Memory, env, properties, datapacks, plugins, now, now)
+	if err != nil {
+		return err
+	}
+
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.Builtin = false
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	return nil
+}
+
+func (db *DB) UpdateTemplate(t *ServerTemplate) error {
+	env, properties, datapacks, plugins, err := marshalTemplateFields(t)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`
+		UPDATE server_templates
+		SET name = ?, description = ?, server_type = ?, version = ?, memory = ?,
+		    env = ?, properties = ?, datapacks = ?, plugins = ?, updated_at = ?
+		WHERE id = ?
+	`, t.Name, t.Description, t.Type, t.Version, t.Memory, env, properties, datapacks, plugins, now, t.ID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	t.UpdatedAt = now
+	return nil
+}
+
+func (db *DB) DeleteTemplate(id int64) error {
+	result, err := db.Exec(`DELETE FROM server_templates WHERE id = ? AND builtin = 0`, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Upgrade statuses
+const (
+	UpgradeBackingUp  = "backing_up"
+	UpgradeStarting   = "starting"
+	UpgradeSucceeded  = "succeeded"
+	UpgradeFailed     = "failed"
+	UpgradeRolledBack = "rolled_back"
+)
+
+type ServerUpgrade struct {
+	ID          int64      `json:"id"`
+	ServerID    string     `json:"serverId"`
+	FromVersion string     `json:"fromVersion"`
+	ToVersion   string     `json:"toVersion"`
+	BackupPath  string     `json:"backupPath"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+}
+
+func (db *DB) CreateUpgrade(upgrade *ServerUpgrade) error {
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO server_upgrades (server_id, from_version, to_version, status, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, upgrade.ServerID, upgrade.FromVersion, upgrade.ToVersion, upgrade.Status, now)
+	if err != nil {
+		return err
+	}
+
+	upgrade.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	upgrade.CreatedAt = now
+	return nil
+}
+
+// UpdateUpgrade stores the upgrade's status, backup path and error. Terminal
+// statuses also record the finish time.
+func (db *DB) UpdateUpgrade(upgrade *ServerUpgrade) error {
+	var finishedAt *time.Time
+	switch upgrade.Status {
+	case UpgradeSucceeded, UpgradeFailed, UpgradeRolledBack:
+		now := time.Now()
+		finishedAt = &now
+	}
+
+	_, err := db.Exec(`
+		UPDATE server_upgrades
+		SET backup_path = ?, status = ?, error = ?, finished_at = ?
+		WHERE id = ?
+	`, upgrade.BackupPath, upgrade.Status, upgrade.Error, finishedAt, upgrade.ID)
+	if err != nil {
+		return err
+	}
+	upgrade.FinishedAt = finishedAt
+	return nil
+}
+
+func scanUpgrade(row rowScanner) (*ServerUpgrade, error) {
+	var upgrade ServerUpgrade
+	var finishedAt sql.NullTime
+	if err := row.Scan(
+		&upgrade.ID, &upgrade.ServerID, &upgrade.FromVersion, &upgrade.ToVersion,
+		&upgrade.BackupPath, &upgrade.Status, &upgrade.Error, &upgrade.CreatedAt, &finishedAt,
+	); err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		upgrade.FinishedAt = &finishedAt.Time
+	}
+	return &upgrade, nil
+}
+
+func (db *DB) GetUpgrade(id int64) (*ServerUpgrade, error) {
+	upgrade, err := scanUpgrade(db.QueryRow(`
+		SELECT id, server_id, from_version, to_version, backup_path, status, error, created_at, finished_at
+		FROM server_upgrades
+		WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return upgrade, err
+}
+
+func (db *DB) ListUpgrades(serverID string) ([]ServerUpgrade, error) {
+	rows, err := db.Query(`
+		SELECT id, server_id, from_version, to_version, backup_path, status, error, created_at, finished_at
+		FROM server_upgrades
+		WHERE server_id = ?
+		ORDER BY created_at DESC
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	upgrades := []ServerUpgrade{}
+	for rows.Next() {
+		upgrade, err := scanUpgrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		upgrades = append(upgrades, *upgrade)
+	}
+	return upgrades, rows.Err()
+}
+
+// GetActiveUpgrade returns the upgrade currently in progress for a server, if any.
+func (db *DB) GetActiveUpgrade(serverID string) (*ServerUpgrade, error) {
+	upgrade, err := scanUpgrade(db.QueryRow(`
+		SELECT id, server_id, from_version, to_version, backup_path, status, error, created_at, finished_at
+		FROM server_upgrades
+		WHERE server_id = ? AND status IN (?, ?)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, serverID, UpgradeBackingUp, UpgradeStarting))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return upgrade, err
+}
+
+// FailInterruptedUpgrades marks upgrades that were in progress when the API
+// stopped as failed so they can be rolled back.
+func (db *DB) FailInterruptedUpgrades() error {
+	_, err := db.Exec(`
+		UPDATE server_upgrades
+		SET status = ?, error = 'interrupted by API restart', finished_at = ?
+		WHERE status IN (?, ?)
+	`, UpgradeFailed, time.Now(), UpgradeBackingUp, UpgradeStarting)
+	return err
+}
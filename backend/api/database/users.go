@@ -17,7 +17,17 @@ func generateAPIKey() (string, error) {
     return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// CreateUser adds a user. The first user becomes an admin.
 func (db *DB) CreateUser(username, password string) (*User, error) {
+    return db.createUser(username, password, false)
+}
+
+// CreateAdmin adds a user with admin rights.
+func (db *DB) CreateAdmin(username, password string) (*User, error) {
+    return db.createUser(username, password, true)
+}
+
+func (db *DB) createUser(username, password string, admin bool) (*User, error) {
     // Hash password
     hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
     if err != nil {
@@ -32,8 +42,9 @@ func (db *DB) CreateUser(username, password string) (*User, error) {
 
     // Insert user
     result, err := db.Exec(
-        `INSERT INTO users (username, password_hash, api_key) VALUES (?, ?, ?)`,
-        username, string(hash), apiKey,
+        `INSERT INTO users (username, password_hash, api_key, is_admin)
+        VALUES (?, ?, ?, ? OR NOT EXISTS (SELECT 1 FROM users))`,
+        username, string(hash), apiKey, admin,
     )
     if err != nil {
         return nil, err
@@ -44,12 +55,7 @@ func (db *DB) CreateUser(username, password string) (*User, error) {
         return nil, err
     }
 
-    return &User{
-        ID:        id,
-        Username:  username,
-        APIKey:    apiKey,
-        CreatedAt: time.Now(),
-    }, nil
+    return db.GetUserByID(id)
 }
 
 func (db *DB) GetUserByAPIKey(apiKey string) (*User, error) {
@@ -57,9 +63,32 @@ func (db *DB) GetUserByAPIKey(apiKey string) (*User, error) {
     var lastLogin sql.NullTime
 
     err := db.QueryRow(
-        `SELECT id, username, api_key, created_at, last_login FROM users WHERE api_key = ?`,
+        `SELECT id, username, api_key, is_admin, created_at, last_login FROM users WHERE api_key = ?`,
         apiKey,
-    ).Scan(&user.ID, &user.Username, &user.APIKey, &user.CreatedAt, &lastLogin)
+    ).Scan(&user.ID, &user.Username, &user.APIKey, &user.IsAdmin, &user.CreatedAt, &lastLogin)
+
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if lastLogin.Valid {
+        user.LastLogin = &lastLogin.Time
+    }
+
+    return &user, nil
+}
+
+func (db *DB) GetUserByID(id int64) (*User, error) {
+    var user User
+    var lastLogin sql.NullTime
+
+    err := db.QueryRow(
+        `SELECT id, username, api_key, is_admin, created_at, last_login FROM users WHERE id = ?`,
+        id,
+    ).Scan(&user.ID, &user.Username, &user.APIKey, &user.IsAdmin, &user.CreatedAt, &lastLogin)
 
     if err == sql.ErrNoRows {
         return nil, nil
@@ -81,9 +110,9 @@ func (db *DB) AuthenticateUser(username, password string) (*User, error) {
     var lastLogin sql.NullTime
 
     err := db.QueryRow(
-        `SELECT id, username, password_hash, api_key, created_at, last_login FROM users WHERE username = ?`,
+        `SELECT id, username, password_hash, api_key, is_admin, created_at, last_login FROM users WHERE username = ?`,
         username,
-    ).Scan(&user.ID, &user.Username, &passwordHash, &user.APIKey, &user.CreatedAt, &lastLogin)
+    ).Scan(&user.ID, &user.Username, &passwordHash, &user.APIKey, &user.IsAdmin, &user.CreatedAt, &lastLogin)
 
     if err == sql.ErrNoRows {
         return nil, nil
@@ -132,6 +161,17 @@ func (db *DB) UpdateUserAPIKey(userID int64) (string, error) {
 }
 
 func (db *DB) DeleteUser(id int64) error {
+	// Foreign keys aren't enforced, so release the user's servers, networks and quota
+	if _, err := db.Exec("UPDATE servers SET owner_id = NULL WHERE owner_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE networks SET owner_id = NULL WHERE owner_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM user_quotas WHERE user_id = ?", id); err != nil {
+		return err
+	}
+
 	result, err := db.Exec("DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		return err
@@ -149,7 +189,7 @@ func (db *DB) DeleteUser(id int64) error {
 }
 
 func (db *DB) ListUsers() ([]User, error) {
-	rows, err := db.Query("SELECT id, username, api_key, created_at, last_login FROM users")
+	rows, err := db.Query("SELECT id, username, api_key, is_admin, created_at, last_login FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +199,7 @@ func (db *DB) ListUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var lastLogin sql.NullTime
-		if err := rows.Scan(&user.ID, &user.Username, &user.APIKey, &user.CreatedAt, &lastLogin); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.APIKey, &user.IsAdmin, &user.CreatedAt, &lastLogin); err != nil {
 			return nil, err
 		}
 		if lastLogin.Valid {
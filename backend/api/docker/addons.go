@@ -0,0 +1,645 @@
+package docker
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Loaders a plugin or mod can be built for
+const (
+	LoaderBukkit   = "bukkit"
+	LoaderPaper    = "paper"
+	LoaderFabric   = "fabric"
+	LoaderQuilt    = "quilt"
+	LoaderForge    = "forge"
+	LoaderNeoForge = "neoforge"
+	LoaderSponge   = "sponge"
+)
+
+// Folders under /data that servers load addons from. Jars moved into the
+// disabled folder inside them are ignored by every loader.
+const (
+	pluginsDir  = "plugins"
+	modsDir     = "mods"
+	disabledDir = "disabled"
+)
+
+// maxUnpackedSize caps the jars unpacked from an uploaded zip, together, so
+// a small zip can't expand to fill the disk.
+const maxUnpackedSize = 256 << 20
+
+// maxListedJar caps a jar read into memory while listing a volume's addons,
+// which can't be read in place.
+const maxListedJar = 512 << 20
+
+// typeLoaders lists the loaders each server type can run addons for. Types
+// missing here, such as CUSTOM, aren't checked.
+var typeLoaders = map[string][]string{
+	"VANILLA":       {},
+	"PAPER":         {LoaderBukkit, LoaderPaper},
+	"PURPUR":        {LoaderBukkit, LoaderPaper},
+	"FOLIA":         {LoaderBukkit, LoaderPaper},
+	"SPIGOT":        {LoaderBukkit},
+	"BUKKIT":        {LoaderBukkit},
+	"FABRIC":        {LoaderFabric},
+	"QUILT":         {LoaderQuilt, LoaderFabric},
+	"FORGE":         {LoaderForge},
+	"NEOFORGE":      {LoaderNeoForge, LoaderForge},
+	"MAGMA":         {LoaderForge, LoaderBukkit},
+	"MOHIST":        {LoaderForge, LoaderBukkit},
+	"SPONGEVANILLA": {LoaderSponge},
+}
+
+// Addon is a plugin or mod jar in a server's plugins or mods folder, with
+// what its metadata says about it. Compatible is false when none of its
+// loaders can run on the server type; Warning explains why.
+type Addon struct {
+	File        string   `json:"file"`
+	Path        string   `json:"path"`
+	ID          string   `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Loaders     []string `json:"loaders"`
+	Enabled     bool     `json:"enabled"`
+	Size        int64    `json:"size"`
+	Compatible  bool     `json:"compatible"`
+	Warning     string   `json:"warning,omitempty"`
+}
+
+// addonServer is what addon operations need to know about a server.
+type addonServer struct {
+	id         string
+	ownerID    int64
+	serverType string
+	storage    Storage
+}
+
+func (m *Manager) addonServer(ctx context.Context, serverID string) (*addonServer, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		return nil, &catalog.ValidationError{Message: "Bedrock Edition servers don't support plugins or mods"}
+	}
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return nil, err
+	}
+	return &addonServer{
+		id:         record.ID,
+		ownerID:    record.OwnerID,
+		serverType: catalog.NormalizeType(envValue(inspect.Config.Env, "TYPE")),
+		storage:    storage,
+	}, nil
+}
+
+// ListAddons returns the plugins and mods installed on a server, enabled or
+// not.
+func (m *Manager) ListAddons(ctx context.Context, serverID string) ([]Addon, error) {
+	server, err := m.addonServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return m.listAddons(ctx, server)
+}
+
+func (m *Manager) listAddons(ctx context.Context, server *addonServer) ([]Addon, error) {
+	addons := []Addon{}
+	for _, dir := range []string{pluginsDir, modsDir} {
+		err := m.walkDataFiles(ctx, server.storage, dir, func(p string, size int64, r io.Reader) error {
+			enabled := path.Dir(p) == dir
+			if !strings.HasSuffix(p, ".jar") || (!enabled && path.Dir(p) != dir+"/"+disabledDir) {
+				return nil
+			}
+			// Jars on the host are read in place, so only their metadata
+			// is read; a volume's arrive as one stream
+			ra, ok := r.(io.ReaderAt)
+			if !ok {
+				content, err := io.ReadAll(io.LimitReader(r, maxListedJar))
+				if err != nil {
+					return err
+				}
+				ra = bytes.NewReader(content)
+			}
+			addon := readAddon(path.Base(p), ra, size)
+			addon.Path = p
+			addon.Enabled = enabled
+			checkAddon(&addon, server.serverType, dir)
+			addons = append(addons, addon)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", dir, err)
+		}
+	}
+	return addons, nil
+}
+
+// InstallAddon adds an uploaded plugin or mod jar, or every jar in an
+// uploaded zip, to the folder its loader reads. A jar replaces one with the
+// same file name and older versions of the same plugin or mod. Jars whose
+// loader doesn't match the server type are refused unless force is set.
+// The upload holds size bytes.
+func (m *Manager) InstallAddon(ctx context.Context, serverID, fileName string, upload io.ReaderAt, size int64, force bool) ([]Addon, error) {
+	server, err := m.addonServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	var jars []addonJar
+	switch {
+	case strings.HasSuffix(strings.ToLower(fileName), ".zip"):
+		var cleanup func()
+		jars, cleanup, err = packJars(upload, size)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+	case validAddonFile(fileName):
+		jars = []addonJar{{name: fileName, r: upload, size: size}}
+	default:
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("%s is not a .jar or .zip file", fileName)}
+	}
+
+	installs := make([]Addon, len(jars))
+	var total int64
+	for i, jar := range jars {
+		addon := readAddon(jar.name, jar.r, jar.size)
+		if len(addon.Loaders) == 0 {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("%s is not a plugin or mod: %s", jar.name, addon.Warning)}
+		}
+		dir := addonDir(addon.Loaders)
+		checkAddon(&addon, server.serverType, dir)
+		if !addon.Compatible && !force {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("%s: %s", jar.name, addon.Warning)}
+		}
+		addon.Path = dir + "/" + jar.name
+		addon.Enabled = true
+		installs[i] = addon
+		total += jar.size
+	}
+	defer m.lockOwner(server.ownerID)()
+	if server.ownerID != 0 {
+		if err := m.CheckQuota(ctx, server.ownerID, QuotaRequest{DiskBytes: total}); err != nil {
+			return nil, err
+		}
+	}
+
+	installed, err := m.listAddons(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	for i, addon := range installs {
+		// Drop older copies so the loader doesn't see the same ID twice
+		for _, old := range installed {
+			if old.Path != addon.Path && (old.File == addon.File || (addon.ID != "" && old.ID == addon.ID && sameLoaders(old.Loaders, addon.Loaders))) {
+				log.Printf("Replacing %s with %s on server %s", old.Path, addon.Path, server.id)
+				if err := m.removeDataFile(ctx, server.storage, old.Path); err != nil {
+					return nil, fmt.Errorf("failed to remove %s: %v", old.Path, err)
+				}
+			}
+		}
+
+		log.Printf("Installing %s (%s %s) on server %s", addon.Path, addon.Name, addon.Version, server.id)
+		jar := jars[i]
+		if err := m.writeDataStream(ctx, server.storage, addon.Path, io.NewSectionReader(jar.r, 0, jar.size), jar.size); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", addon.Path, err)
+		}
+	}
+
+	m.markRestartRequired(server.id)
+	return installs, nil
+}
+
+// SetAddonEnabled moves an addon into or out of the disabled folder.
+func (m *Manager) SetAddonEnabled(ctx context.Context, serverID, file string, enabled bool) (*Addon, error) {
+	server, addon, err := m.findAddon(ctx, serverID, file)
+	if err != nil {
+		return nil, err
+	}
+	if addon.Enabled == enabled {
+		return addon, nil
+	}
+
+	dir := strings.TrimSuffix(path.Dir(addon.Path), "/"+disabledDir)
+	target := dir + "/" + addon.File
+	if !enabled {
+		target = dir + "/" + disabledDir + "/" + addon.File
+	}
+	log.Printf("Moving %s to %s on server %s", addon.Path, target, server.id)
+	if err := m.moveDataFile(ctx, server.storage, addon.Path, target); err != nil {
+		return nil, fmt.Errorf("failed to move %s: %v", addon.Path, err)
+	}
+
+	addon.Path = target
+	addon.Enabled = enabled
+	m.markRestartRequired(server.id)
+	return addon, nil
+}
+
+// DeleteAddon removes an addon jar. Files the addon created, such as its
+// config folder, are left alone.
+func (m *Manager) DeleteAddon(ctx context.Context, serverID, file string) error {
+	server, addon, err := m.findAddon(ctx, serverID, file)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleting %s from server %s", addon.Path, server.id)
+	if err := m.removeDataFile(ctx, server.storage, addon.Path); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", addon.Path, err)
+	}
+	m.markRestartRequired(server.id)
+	return nil
+}
+
+// AddonNotFoundError is returned for addon files a server doesn't have.
+type AddonNotFoundError struct {
+	File string
+}
+
+func (e *AddonNotFoundError) Error() string {
+	return fmt.Sprintf("addon %s not found", e.File)
+}
+
+func (m *Manager) findAddon(ctx context.Context, serverID, file string) (*addonServer, *Addon, error) {
+	if !validAddonFile(file) {
+		return nil, nil, &catalog.ValidationError{Message: fmt.Sprintf("invalid addon file name %q", file)}
+	}
+	server, err := m.addonServer(ctx, serverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	addons, err := m.listAddons(ctx, server)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range addons {
+		if addons[i].File == file {
+			return server, &addons[i], nil
+		}
+	}
+	return nil, nil, &AddonNotFoundError{File: file}
+}
+
+// markRestartRequired flags a server whose addons changed; running servers
+// only load addons when they start.
+func (m *Manager) markRestartRequired(serverID string) {
+	if err := m.db.MarkRestartRequired(serverID); err != nil {
+		log.Printf("Error flagging server %s for restart: %v", serverID, err)
+	}
+}
+
+// restartRequired reports whether a running server started before its last
+// addon change.
+func (m *Manager) restartRequired(serverID string, inspect types.ContainerJSON) bool {
+	if inspect.State == nil || !inspect.State.Running {
+		return false
+	}
+	changedAt, err := m.db.RestartRequiredAt(serverID)
+	if err != nil || changedAt == nil {
+		return false
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil {
+		return false
+	}
+	return changedAt.After(startedAt)
+}
+
+// validAddonFile accepts plain jar file names without any path.
+func validAddonFile(name string) bool {
+	return strings.HasSuffix(name, ".jar") && len(name) > len(".jar") &&
+		!strings.ContainsAny(name, "/\\") && !strings.HasPrefix(name, ".")
+}
+
+// addonJar is an uploaded jar waiting to be installed.
+type addonJar struct {
+	name string
+	r    io.ReaderAt
+	size int64
+}
+
+// packJars extracts the jars from an uploaded zip, wherever they are in it,
+// one at a time into temporary files that cleanup removes. Zips that unpack
+// to more than maxUnpackedSize are refused.
+func packJars(upload io.ReaderAt, size int64) (jars []addonJar, cleanup func(), err error) {
+	zr, err := zip.NewReader(upload, size)
+	if err != nil {
+		return nil, nil, &catalog.ValidationError{Message: fmt.Sprintf("invalid zip file: %v", err)}
+	}
+
+	dir, err := os.MkdirTemp("", "mboxmini-addons-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unpack zip: %v", err)
+	}
+	var files []*os.File
+	cleanup = func() {
+		for _, f := range files {
+			f.Close()
+		}
+		os.RemoveAll(dir)
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	tooLarge := &catalog.ValidationError{Message: fmt.Sprintf("zip unpacks to more than %d MB of jars", maxUnpackedSize>>20)}
+	seen := map[string]bool{}
+	var unpacked uint64
+	for _, f := range zr.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || !validAddonFile(name) {
+			continue
+		}
+		if seen[name] {
+			return nil, nil, &catalog.ValidationError{Message: fmt.Sprintf("zip contains %s more than once", name)}
+		}
+		seen[name] = true
+		if f.UncompressedSize64 > maxUnpackedSize-unpacked {
+			return nil, nil, tooLarge
+		}
+
+		out, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unpack %s: %v", f.Name, err)
+		}
+		files = append(files, out)
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, &catalog.ValidationError{Message: fmt.Sprintf("failed to read %s: %v", f.Name, err)}
+		}
+		// The sizes in the zip can lie, so the copy is capped as well
+		n, err := io.Copy(out, io.LimitReader(rc, int64(maxUnpackedSize-unpacked)+1))
+		rc.Close()
+		if err != nil {
+			return nil, nil, &catalog.ValidationError{Message: fmt.Sprintf("failed to read %s: %v", f.Name, err)}
+		}
+		unpacked += uint64(n)
+		if unpacked > maxUnpackedSize {
+			return nil, nil, tooLarge
+		}
+		jars = append(jars, addonJar{name: name, r: out, size: n})
+	}
+	if len(jars) == 0 {
+		return nil, nil, &catalog.ValidationError{Message: "zip contains no .jar files"}
+	}
+	return jars, cleanup, nil
+}
+
+// addonDir is the folder jars for the given loaders are loaded from.
+func addonDir(loaders []string) string {
+	for _, loader := range loaders {
+		if loader == LoaderBukkit || loader == LoaderPaper {
+			return pluginsDir
+		}
+	}
+	return modsDir
+}
+
+// checkAddon sets Compatible and Warning for an addon found in dir on a
+// server of serverType.
+func checkAddon(addon *Addon, serverType, dir string) {
+	addon.Compatible = true
+	if len(addon.Loaders) == 0 {
+		return
+	}
+
+	if supported, ok := typeLoaders[serverType]; ok {
+		compatible := false
+		for _, loader := range addon.Loaders {
+			for _, s := range supported {
+				compatible = compatible || loader == s
+			}
+		}
+		if !compatible {
+			addon.Compatible = false
+			addon.Warning = fmt.Sprintf("built for %s, which a %s server can't load", strings.Join(addon.Loaders, "/"), serverType)
+			return
+		}
+	}
+	if want := addonDir(addon.Loaders); want != dir {
+		addon.Compatible = false
+		addon.Warning = fmt.Sprintf("in %s/ but %s addons are loaded from %s/", dir, strings.Join(addon.Loaders, "/"), want)
+	}
+}
+
+func sameLoaders(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// readAddon reads a jar's plugin or mod metadata. Only the metadata entries
+// are read, up to 1 MB each. Jars without any leave Loaders empty and explain
+// why in Warning.
+func readAddon(file string, jar io.ReaderAt, size int64) Addon {
+	addon := Addon{File: file, Name: strings.TrimSuffix(file, ".jar"), Size: size, Loaders: []string{}}
+
+	zr, err := zip.NewReader(jar, size)
+	if err != nil {
+		addon.Warning = "not a valid jar file"
+		return addon
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) string {
+		f, ok := files[name]
+		if !ok {
+			return ""
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return ""
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	// A jar can carry metadata for several loaders; the first one found
+	// names the addon
+	found := func(loader, id, name, version, description string) {
+		addon.Loaders = append(addon.Loaders, loader)
+		if len(addon.Loaders) > 1 {
+			return
+		}
+		addon.ID, addon.Version, addon.Description = id, version, description
+		if name != "" {
+			addon.Name = name
+		} else if id != "" {
+			addon.Name = id
+		}
+	}
+
+	if doc := read("paper-plugin.yml"); doc != "" {
+		name := yamlTopLevel(doc, "name")
+		found(LoaderPaper, strings.ToLower(name), name, yamlTopLevel(doc, "version"), yamlTopLevel(doc, "description"))
+	}
+	if doc := read("plugin.yml"); doc != "" {
+		name := yamlTopLevel(doc, "name")
+		found(LoaderBukkit, strings.ToLower(name), name, yamlTopLevel(doc, "version"), yamlTopLevel(doc, "description"))
+	}
+	if doc := read("fabric.mod.json"); doc != "" {
+		var meta struct {
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			Version     string `json:"version"`
+			Description string `json:"description"`
+		}
+		if json.Unmarshal([]byte(doc), &meta) == nil {
+			found(LoaderFabric, meta.ID, meta.Name, meta.Version, meta.Description)
+		}
+	}
+	if doc := read("quilt.mod.json"); doc != "" {
+		var meta struct {
+			QuiltLoader struct {
+				ID       string `json:"id"`
+				Version  string `json:"version"`
+				Metadata struct {
+					Name        string `json:"name"`
+					Description string `json:"description"`
+				} `json:"metadata"`
+			} `json:"quilt_loader"`
+		}
+		if json.Unmarshal([]byte(doc), &meta) == nil {
+			q := meta.QuiltLoader
+			found(LoaderQuilt, q.ID, q.Metadata.Name, q.Version, q.Metadata.Description)
+		}
+	}
+	for _, toml := range []struct{ file, loader string }{
+		{"META-INF/neoforge.mods.toml", LoaderNeoForge},
+		{"META-INF/mods.toml", LoaderForge},
+	} {
+		if doc := read(toml.file); doc != "" {
+			mod := modsTOML(doc)
+			version := mod["version"]
+			if version == "" || strings.HasPrefix(version, "${") {
+				version = manifestValue(read("META-INF/MANIFEST.MF"), "Implementation-Version")
+			}
+			found(toml.loader, mod["modId"], mod["displayName"], version, mod["description"])
+		}
+	}
+	if doc := read("mcmod.info"); doc != "" {
+		// Forge before 1.13; either a list of mods or {"modList": [...]}
+		var mods []struct {
+			ModID       string `json:"modid"`
+			Name        string `json:"name"`
+			Version     string `json:"version"`
+			Description string `json:"description"`
+		}
+		if json.Unmarshal([]byte(doc), &mods) != nil {
+			var wrapped struct {
+				ModList json.RawMessage `json:"modList"`
+			}
+			if json.Unmarshal([]byte(doc), &wrapped) == nil {
+				json.Unmarshal(wrapped.ModList, &mods)
+			}
+		}
+		if len(mods) > 0 && !containsString(addon.Loaders, LoaderForge) {
+			found(LoaderForge, mods[0].ModID, mods[0].Name, mods[0].Version, mods[0].Description)
+		}
+	}
+	if doc := read("META-INF/sponge_plugins.json"); doc != "" {
+		var meta struct {
+			Plugins []struct {
+				ID          string `json:"id"`
+				Name        string `json:"name"`
+				Version     string `json:"version"`
+				Description string `json:"description"`
+			} `json:"plugins"`
+		}
+		if json.Unmarshal([]byte(doc), &meta) == nil && len(meta.Plugins) > 0 {
+			p := meta.Plugins[0]
+			found(LoaderSponge, p.ID, p.Name, p.Version, p.Description)
+		}
+	}
+
+	if len(addon.Loaders) == 0 {
+		addon.Warning = "no plugin.yml, fabric.mod.json, quilt.mod.json or mods.toml found"
+	}
+	return addon
+}
+
+// modsTOML reads the string values of the first [[mods]] table of a Forge
+// mods.toml, including multi-line strings.
+func modsTOML(doc string) map[string]string {
+	values := map[string]string{}
+	inMods := false
+	scanner := bufio.NewScanner(strings.NewReader(doc))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			if inMods {
+				break
+			}
+			inMods = line == "[[mods]]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !inMods || !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, "'''") || strings.HasPrefix(value, `"""`) {
+			quote := value[:3]
+			text := strings.TrimPrefix(value, quote)
+			for !strings.Contains(text, quote) && scanner.Scan() {
+				text += "\n" + scanner.Text()
+			}
+			text, _, _ = strings.Cut(text, quote)
+			values[key] = strings.TrimSpace(text)
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+				values[key] = value[1 : end+1]
+			}
+		}
+	}
+	return values
+}
+
+// manifestValue reads a main attribute from a jar manifest.
+func manifestValue(manifest, key string) string {
+	for _, line := range strings.Split(manifest, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), key+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Backup is a gzipped tarball of a server's data directory.
+type Backup struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListBackups returns the backups of a server, newest first.
+func (m *Manager) ListBackups(ctx context.Context, serverID string) ([]Backup, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	dir, err := m.backupDir(strings.TrimPrefix(inspect.Name, "/"))
+	if err != nil {
+		// Without a backup location there is nothing to list
+		return []Backup{}, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %v", err)
+	}
+
+	backups := []Backup{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tar.gz") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Name:      entry.Name(),
+			Path:      filepath.Join(dir, entry.Name()),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime(),
+		})
+	}
+	return backups, nil
+}
+
+// errNoBackupPath is returned for backups when neither BACKUP_PATH nor
+// DATA_PATH gives them a place on disk.
+var errNoBackupPath = errors.New("no backup location: set BACKUP_PATH to a directory mounted into the API container")
+
+// defaultBackupPath keeps backups beside the servers' data directories when
+// there are any.
+func defaultBackupPath(dataPath string) string {
+	if dataPath == "" {
+		return ""
+	}
+	return filepath.Join(dataPath, "backups")
+}
+
+// SetBackupPath sets the directory backups and archived worlds are written
+// to, instead of the backups folder under the data path. Deployments that
+// keep server data only in volumes need one.
+func (m *Manager) SetBackupPath(path string) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory %s: %v", path, err)
+	}
+	m.backupPath = path
+	return nil
+}
+
+// backupDir is where backups for a server container are stored.
+func (m *Manager) backupDir(containerName string) (string, error) {
+	if m.backupPath == "" {
+		return "", errNoBackupPath
+	}
+	return filepath.Join(m.backupPath, containerName), nil
+}
+
+// preUpgradeLabel starts the label of the backups upgrades take, which
+// pruning keeps for rollbacks.
+const preUpgradeLabel = "pre-upgrade-"
+
+var backupLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ValidateBackupLabel checks a label given to a backup. Labels end up in
+// the archive's file name, and the pre-upgrade marker is kept for upgrades.
+func ValidateBackupLabel(label string) error {
+	if label == "" {
+		return nil
+	}
+	if !backupLabelPattern.MatchString(label) || strings.Contains(label, "..") {
+		return &catalog.ValidationError{Message: fmt.Sprintf("invalid backup label %q: use up to 64 letters, digits, dots, dashes and underscores", label)}
+	}
+	if strings.Contains(strings.ToLower(label), strings.TrimSuffix(preUpgradeLabel, "-")) {
+		return &catalog.ValidationError{Message: "backup labels can't contain pre-upgrade, which marks the backups upgrades take"}
+	}
+	return nil
+}
+
+// BackupServer archives the server's data directory. If the server is
+// running its saves are flushed first and autosave is paused until the
+// archive is written.
+func (m *Manager) BackupServer(ctx context.Context, serverID, label string, progress Progress) (*Backup, error) {
+	if err := ValidateBackupLabel(label); err != nil {
+		return nil, err
+	}
+	return m.backupServer(ctx, serverID, label, progress)
+}
+
+func (m *Manager) backupServer(ctx context.Context, serverID, label string, progress Progress) (*Backup, error) {
+	progress = orNoProgress(progress)
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	if inspect.State.Running {
+		progress.Step("flush saves")
+		if err := m.flushSaves(ctx, inspect); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := m.resumeSaves(context.Background(), inspect); err != nil {
+				log.Printf("Error re-enabling saves on %s: %v", containerName, err)
+			}
+		}()
+	}
+
+	dir, err := m.backupDir(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	now := time.Now()
+	name := now.Format("20060102-150405")
+	if label != "" {
+		name += "-" + label
+	}
+	name += ".tar.gz"
+	archivePath := filepath.Join(dir, name)
+
+	progress.Step("archive data directory")
+	log.Printf("Backing up %s to %s", storage.Source, archivePath)
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %v", err)
+	}
+	if err := m.archiveData(ctx, storage, f); err != nil {
+		f.Close()
+		os.Remove(archivePath)
+		return nil, fmt.Errorf("failed to write backup: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(archivePath)
+		return nil, fmt.Errorf("failed to write backup: %v", err)
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Backup of %s completed (%d bytes)", containerName, info.Size())
+	m.pruneBackups(dir)
+	return &Backup{Name: name, Path: archivePath, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// pruneBackups deletes the oldest backups in dir beyond the backup_retention
+// setting. Pre-upgrade backups are kept since rollbacks depend on them.
+func (m *Manager) pruneBackups(dir string) {
+	retention := m.db.SettingInt("backup_retention")
+	if retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading backups in %s: %v", dir, err)
+		return
+	}
+
+	// Names start with a timestamp, so ReadDir's order is oldest first
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".tar.gz") || strings.Contains(name, "-"+preUpgradeLabel) {
+			continue
+		}
+		backups = append(backups, name)
+	}
+
+	for len(backups) > retention {
+		path := filepath.Join(dir, backups[0])
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error pruning backup %s: %v", path, err)
+		} else {
+			log.Printf("Pruned backup %s", path)
+		}
+		backups = backups[1:]
+	}
+}
+
+// restoreBackup replaces the contents of the server's storage with the
+// archive. The server must be stopped.
+func (m *Manager) restoreBackup(ctx context.Context, storage Storage, archivePath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	if err := m.clearData(ctx, storage); err != nil {
+		return err
+	}
+
+	if err := m.extractData(ctx, storage, f); err != nil {
+		return fmt.Errorf("failed to extract backup: %v", err)
+	}
+	return nil
+}
+
+// writeTarGz writes the contents of src as a gzipped tarball with paths
+// relative to src.
+func writeTarGz(ctx context.Context, src string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || info.Name() == "session.lock" {
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractTarGz unpacks a gzipped tarball into dst, rejecting entries that
+// would escape it.
+func extractTarGz(r io.Reader, dst string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := safeJoin(dst, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("invalid symlink in archive: %s", header.Name)
+			}
+			if _, err := safeJoin(dst, filepath.Join(filepath.Dir(header.Name), header.Linkname)); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// safeJoin joins name onto base and fails if the result is outside base.
+func safeJoin(base, name string) (string, error) {
+	target := filepath.Join(base, filepath.FromSlash(name))
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in archive: %s", name)
+	}
+	return target, nil
+}
@@ -0,0 +1,261 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Minecraft editions a server can run
+const (
+	EditionJava    = "java"
+	EditionBedrock = "bedrock"
+)
+
+// bedrockImageRepo runs Bedrock Dedicated Server. Its tags don't follow the
+// Java image's, so it is always used at latest and VERSION picks the server.
+const bedrockImageRepo = "itzg/minecraft-bedrock-server"
+
+// editionLabel records the edition on containers we create. Older containers
+// are Java servers, which editionOf also infers from the image.
+const editionLabel = "mboxmini.edition"
+
+// bedrockPort is the Bedrock game port inside server containers.
+const bedrockPort = nat.Port("19132/udp")
+
+// bedrockPingTimeout bounds each RakNet ping attempt.
+const bedrockPingTimeout = 2 * time.Second
+
+// bedrockSaveHoldDelay is how long flushSaves waits after save hold before
+// the world files are copied.
+const bedrockSaveHoldDelay = 5 * time.Second
+
+// raknetMagic is the offline message ID every unconnected RakNet packet carries.
+var raknetMagic = []byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78}
+
+// bedrockVersionPattern matches the versions the Bedrock image accepts
+// besides LATEST and PREVIEW, e.g. 1.20.81.01.
+var bedrockVersionPattern = regexp.MustCompile(`^\d+(\.\d+){1,3}$`)
+
+// ValidateEdition checks an edition name. Empty means Java.
+func ValidateEdition(edition string) error {
+	switch edition {
+	case "", EditionJava, EditionBedrock:
+		return nil
+	}
+	return &catalog.ValidationError{Message: fmt.Sprintf("unknown edition %q (expected %s or %s)", edition, EditionJava, EditionBedrock)}
+}
+
+// editionOf reports the edition of a server container from its label,
+// falling back to the image for containers created before editions existed.
+func editionOf(image string, labels map[string]string) string {
+	if edition := labels[editionLabel]; edition != "" {
+		return edition
+	}
+	if strings.HasPrefix(image, bedrockImageRepo) {
+		return EditionBedrock
+	}
+	return EditionJava
+}
+
+// editionPort is the game port an edition's container listens on.
+func editionPort(edition string) nat.Port {
+	if edition == EditionBedrock {
+		return bedrockPort
+	}
+	return gamePort
+}
+
+// portRangeKeys are the settings bounding the host ports of an edition.
+func portRangeKeys(edition string) (string, string) {
+	if edition == EditionBedrock {
+		return "bedrock_port_range_start", "bedrock_port_range_end"
+	}
+	return "port_range_start", "port_range_end"
+}
+
+// validateBedrockConfig checks the parts of a Bedrock config that the Java
+// version catalog would otherwise cover.
+func validateBedrockConfig(cfg ServerConfig) error {
+	if cfg.Type != "" {
+		return &catalog.ValidationError{Message: "type is not supported for Bedrock servers"}
+	}
+	if len(cfg.Datapacks) > 0 || len(cfg.Plugins) > 0 {
+		return &catalog.ValidationError{Message: "datapacks and plugins are not supported for Bedrock servers"}
+	}
+	switch strings.ToUpper(cfg.Version) {
+	case "", "LATEST", "PREVIEW":
+		return nil
+	}
+	if !bedrockVersionPattern.MatchString(cfg.Version) {
+		return &catalog.ValidationError{Message: fmt.Sprintf("invalid Bedrock version %q (expected LATEST, PREVIEW or a version such as 1.20.81.01)", cfg.Version)}
+	}
+	return nil
+}
+
+// bedrockEnv builds the itzg/minecraft-bedrock-server environment. The image
+// has no CUSTOM_SERVER_PROPERTIES; each server.properties key is its own
+// variable, e.g. level-name becomes LEVEL_NAME.
+func (cfg ServerConfig) bedrockEnv() []string {
+	vars := map[string]string{
+		"SERVER_NAME": cfg.Name,
+	}
+	for key, value := range cfg.Env {
+		vars[key] = value
+	}
+	for key, value := range cfg.Properties {
+		vars[strings.ToUpper(strings.ReplaceAll(key, "-", "_"))] = value
+	}
+	if cfg.ViewDistance > 0 {
+		vars["VIEW_DISTANCE"] = strconv.Itoa(cfg.ViewDistance)
+	}
+	delete(vars, "EULA")
+	delete(vars, "VERSION")
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	version := cfg.Version
+	if version == "" {
+		version = "LATEST"
+	}
+	env := []string{"EULA=TRUE", fmt.Sprintf("VERSION=%s", version)}
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, vars[key]))
+	}
+	return env
+}
+
+// BedrockStatus is what a Bedrock server reports in its unconnected pong.
+type BedrockStatus struct {
+	MOTD       string
+	Protocol   int
+	Version    string
+	Players    int
+	MaxPlayers int
+	LevelName  string
+	GameMode   string
+}
+
+// pingBedrock sends a RakNet unconnected ping to addr and parses the pong.
+func pingBedrock(ctx context.Context, addr string) (*BedrockStatus, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(bedrockPingTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	var ping bytes.Buffer
+	ping.WriteByte(0x01)
+	binary.Write(&ping, binary.BigEndian, time.Now().UnixMilli())
+	ping.Write(raknetMagic)
+	binary.Write(&ping, binary.BigEndian, rand.Int63())
+	if _, err := conn.Write(ping.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send ping: %v", err)
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("no pong from %s: %v", addr, err)
+	}
+	return parseBedrockPong(buf[:n])
+}
+
+// parseBedrockPong decodes an unconnected pong: ID 0x1c, ping time, server
+// GUID, magic and a length-prefixed "MCPE;motd;protocol;version;..." string.
+func parseBedrockPong(pong []byte) (*BedrockStatus, error) {
+	const header = 1 + 8 + 8 + 16
+	if len(pong) < header+2 || pong[0] != 0x1c {
+		return nil, fmt.Errorf("invalid pong")
+	}
+	if !bytes.Equal(pong[17:header], raknetMagic) {
+		return nil, fmt.Errorf("invalid pong magic")
+	}
+	length := int(binary.BigEndian.Uint16(pong[header:]))
+	if len(pong) < header+2+length {
+		return nil, fmt.Errorf("truncated pong")
+	}
+
+	fields := strings.Split(string(pong[header+2:header+2+length]), ";")
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("unexpected pong data %q", fields)
+	}
+	status := &BedrockStatus{MOTD: fields[1], Version: fields[3]}
+	status.Protocol, _ = strconv.Atoi(fields[2])
+	status.Players, _ = strconv.Atoi(fields[4])
+	status.MaxPlayers, _ = strconv.Atoi(fields[5])
+	if len(fields) > 7 {
+		status.LevelName = fields[7]
+	}
+	if len(fields) > 8 {
+		status.GameMode = fields[8]
+	}
+	return status, nil
+}
+
+// bedrockStatus pings a running Bedrock container, first on its address on
+// the Docker network and then through the published host port for APIs that
+// run outside Docker.
+func (m *Manager) bedrockStatus(ctx context.Context, inspect types.ContainerJSON) (*BedrockStatus, error) {
+	var addrs []string
+	if inspect.NetworkSettings != nil {
+		for _, network := range inspect.NetworkSettings.Networks {
+			if network.IPAddress != "" {
+				addrs = append(addrs, net.JoinHostPort(network.IPAddress, bedrockPort.Port()))
+			}
+		}
+		if port := hostPort(inspect.NetworkSettings.Ports, bedrockPort); port != 0 {
+			addrs = append(addrs, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		}
+	}
+
+	lastErr := fmt.Errorf("container has no reachable address")
+	for _, addr := range addrs {
+		status, err := pingBedrock(ctx, addr)
+		if err == nil {
+			return status, nil
+		}
+		log.Printf("Bedrock ping to %s failed: %v", addr, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// addBedrockStatus fills in the player count and, for servers tracking
+// LATEST or PREVIEW, the version actually running.
+func (m *Manager) addBedrockStatus(info *ServerInfo, inspect types.ContainerJSON) {
+	status, err := m.bedrockStatus(context.Background(), inspect)
+	if err != nil {
+		log.Printf("Error pinging Bedrock server %s: %v", info.Name, err)
+		return
+	}
+	info.PlayerCount = status.Players
+	info.MaxPlayers = status.MaxPlayers
+	if version := strings.ToUpper(info.Version); version == "LATEST" || version == "PREVIEW" {
+		info.Version = status.Version
+	}
+}
@@ -0,0 +1,277 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// ValidateClone checks that a server can be cloned as newName so callers can
+// reject a clone up front. CloneServer runs the same checks.
+func (m *Manager) ValidateClone(ctx context.Context, sourceID, newName string) error {
+	if err := ValidateServerName(newName); err != nil {
+		return err
+	}
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(sourceID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect source container: %v", err)
+	}
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return err
+	}
+
+	// A network member trusts whoever connects, as it expects only its proxy
+	// to reach it, so a copy of it must not get a public port
+	if member, err := m.db.GetServerNetwork(record.ID); err != nil {
+		return err
+	} else if member != nil {
+		return &catalog.ValidationError{Message: fmt.Sprintf("server %s is in a network; remove it from the network before cloning it", record.Name)}
+	}
+
+	if existing, err := m.db.GetServerByName(newName); err != nil {
+		return err
+	} else if existing != nil {
+		return &catalog.ValidationError{Message: fmt.Sprintf("a server named %s already exists", newName)}
+	}
+	return m.checkContainerName(ctx, ContainerName(newName))
+}
+
+// CloneServer copies the source server's data into new storage for
+// mboxmini-<newName> and creates a container with the same image
+// and environment on a freshly allocated port. If the source is running its
+// saves are flushed first and autosave is paused while the copy runs. The
+// clone is owned by ownerID and must fit in their quota.
+func (m *Manager) CloneServer(ctx context.Context, sourceID, newName string, ownerID int64, start bool, progress Progress) (string, error) {
+	progress = orNoProgress(progress)
+	log.Printf("Cloning server %s as %s", sourceID, newName)
+
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(sourceID))
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect source container: %v", err)
+	}
+	sourceName := strings.TrimPrefix(inspect.Name, "/")
+	if !strings.HasPrefix(sourceName, "mboxmini-") {
+		return "", fmt.Errorf("container %s is not a MboxMini server", sourceName)
+	}
+
+	if err := m.ValidateClone(ctx, sourceID, newName); err != nil {
+		return "", err
+	}
+
+	serverID := ContainerName(newName)
+	source, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate source data: %v", err)
+	}
+
+	// The clone counts against the user cloning it
+	defer m.lockOwner(ownerID)()
+	if ownerID != 0 {
+		req := QuotaRequest{
+			Servers:     1,
+			MemoryBytes: m.containerMemory(inspect),
+			DiskBytes:   storageSize(source, m.volumeSizes(ctx)),
+		}
+		if start {
+			req.Running = 1
+		}
+		if err := m.CheckQuota(ctx, ownerID, req); err != nil {
+			return "", err
+		}
+	}
+
+	uuid, err := database.NewServerID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate server ID: %v", err)
+	}
+
+	if inspect.State.Running {
+		progress.Step("flush saves")
+		if err := m.flushSaves(ctx, inspect); err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := m.resumeSaves(context.Background(), inspect); err != nil {
+				log.Printf("Error re-enabling saves on %s: %v", sourceName, err)
+			}
+		}()
+	}
+
+	// The clone uses the same kind of storage as its source
+	progress.Step("copy data directory")
+	storage, err := m.provisionData(ctx, serverID, uuid, source.Type)
+	if err != nil {
+		return "", err
+	}
+	cleanup := func() {
+		if err := m.removeData(context.Background(), storage); err != nil {
+			log.Printf("Error removing data of failed clone %s: %v", serverID, err)
+		}
+	}
+	log.Printf("Copying %s to %s", source.Source, storage.Source)
+	if err := m.copyData(ctx, source, storage); err != nil {
+		cleanup()
+		return "", fmt.Errorf("failed to copy server data: %v", err)
+	}
+
+	progress.Step("create container")
+	edition := editionOf(inspect.Config.Image, inspect.Config.Labels)
+	port, err := m.allocatePort(ctx, uuid, edition, 0)
+	if err != nil {
+		cleanup()
+		return "", err
+	}
+	log.Printf("Allocated port: %d", port)
+	releasePorts := func() {
+		if err := m.db.ReleaseServerPorts(uuid); err != nil {
+			log.Printf("Error releasing ports of %s: %v", serverID, err)
+		}
+	}
+	ports := map[nat.Port]int{editionPort(edition): port}
+
+	// A crossplay clone gets its own Bedrock port and a Geyser config to match
+	if hasCrossplay(edition, inspect.HostConfig.PortBindings) {
+		bedrockHostPort, err := m.allocatePort(ctx, uuid, EditionBedrock, 0)
+		if err != nil {
+			releasePorts()
+			cleanup()
+			return "", err
+		}
+		ports[bedrockPort] = bedrockHostPort
+		if err := m.writeGeyserConfig(ctx, storage, bedrockHostPort); err != nil {
+			releasePorts()
+			cleanup()
+			return "", err
+		}
+	}
+
+	if err := m.createServerContainer(ctx, serverID, inspect.Config.Image, inspect.Config.Env, storage, inspect.HostConfig.Resources, inspect.HostConfig.RestartPolicy, edition, ports, uuid); err != nil {
+		releasePorts()
+		cleanup()
+		return "", err
+	}
+
+	if err := m.db.CreateServer(&database.Server{ID: uuid, Name: newName, ContainerName: serverID, OwnerID: ownerID}); err != nil {
+		m.client.ContainerRemove(ctx, serverID, types.ContainerRemoveOptions{Force: true})
+		releasePorts()
+		cleanup()
+		return "", fmt.Errorf("failed to register server: %v", err)
+	}
+
+	if start {
+		progress.Step("start container")
+		log.Printf("Starting container %s", serverID)
+		if err := m.client.ContainerStart(ctx, serverID, types.ContainerStartOptions{}); err != nil {
+			return "", fmt.Errorf("failed to start cloned container: %v", err)
+		}
+	}
+
+	log.Printf("Server %s cloned successfully as %s", sourceName, serverID)
+	return serverID, nil
+}
+
+// flushSaves disables autosave and forces the world to be written to disk so
+// the data directory can be copied consistently. Callers must run
+// resumeSaves when they are done.
+func (m *Manager) flushSaves(ctx context.Context, inspect types.ContainerJSON) error {
+	serverID := inspect.ID
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		// send-command has no output to poll save query with, so give the
+		// server a moment to finish writing
+		if _, err := m.ExecuteCommand(ctx, serverID, "save hold"); err != nil {
+			return fmt.Errorf("failed to hold saves: %v", err)
+		}
+		select {
+		case <-time.After(bedrockSaveHoldDelay):
+		case <-ctx.Done():
+			m.ExecuteCommand(context.Background(), serverID, "save resume")
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	if _, err := m.ExecuteCommand(ctx, serverID, "save-off"); err != nil {
+		return fmt.Errorf("failed to disable saves: %v", err)
+	}
+	if _, err := m.ExecuteCommand(ctx, serverID, "save-all flush"); err != nil {
+		m.ExecuteCommand(ctx, serverID, "save-on")
+		return fmt.Errorf("failed to flush saves: %v", err)
+	}
+	return nil
+}
+
+// resumeSaves re-enables saving after flushSaves.
+func (m *Manager) resumeSaves(ctx context.Context, inspect types.ContainerJSON) error {
+	command := "save-on"
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		command = "save resume"
+	}
+	_, err := m.ExecuteCommand(ctx, inspect.ID, command)
+	return err
+}
+
+// copyDir recursively copies src to dst, preserving file modes and symlinks.
+// The world's session.lock is skipped since it belongs to the running source.
+func copyDir(ctx context.Context, src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		case info.Name() == "session.lock":
+			return nil
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"strings"
+)
+
+// setYAMLValue sets the scalar at path in a block-style YAML document such
+// as the configs Paper and Spigot write, keeping everything else, comments
+// included, as it is. Missing keys are appended to their parent mapping.
+// value must already be a valid YAML scalar.
+func setYAMLValue(doc string, path []string, value string) string {
+	lines := strings.Split(strings.TrimRight(doc, "\n"), "\n")
+	if doc == "" {
+		lines = nil
+	}
+
+	start, end, parentIndent := 0, len(lines), -1
+	for depth, key := range path {
+		indent := childIndent(lines[start:end], parentIndent)
+		found := -1
+		for i := start; i < end; i++ {
+			if lineIndent(lines[i]) == indent && strings.HasPrefix(strings.TrimSpace(lines[i]), key+":") {
+				found = i
+				break
+			}
+		}
+
+		if found < 0 {
+			// Insert the rest of the path at the end of the parent mapping,
+			// before any comments that lead into what follows it
+			at := end
+			for at > start && !isYAMLContent(lines[at-1]) && lineIndent(lines[at-1]) < indent {
+				at--
+			}
+			var insert []string
+			for j, k := range path[depth:] {
+				line := strings.Repeat(" ", indent+2*j) + k + ":"
+				if depth+j == len(path)-1 {
+					line += " " + value
+				}
+				insert = append(insert, line)
+			}
+			lines = append(lines[:at], append(insert, lines[at:]...)...)
+			break
+		}
+
+		if depth == len(path)-1 {
+			lines[found] = strings.Repeat(" ", indent) + key + ": " + value + inlineComment(lines[found])
+			break
+		}
+
+		// Narrow the search to the lines nested under this key
+		start, parentIndent = found+1, indent
+		for end = start; end < len(lines); end++ {
+			if isYAMLContent(lines[end]) && lineIndent(lines[end]) <= indent {
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// childIndent is the indentation of the keys of a mapping whose lines are
+// given, or two more than its parent's for an empty mapping.
+func childIndent(lines []string, parentIndent int) int {
+	for _, line := range lines {
+		if isYAMLContent(line) {
+			return lineIndent(line)
+		}
+	}
+	if parentIndent < 0 {
+		return 0
+	}
+	return parentIndent + 2
+}
+
+// inlineComment returns the comment after a key's unquoted scalar, with the
+// space before it, or "".
+func inlineComment(line string) string {
+	_, value, _ := strings.Cut(line, ":")
+	if i := strings.Index(value, " #"); i >= 0 && !strings.ContainsAny(value[:i], `'"`) {
+		return value[i:]
+	}
+	return ""
+}
+
+func isYAMLContent(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
+
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// yamlString quotes s as a single-quoted YAML scalar.
+func yamlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// yamlTopLevel reads a top-level scalar from a YAML document such as a
+// plugin.yml, unquoting it. Missing keys and nested values read as "".
+func yamlTopLevel(doc, key string) string {
+	for _, line := range strings.Split(doc, "\n") {
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(line, key+":"))
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value
+	}
+	return ""
+}
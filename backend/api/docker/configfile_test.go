@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetYAMLValue(t *testing.T) {
+	lines := func(l ...string) string { return strings.Join(l, "\n") + "\n" }
+	tests := []struct {
+		name  string
+		doc   string
+		path  string
+		value string
+		want  string
+	}{
+		{
+			name:  "empty document",
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  velocity:", "    enabled: true"),
+		},
+		{
+			name:  "existing scalar",
+			doc:   lines("proxies:", "  velocity:", "    enabled: false", "    secret: ''"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  velocity:", "    enabled: true", "    secret: ''"),
+		},
+		{
+			name:  "top-level scalar",
+			doc:   lines("online-mode: true", "port: 25577"),
+			path:  "online-mode",
+			value: "false",
+			want:  lines("online-mode: false", "port: 25577"),
+		},
+		{
+			name:  "missing key in existing mapping",
+			doc:   lines("proxies:", "  velocity:", "    enabled: false", "settings:", "  debug: false"),
+			path:  "proxies.velocity.secret",
+			value: "'abc'",
+			want:  lines("proxies:", "  velocity:", "    enabled: false", "    secret: 'abc'", "settings:", "  debug: false"),
+		},
+		{
+			name:  "missing nested mappings",
+			doc:   lines("settings:", "  debug: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("settings:", "  debug: false", "proxies:", "  velocity:", "    enabled: true"),
+		},
+		{
+			name:  "missing mapping under existing parent",
+			doc:   lines("proxies:", "  bungee-cord:", "    online-mode: false", "settings:", "  debug: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  bungee-cord:", "    online-mode: false", "  velocity:", "    enabled: true", "settings:", "  debug: false"),
+		},
+		{
+			name:  "longer key with the same prefix",
+			doc:   lines("secret-key: a", "secret: b"),
+			path:  "secret",
+			value: "c",
+			want:  lines("secret-key: a", "secret: c"),
+		},
+		{
+			name:  "same key at another depth",
+			doc:   lines("velocity:", "  enabled: false", "proxies:", "  velocity:", "    enabled: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("velocity:", "  enabled: false", "proxies:", "  velocity:", "    enabled: true"),
+		},
+		{
+			name:  "comments kept",
+			doc:   lines("# Paper config", "proxies:", "  # Modern forwarding", "  velocity:", "    # Turn on for networks", "    enabled: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("# Paper config", "proxies:", "  # Modern forwarding", "  velocity:", "    # Turn on for networks", "    enabled: true"),
+		},
+		{
+			name:  "commented-out key ignored",
+			doc:   lines("proxies:", "  velocity:", "    # enabled: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  velocity:", "    # enabled: false", "    enabled: true"),
+		},
+		{
+			name:  "inline comment kept",
+			doc:   lines("proxies:", "  velocity:", "    enabled: false # needs a restart"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  velocity:", "    enabled: true # needs a restart"),
+		},
+		{
+			name:  "hash inside a quoted value",
+			doc:   lines("motd: 'Server #1'"),
+			path:  "motd",
+			value: "'Server #2'",
+			want:  lines("motd: 'Server #2'"),
+		},
+		{
+			name:  "insert before the next section's comment",
+			doc:   lines("proxies:", "  velocity:", "    enabled: false", "", "# Chat settings", "chat:", "  format: x"),
+			path:  "proxies.velocity.secret",
+			value: "'abc'",
+			want:  lines("proxies:", "  velocity:", "    enabled: false", "    secret: 'abc'", "", "# Chat settings", "chat:", "  format: x"),
+		},
+		{
+			name:  "list values skipped",
+			doc:   lines("settings:", "  hidden-items:", "  - enabled", "  - velocity", "  enabled: false"),
+			path:  "settings.enabled",
+			value: "true",
+			want:  lines("settings:", "  hidden-items:", "  - enabled", "  - velocity", "  enabled: true"),
+		},
+		{
+			name:  "after a nested list",
+			doc:   lines("settings:", "  worlds:", "    - world", "    - world_nether", "other: 1"),
+			path:  "settings.debug",
+			value: "false",
+			want:  lines("settings:", "  worlds:", "    - world", "    - world_nether", "  debug: false", "other: 1"),
+		},
+		{
+			name:  "four-space indentation",
+			doc:   lines("proxies:", "    velocity:", "        enabled: false"),
+			path:  "proxies.velocity.secret",
+			value: "'abc'",
+			want:  lines("proxies:", "    velocity:", "        enabled: false", "        secret: 'abc'"),
+		},
+		{
+			name:  "four-space indentation, missing mapping",
+			doc:   lines("proxies:", "    bungee-cord:", "        online-mode: false"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "    bungee-cord:", "        online-mode: false", "    velocity:", "      enabled: true"),
+		},
+		{
+			name:  "empty mapping",
+			doc:   lines("proxies:", "other: 1"),
+			path:  "proxies.velocity.enabled",
+			value: "true",
+			want:  lines("proxies:", "  velocity:", "    enabled: true", "other: 1"),
+		},
+		{
+			name:  "missing final newline",
+			doc:   "a: 1",
+			path:  "a",
+			value: "2",
+			want:  lines("a: 2"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := setYAMLValue(tt.doc, strings.Split(tt.path, "."), tt.value)
+			if got != tt.want {
+				t.Errorf("setYAMLValue(%q, %s, %s) =\n%s\nwant\n%s", tt.doc, tt.path, tt.value, got, tt.want)
+			}
+		})
+	}
+}
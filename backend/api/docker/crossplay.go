@@ -0,0 +1,238 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Download URLs of the latest Geyser and Floodgate builds for Spigot-based
+// servers. The image installs them from PLUGINS on startup.
+const (
+	geyserPluginURL    = "https://download.geysermc.org/v2/projects/geyser/versions/latest/builds/latest/downloads/spigot"
+	floodgatePluginURL = "https://download.geysermc.org/v2/projects/floodgate/versions/latest/builds/latest/downloads/spigot"
+)
+
+// geyserConfigPath is where Geyser-Spigot reads its config, relative to /data.
+const geyserConfigPath = "plugins/Geyser-Spigot/config.yml"
+
+// crossplayTypes are the server types that can load Bukkit plugins.
+var crossplayTypes = []string{"PAPER", "FOLIA", "PURPUR", "SPIGOT", "BUKKIT"}
+
+// ServerAddress is a port players of an edition connect to. Address is only
+// set when the public_host setting is.
+type ServerAddress struct {
+	Edition  string `json:"edition"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Address  string `json:"address,omitempty"`
+}
+
+// validateCrossplay checks that a Java server can run Geyser and Floodgate.
+func validateCrossplay(cfg ServerConfig) error {
+	if cfg.Edition == EditionBedrock {
+		return &catalog.ValidationError{Message: "crossplay is only available for Java Edition servers"}
+	}
+	serverType := catalog.NormalizeType(cfg.Type)
+	for _, t := range crossplayTypes {
+		if t == serverType {
+			return nil
+		}
+	}
+	return &catalog.ValidationError{Message: fmt.Sprintf("crossplay needs a plugin server type (%s), not %s", strings.Join(crossplayTypes, ", "), serverType)}
+}
+
+// crossplayPlugins adds Geyser and Floodgate to a plugin list.
+func crossplayPlugins(plugins []string) []string {
+	for _, url := range []string{geyserPluginURL, floodgatePluginURL} {
+		found := false
+		for _, p := range plugins {
+			if p == url {
+				found = true
+				break
+			}
+		}
+		if !found {
+			plugins = append(plugins, url)
+		}
+	}
+	return plugins
+}
+
+// hasCrossplay reports whether a Java server container publishes a Bedrock
+// port for Geyser.
+func hasCrossplay(edition string, bindings nat.PortMap) bool {
+	return edition == EditionJava && hostPort(bindings, bedrockPort) != 0
+}
+
+// geyserConfig points Geyser at the Java server in the same container.
+// Bedrock clients reach it on the published bedrockHostPort, which Geyser
+// advertises in its pong so server lists show the right port. Floodgate lets
+// them join without a Java account.
+func geyserConfig(bedrockHostPort int) string {
+	return fmt.Sprintf(`# Written by MboxMini. Geyser adds any missing options on startup.
+bedrock:
+  address: 0.0.0.0
+  port: %s
+  clone-remote-port: false
+  broadcast-port: %d
+remote:
+  address: auto
+  port: %s
+  auth-type: floodgate
+`, bedrockPort.Port(), bedrockHostPort, gamePort.Port())
+}
+
+// writeGeyserConfig writes the Geyser config into a server's storage.
+func (m *Manager) writeGeyserConfig(ctx context.Context, st Storage, bedrockHostPort int) error {
+	log.Printf("Writing Geyser config to %s (Bedrock port %d)", st.Source, bedrockHostPort)
+	if err := m.writeDataFile(ctx, st, geyserConfigPath, []byte(geyserConfig(bedrockHostPort))); err != nil {
+		return fmt.Errorf("failed to write Geyser config: %v", err)
+	}
+	return nil
+}
+
+// serverAddresses lists the ports players connect to, using the Java and
+// Bedrock host ports published by a container.
+func (m *Manager) serverAddresses(edition string, javaPort, bedrockHostPort int) []ServerAddress {
+	host := m.db.SettingString("public_host")
+	address := func(edition string, port nat.Port, hostPort int) ServerAddress {
+		addr := ServerAddress{Edition: edition, Protocol: port.Proto(), Port: hostPort}
+		if host != "" {
+			addr.Address = fmt.Sprintf("%s:%d", host, hostPort)
+		}
+		return addr
+	}
+
+	var addresses []ServerAddress
+	if edition == EditionJava && javaPort != 0 {
+		addresses = append(addresses, address(EditionJava, gamePort, javaPort))
+	}
+	if bedrockHostPort != 0 {
+		addresses = append(addresses, address(EditionBedrock, bedrockPort, bedrockHostPort))
+	}
+	return addresses
+}
+
+// addAddresses fills in a server's crossplay flag and connection addresses.
+func (m *Manager) addAddresses(info *ServerInfo, inspect types.ContainerJSON) {
+	bindings := inspect.HostConfig.PortBindings
+	info.Crossplay = hasCrossplay(info.Edition, bindings)
+	info.Addresses = m.serverAddresses(info.Edition, hostPort(bindings, gamePort), hostPort(bindings, bedrockPort))
+}
+
+// ValidateCrossplay checks that crossplay can be turned on or off for a server
+// so callers can reject the change up front. SetCrossplay runs the same
+// checks.
+func (m *Manager) ValidateCrossplay(ctx context.Context, serverID string, enabled bool) error {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	edition := editionOf(inspect.Config.Image, inspect.Config.Labels)
+	if !enabled || hasCrossplay(edition, inspect.HostConfig.PortBindings) {
+		return nil
+	}
+	return validateCrossplay(ServerConfig{Edition: edition, Type: envValue(inspect.Config.Env, "TYPE")})
+}
+
+// SetCrossplay turns crossplay on or off for an existing Java server by
+// recreating its container with or without the Bedrock port. Turning it off
+// leaves the downloaded plugin jars in the plugins folder, but Bedrock
+// clients can no longer reach Geyser.
+func (m *Manager) SetCrossplay(ctx context.Context, serverID string, enabled bool) error {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+	edition := editionOf(inspect.Config.Image, inspect.Config.Labels)
+	if hasCrossplay(edition, inspect.HostConfig.PortBindings) == enabled {
+		return nil
+	}
+
+	record, err := m.serverRecord(inspect.ID, containerName, inspect.Config.Labels)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		port := hostPort(inspect.HostConfig.PortBindings, bedrockPort)
+		log.Printf("Disabling crossplay on %s", containerName)
+		if err := m.recreateContainer(ctx, containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+			cfg.Env = setPlugins(cfg.Env, withoutCrossplayPlugins(envList(cfg.Env, "PLUGINS")))
+			delete(hostConfig.PortBindings, bedrockPort)
+		}, inspect.State.Running); err != nil {
+			return err
+		}
+		return m.db.ReleasePort(port, bedrockPort.Proto())
+	}
+
+	if err := validateCrossplay(ServerConfig{Edition: edition, Type: envValue(inspect.Config.Env, "TYPE")}); err != nil {
+		return err
+	}
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return err
+	}
+
+	port, err := m.allocatePort(ctx, record.ID, EditionBedrock, 0)
+	if err != nil {
+		return err
+	}
+	release := func() {
+		if err := m.db.ReleasePort(port, bedrockPort.Proto()); err != nil {
+			log.Printf("Error releasing port %d: %v", port, err)
+		}
+	}
+	if err := m.writeGeyserConfig(ctx, storage, port); err != nil {
+		release()
+		return err
+	}
+
+	log.Printf("Enabling crossplay on %s with Bedrock port %d", containerName, port)
+	if err := m.recreateContainer(ctx, containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		cfg.Env = setPlugins(cfg.Env, crossplayPlugins(envList(cfg.Env, "PLUGINS")))
+		if hostConfig.PortBindings == nil {
+			hostConfig.PortBindings = nat.PortMap{}
+		}
+		hostConfig.PortBindings[bedrockPort] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", port)}}
+	}, inspect.State.Running); err != nil {
+		release()
+		return err
+	}
+	return nil
+}
+
+func withoutCrossplayPlugins(plugins []string) []string {
+	var kept []string
+	for _, p := range plugins {
+		if p != geyserPluginURL && p != floodgatePluginURL {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// envList splits a comma-separated variable such as PLUGINS.
+func envList(env []string, key string) []string {
+	value := envValue(env, key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// setPlugins replaces PLUGINS, dropping it when there are none left.
+func setPlugins(env []string, plugins []string) []string {
+	if len(plugins) > 0 {
+		return setEnv(env, "PLUGINS", strings.Join(plugins, ","))
+	}
+	return removeEnv(env, "PLUGINS")
+}
@@ -5,9 +5,8 @@
 	"fmt"
 	"io"
 	"log"
-	"net"
-	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -16,200 +15,440 @@
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/modpack"
+	"github.com/mboxmini/mboxmini/backend/api/mojang"
 )
 
+// serverImageRepo is the Minecraft server image; the tag comes from the
+// default_image_tag setting.
+const serverImageRepo = "itzg/minecraft-server"
+
 type Manager struct {
-	client    *client.Client
-	dataPath  string
-	portStart int
-	portEnd   int
-	mu        sync.Mutex
-	portInUse map[int]string
+	client   *client.Client
+	db       *database.DB
+	catalog  *catalog.Catalog
+	dataPath string
+	// backupPath holds backups and archived worlds, see SetBackupPath
+	backupPath string
+	dataUID    int
+	dataGID    int
+	// defaultStorage is the backend for new servers, see SetDefaultStorage
+	defaultStorage string
+	// mu serializes port allocation
+	mu sync.Mutex
+	// boundPorts caches the ports host processes listen on, guarded by mu
+	boundPorts map[string]boundPorts
+	// ownerMu guards ownerLocks and diskUsage, see lockOwner
+	ownerMu    sync.Mutex
+	ownerLocks map[int64]*sync.Mutex
+	diskUsage  map[int64]diskUsage
+	// downloader fetches modpack files, see SetModpackDownloader
+	downloader modpack.Downloader
+	// profiles resolves player names for access lists of stopped servers
+	profiles *mojang.Client
+}
+
+// ServerConfig describes the Minecraft container to create. Env, Properties,
+// Datapacks and Plugins are optional and usually come from a server template.
+// Storage selects a bind mount or named volume for /data and Resources sets
+// the container limits. OwnerID is the user whose quota the server counts
+// against, or 0 for none. Port requests a specific host port; 0 picks a free
+// one from the configured range. Edition is java (the default) or bedrock;
+// Bedrock servers have no Type and their Memory only sizes the default
+// container limit. Crossplay installs Geyser and Floodgate on a Java plugin
+// server and publishes a Bedrock port for them.
+type ServerConfig struct {
+	Name           string
+	Edition        string
+	Version        string
+	Memory         string
+	Type           string
+	PauseWhenEmpty int
+	ViewDistance   int
+	Env            map[string]string
+	Properties     map[string]string
+	Datapacks      []string
+	Plugins        []string
+	Storage        string
+	Resources      Resources
+	OwnerID        int64
+	Port           int
+	Crossplay      bool
+	// RestartPolicy defaults to the default_restart_policy setting
+	RestartPolicy *RestartPolicy
+
+	// populate fills the new data directory before the container exists
+	populate func(ctx context.Context, st Storage, progress Progress) error
 }
 
+// containerEnv builds the itzg/minecraft-server environment for the config.
+// Explicit fields always win over entries in Env.
+func (cfg ServerConfig) containerEnv() []string {
+	env := []string{"EULA=TRUE"}
+
+	keys := make([]string, 0, len(cfg.Env))
+	for key := range cfg.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "EULA", "VERSION", "TYPE", "MEMORY", "PAUSE_WHEN_EMPTY_SECONDS", "VIEW_DISTANCE":
+			continue
+		}
+		env = append(env, fmt.Sprintf("%s=%s", key, cfg.Env[key]))
+	}
+
+	env = append(env,
+		fmt.Sprintf("VERSION=%s", cfg.Version),
+		fmt.Sprintf("TYPE=%s", cfg.Type),
+		fmt.Sprintf("MEMORY=%s", cfg.Memory),
+		fmt.Sprintf("PAUSE_WHEN_EMPTY_SECONDS=%d", cfg.PauseWhenEmpty),
+		fmt.Sprintf("VIEW_DISTANCE=%d", cfg.ViewDistance),
+	)
+
+	// The image writes these into server.properties on first start
+	if len(cfg.Properties) > 0 {
+		props := make([]string, 0, len(cfg.Properties))
+		for key, value := range cfg.Properties {
+			props = append(props, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(props)
+		env = append(env, fmt.Sprintf("CUSTOM_SERVER_PROPERTIES=%s", strings.Join(props, "\n")))
+	}
+
+	// The image downloads these URLs into the world's datapacks and plugins folders
+	if len(cfg.Datapacks) > 0 {
+		env = append(env, fmt.Sprintf("DATAPACKS=%s", strings.Join(cfg.Datapacks, ",")))
+	}
+	if len(cfg.Plugins) > 0 {
+		env = append(env, fmt.Sprintf("PLUGINS=%s", strings.Join(cfg.Plugins, ",")))
+	}
+
+	return env
+}
+
+// ServerInfo describes a server. Bedrock servers only report how many
+// players are online, so Players is empty for them. Addresses lists the Java
+// and Bedrock ports, both for Java servers with crossplay.
 type ServerInfo struct {
-	ID      string   `json:"id"`
-	Name    string   `json:"name"`
-	Status  string   `json:"status"`
-	Version string   `json:"version"`
-	Port    int      `json:"port"`
-	Players []string `json:"players"`
+	ID              string          `json:"id"`
+	UUID            string          `json:"uuid"`
+	Name            string          `json:"name"`
+	Edition         string          `json:"edition"`
+	Status          string          `json:"status"`
+	Version         string          `json:"version"`
+	Port            int             `json:"port"`
+	Crossplay       bool            `json:"crossplay,omitempty"`
+	Addresses       []ServerAddress `json:"addresses,omitempty"`
+	Players         []string        `json:"players"`
+	PlayerCount     int             `json:"playerCount"`
+	MaxPlayers      int             `json:"maxPlayers,omitempty"`
+	Storage         string          `json:"storage,omitempty"`
+	Resources       *Resources      `json:"resources,omitempty"`
+	RestartRequired bool            `json:"restartRequired,omitempty"`
+	RestartPolicy   RestartPolicy   `json:"restartPolicy"`
+	RestartCount    int             `json:"restartCount,omitempty"`
 }
 
-func NewManager(dataPath string, portStart, portEnd int) (*Manager, error) {
+func NewManager(db *database.DB, versions *catalog.Catalog, dataPath string) (*Manager, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %v", err)
 	}
 
 	m := &Manager{
-		client:    cli,
-		dataPath:  dataPath,
-		portStart: portStart,
-		portEnd:   portEnd,
-		portInUse: make(map[int]string),
+		client:     cli,
+		db:         db,
+		catalog:    versions,
+		dataPath:   dataPath,
+		backupPath: defaultBackupPath(dataPath),
+		dataUID:    -1,
+		dataGID:    -1,
+		downloader: modpack.NewHTTPDownloader(""),
+		profiles:   mojang.NewClient(),
 	}
 
-	// Initialize port tracking by checking existing containers
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %v", err)
+	// Give servers created before stable IDs existed a UUID
+	if err := m.migrateServers(); err != nil {
+		return nil, fmt.Errorf("failed to migrate servers: %v", err)
 	}
 
-	for _, cont := range containers {
-		for _, p := range cont.Ports {
-			if p.PrivatePort == 25565 {
-				m.portInUse[int(p.PublicPort)] = strings.TrimPrefix(cont.Names[0], "/")
-				break
-			}
-		}
+	// Reserve the ports of existing servers, running or not
+	if err := m.syncPorts(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to sync port allocations: %v", err)
+	}
+
+	if err := db.FailInterruptedUpgrades(); err != nil {
+		return nil, fmt.Errorf("failed to recover interrupted upgrades: %v", err)
 	}
 
 	return m, nil
 }
 
-func (m *Manager) findAvailablePort() (int, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// serverImage is the image new servers are created from.
+func (m *Manager) serverImage() string {
+	return serverImageRepo + ":" + m.db.SettingString("default_image_tag")
+}
 
-	// First try to find a port in the configured range
-	for port := m.portStart; port <= m.portEnd; port++ {
-		// Check if port is in use by our tracking
-		if _, exists := m.portInUse[port]; exists {
-			continue
+// editionImage is the image new servers of an edition are created from.
+func (m *Manager) editionImage(edition string) string {
+	if edition == EditionBedrock {
+		return bedrockImageRepo + ":latest"
+	}
+	return m.serverImage()
+}
+
+// ValidateServerConfig checks a config before any work is done so callers
+// can reject it up front. CreateServer runs the same checks.
+func (m *Manager) ValidateServerConfig(cfg ServerConfig) error {
+	if err := ValidateServerName(cfg.Name); err != nil {
+		return err
+	}
+	if err := ValidateEdition(cfg.Edition); err != nil {
+		return err
+	}
+	if cfg.Edition == EditionBedrock {
+		if err := validateBedrockConfig(cfg); err != nil {
+			return err
 		}
+	} else if err := m.catalog.Validate(cfg.Type, cfg.Version); err != nil {
+		return err
+	}
+	if err := ValidateStorage(cfg.Storage); err != nil {
+		return &catalog.ValidationError{Message: err.Error()}
+	}
+	resources, err := m.resolveResources(cfg.Resources, cfg.Memory, cfg.OwnerID)
+	if err != nil {
+		return err
+	}
 
-		// Check if port is in use by the system
-		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-		if err != nil {
-			continue
+	if existing, err := m.db.GetServerByName(cfg.Name); err != nil {
+		return err
+	} else if existing != nil {
+		return &catalog.ValidationError{Message: fmt.Sprintf("a server named %s already exists", cfg.Name)}
+	}
+	if err := m.checkContainerName(context.Background(), ContainerName(cfg.Name)); err != nil {
+		return err
+	}
+
+	if cfg.Crossplay {
+		if err := validateCrossplay(cfg); err != nil {
+			return err
 		}
-		listener.Close()
+	}
 
-		return port, nil
+	if cfg.RestartPolicy != nil {
+		if err := cfg.RestartPolicy.Validate(); err != nil {
+			return err
+		}
 	}
 
-	return 0, fmt.Errorf("no available ports in range %d-%d", m.portStart, m.portEnd)
+	if cfg.Port != 0 {
+		if err := m.checkPort(context.Background(), cfg.Port, editionPort(cfg.Edition).Proto()); err != nil {
+			return err
+		}
+	}
+
+	// New servers are started right away, so they also need a running slot
+	if cfg.OwnerID != 0 {
+		memory, _ := ParseMemory(resources.MemoryLimit)
+		if err := m.CheckQuota(context.Background(), cfg.OwnerID, QuotaRequest{
+			Servers:     1,
+			MemoryBytes: memory,
+			Running:     1,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (m *Manager) CreateServer(name, version, memory string, serverType string, pauseWhenEmpty int, viewDistance int) (string, error) {
-	log.Printf("Starting server creation - Name: %s, Version: %s, Memory: %s, Type: %s, PauseWhenEmpty: %d, ViewDistance: %d", 
-		name, version, memory, serverType, pauseWhenEmpty, viewDistance)
+// CreateServer provisions the data directory, pulls the server image if
+// needed and creates and starts the container, reporting each step.
+func (m *Manager) CreateServer(ctx context.Context, cfg ServerConfig, progress Progress) (string, error) {
+	progress = orNoProgress(progress)
+	log.Printf("Starting server creation - Name: %s, Edition: %s, Version: %s, Memory: %s, Type: %s, PauseWhenEmpty: %d, ViewDistance: %d",
+		cfg.Name, cfg.Edition, cfg.Version, cfg.Memory, cfg.Type, cfg.PauseWhenEmpty, cfg.ViewDistance)
+
+	if cfg.Edition == "" {
+		cfg.Edition = EditionJava
+	}
 
-	if memory == "" {
-		memory = "2G"
-		log.Printf("Using default memory: %s", memory)
+	if cfg.Memory == "" {
+		cfg.Memory = m.defaultMemory()
+		log.Printf("Using default memory: %s", cfg.Memory)
 	}
 
-	if serverType == "" {
-		serverType = "VANILLA"
-		log.Printf("Using default type: %s", serverType)
+	if cfg.Edition == EditionJava {
+		if cfg.Type == "" {
+			log.Printf("Using default type: VANILLA")
+		}
+		cfg.Type = catalog.NormalizeType(cfg.Type)
 	}
 
-	if viewDistance == 0 {
-		viewDistance = 32
-		log.Printf("Using default view distance: %d", viewDistance)
+	// The quota checked here must still hold when the server is registered
+	defer m.lockOwner(cfg.OwnerID)()
+
+	// Reject typos before they turn into a crash-looping container
+	if err := m.ValidateServerConfig(cfg); err != nil {
+		log.Printf("Invalid server config: %v", err)
+		return "", err
 	}
 
-	port, err := m.findAvailablePort()
+	resources, err := m.resolveResources(cfg.Resources, cfg.Memory, cfg.OwnerID)
 	if err != nil {
-		log.Printf("Error finding available port: %v", err)
 		return "", err
 	}
-	log.Printf("Found available port: %d", port)
 
-	serverID := fmt.Sprintf("mboxmini-%s", name)
+	restart := m.defaultRestartPolicy()
+	if cfg.RestartPolicy != nil {
+		restart = *cfg.RestartPolicy
+	}
+
+	progress.Step("allocate port")
+
+	if cfg.ViewDistance == 0 {
+		cfg.ViewDistance = 32
+		log.Printf("Using default view distance: %d", cfg.ViewDistance)
+	}
+
+	serverID := ContainerName(cfg.Name)
 	log.Printf("Generated server ID: %s", serverID)
 
-	// Create server data directory on host using a temporary container
-	serverDataDir := filepath.Join(m.dataPath, serverID)
-	log.Printf("Creating server data directory on host: %s", serverDataDir)
+	uuid, err := database.NewServerID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate server ID: %v", err)
+	}
 
-	// Pull alpine image first
-	log.Printf("Pulling alpine image...")
-	reader, err := m.client.ImagePull(context.Background(), "alpine:latest", types.ImagePullOptions{})
+	// Undo everything created so far unless the server is registered
+	var cleanups []func()
+	registered := false
+	defer func() {
+		if registered {
+			return
+		}
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
+
+	port, err := m.allocatePort(ctx, uuid, cfg.Edition, cfg.Port)
 	if err != nil {
-		log.Printf("Error pulling alpine image: %v", err)
-		return "", fmt.Errorf("failed to pull alpine image: %v", err)
-	}
-	io.Copy(io.Discard, reader)
-	reader.Close()
-
-	// Use alpine container to create directory
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", serverDataDir)
-	resp, err := m.client.ContainerCreate(
-		context.Background(),
-		&container.Config{
-			Image: "alpine:latest",
-			Cmd:   []string{"sh", "-c", mkdirCmd},
-		},
-		&container.HostConfig{
-			AutoRemove: true,
-			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: filepath.Dir(m.dataPath),
-					Target: filepath.Dir(m.dataPath),
-				},
-			},
-		},
-		nil,
-		nil,
-		"",
-	)
+		log.Printf("Error allocating port: %v", err)
+		return "", err
+	}
+	log.Printf("Allocated port: %d", port)
+	cleanups = append(cleanups, func() {
+		if err := m.db.ReleaseServerPorts(uuid); err != nil {
+			log.Printf("Error releasing ports of %s: %v", serverID, err)
+		}
+	})
+	ports := map[nat.Port]int{editionPort(cfg.Edition): port}
+
+	if cfg.Crossplay {
+		bedrockHostPort, err := m.allocatePort(ctx, uuid, EditionBedrock, 0)
+		if err != nil {
+			log.Printf("Error allocating crossplay port: %v", err)
+			return "", err
+		}
+		log.Printf("Allocated crossplay port: %d", bedrockHostPort)
+		ports[bedrockPort] = bedrockHostPort
+		cfg.Plugins = crossplayPlugins(cfg.Plugins)
+	}
+
+	progress.Step("create data directory")
+	storage, err := m.provisionData(ctx, serverID, uuid, cfg.Storage)
 	if err != nil {
-		log.Printf("Error creating directory container: %v", err)
-		return "", fmt.Errorf("failed to create directory container: %v", err)
+		return "", err
 	}
+	cleanups = append(cleanups, func() {
+		if err := m.removeData(context.Background(), storage); err != nil {
+			log.Printf("Error removing data of %s: %v", serverID, err)
+		}
+	})
 
-	if err := m.client.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
-		log.Printf("Error starting directory container: %v", err)
-		return "", fmt.Errorf("failed to start directory container: %v", err)
+	if cfg.Crossplay {
+		if err := m.writeGeyserConfig(ctx, storage, ports[bedrockPort]); err != nil {
+			return "", err
+		}
 	}
 
-	// Wait for container to finish
-	statusCh, errCh := m.client.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			log.Printf("Error waiting for directory container: %v", err)
-			return "", fmt.Errorf("failed waiting for directory container: %v", err)
+	if cfg.populate != nil {
+		if err := cfg.populate(ctx, storage, progress); err != nil {
+			return "", err
 		}
-	case <-statusCh:
 	}
 
-	env := []string{
-		"EULA=TRUE",
-		fmt.Sprintf("VERSION=%s", version),
-		fmt.Sprintf("TYPE=%s", serverType),
-		fmt.Sprintf("MEMORY=%s", memory),
-		fmt.Sprintf("PAUSE_WHEN_EMPTY_SECONDS=%d", pauseWhenEmpty),
-		fmt.Sprintf("VIEW_DISTANCE=%d", viewDistance),
+	progress.Step("pull server image")
+	image := m.editionImage(cfg.Edition)
+	if err := m.ensureImage(ctx, image, progress); err != nil {
+		return "", err
+	}
+
+	progress.Step("create container")
+	env := cfg.containerEnv()
+	if cfg.Edition == EditionBedrock {
+		env = cfg.bedrockEnv()
 	}
+	env = append(env, m.ownerEnv()...)
 	log.Printf("Minecraft container environment variables: %v", env)
 
-	// Create Minecraft server container
+	if err := m.createServerContainer(ctx, serverID, image, env, storage, resources.containerResources(), restart.containerPolicy(), cfg.Edition, ports, uuid); err != nil {
+		return "", err
+	}
+	cleanups = append(cleanups, func() {
+		if err := m.client.ContainerRemove(context.Background(), serverID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			log.Printf("Error removing container %s: %v", serverID, err)
+		}
+	})
+
+	if err := m.db.CreateServer(&database.Server{ID: uuid, Name: cfg.Name, ContainerName: serverID, OwnerID: cfg.OwnerID}); err != nil {
+		log.Printf("Error registering server: %v", err)
+		return "", fmt.Errorf("failed to register server: %v", err)
+	}
+	cleanups = append(cleanups, func() { m.db.DeleteServer(uuid) })
+
+	// Start the container
+	progress.Step("start container")
+	log.Printf("Starting container %s", serverID)
+	if err := m.client.ContainerStart(ctx, serverID, types.ContainerStartOptions{}); err != nil {
+		log.Printf("Error starting container: %v", err)
+		return "", fmt.Errorf("failed to start container: %v", err)
+	}
+	registered = true
+
+	log.Printf("Server created and started successfully with ID: %s", serverID)
+	return serverID, nil
+}
+
+// createServerContainer creates (but does not start) a Minecraft container
+// with storage mounted at /data, the given limits and restart policy and
+// ports, which map container ports to host ports, published.
+func (m *Manager) createServerContainer(ctx context.Context, serverID, image string, env []string, storage Storage, resources container.Resources, restart container.RestartPolicy, edition string, ports map[nat.Port]int, uuid string) error {
+	bindings := nat.PortMap{}
+	for containerPort, port := range ports {
+		bindings[containerPort] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", port)}}
+	}
+
 	containerConfig := &container.Config{
-		Image: "itzg/minecraft-server:latest",
-		Env:   env,
+		Image:  image,
+		Env:    env,
+		Labels: map[string]string{serverIDLabel: uuid, editionLabel: edition},
 	}
 
 	hostConfig := &container.HostConfig{
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: serverDataDir,
-				Target: "/data",
-			},
-		},
-		PortBindings: nat.PortMap{
-			"25565/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", port)}},
-		},
+		Mounts:        []mount.Mount{storage.mount()},
+		Resources:     resources,
+		PortBindings:  bindings,
+		RestartPolicy: restart,
 	}
 
 	// Create the container
-	_, err = m.client.ContainerCreate(
-		context.Background(),
+	_, err := m.client.ContainerCreate(
+		ctx,
 		containerConfig,
 		hostConfig,
 		nil,
@@ -218,31 +457,15 @@ func (m *Manager) CreateServer(name, version, memory string, serverType string,
 	)
 	if err != nil {
 		log.Printf("Error creating container: %v", err)
-		return "", fmt.Errorf("failed to create container: %v", err)
+		return fmt.Errorf("failed to create container: %v", err)
 	}
 
-	// Start the container
-	log.Printf("Starting container %s", serverID)
-	if err := m.client.ContainerStart(context.Background(), serverID, types.ContainerStartOptions{}); err != nil {
-		log.Printf("Error starting container: %v", err)
-		// Clean up on failure
-		if rmErr := m.client.ContainerRemove(context.Background(), serverID, types.ContainerRemoveOptions{Force: true}); rmErr != nil {
-			log.Printf("Error removing container after failed start: %v", rmErr)
-		}
-		return "", fmt.Errorf("failed to start container: %v", err)
-	}
-
-	m.mu.Lock()
-	m.portInUse[port] = serverID
-	m.mu.Unlock()
-
-	log.Printf("Server created and started successfully with ID: %s", serverID)
-	return serverID, nil
+	return nil
 }
 
 func (m *Manager) ListServers() ([]ServerInfo, error) {
 	log.Printf("Listing Docker containers")
-	
+
 	containers, err := m.client.ContainerList(context.Background(), types.ContainerListOptions{
 		All: true,
 	})
@@ -255,25 +478,19 @@ func (m *Manager) ListServers() ([]ServerInfo, error) {
 
 	var servers []ServerInfo
 	for _, container := range containers {
-		// Get container name without leading slash
-		if len(container.Names) == 0 {
+		// Only include Minecraft server containers with the mboxmini- prefix
+		if !isServerContainer(container) {
 			continue
 		}
 		name := strings.TrimPrefix(container.Names[0], "/")
-		
-		// Skip containers that use MBoxMini application images
-		if strings.HasPrefix(container.Image, "mboxmini-") {
-			log.Printf("Skipping container %s: uses MBoxMini application image", name)
-			continue
-		}
-		
-		// Only include containers with mboxmini- prefix
-		if !strings.HasPrefix(name, "mboxmini-") {
-			log.Printf("Skipping container %s: not a Minecraft server", name)
+
+		log.Printf("Processing Minecraft server: %s", name)
+
+		record, err := m.serverRecord(container.ID, name, container.Labels)
+		if err != nil {
+			log.Printf("Error looking up server %s: %v", name, err)
 			continue
 		}
-		
-		log.Printf("Processing Minecraft server: %s", name)
 
 		// Get container details to extract version from environment variables
 		inspect, err := m.client.ContainerInspect(context.Background(), container.ID)
@@ -291,15 +508,10 @@ func (m *Manager) ListServers() ([]ServerInfo, error) {
 			}
 		}
 		log.Printf("Server version: %s", version)
-		
+
 		// Get port mapping
-		port := 0
-		for _, p := range container.Ports {
-			if p.PrivatePort == 25565 {
-				port = int(p.PublicPort)
-				break
-			}
-		}
+		edition := editionOf(container.Image, container.Labels)
+		port := hostPort(inspect.HostConfig.PortBindings, editionPort(edition))
 		log.Printf("Server port: %d", port)
 
 		// Get server status
@@ -308,23 +520,33 @@ func (m *Manager) ListServers() ([]ServerInfo, error) {
 			status = "running"
 		}
 
+		serverInfo := ServerInfo{
+			ID:      container.ID,
+			UUID:    record.ID,
+			Name:    record.Name,
+			Edition: edition,
+			Status:  status,
+			Port:    port,
+			Version: version,
+		}
+		m.addAddresses(&serverInfo, inspect)
+		serverInfo.RestartRequired = m.restartRequired(record.ID, inspect)
+		serverInfo.RestartPolicy = restartPolicyOf(inspect.HostConfig)
+		serverInfo.RestartCount = inspect.RestartCount
+
 		// Get players if server is running
-		var players []string
 		if status == "running" {
-			if playerList, err := m.GetServerPlayers(context.Background(), container.ID); err == nil {
-				players = playerList
+			if edition == EditionBedrock {
+				m.addBedrockStatus(&serverInfo, inspect)
+			} else if playerList, err := m.GetServerPlayers(context.Background(), container.ID); err == nil {
+				serverInfo.Players = playerList
+				serverInfo.PlayerCount = len(playerList)
 			} else {
 				log.Printf("Error getting players for server %s: %v", name, err)
 			}
 		}
-
-		serverInfo := ServerInfo{
-			ID:      container.ID,
-			Name:    strings.TrimPrefix(name, "mboxmini-"),
-			Status:  status,
-			Port:    port,
-			Version: version,
-			Players: players,
+		if storage, err := storageOf(container.Mounts); err == nil {
+			serverInfo.Storage = storage.Type
 		}
 		log.Printf("Adding server: %+v", serverInfo)
 		servers = append(servers, serverInfo)
@@ -335,11 +557,16 @@ func (m *Manager) ListServers() ([]ServerInfo, error) {
 }
 
 func (m *Manager) GetServerStatus(serverID string) (*ServerInfo, error) {
-	inspect, err := m.client.ContainerInspect(context.Background(), serverID)
+	inspect, err := m.client.ContainerInspect(context.Background(), m.containerRef(serverID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect container: %v", err)
 	}
 
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
 	// Extract version from environment variables
 	var version string
 	for _, env := range inspect.Config.Env {
@@ -350,56 +577,65 @@ func (m *Manager) GetServerStatus(serverID string) (*ServerInfo, error) {
 	}
 
 	// Get port mapping
-	var port int
-	for p := range inspect.NetworkSettings.Ports {
-		if strings.HasPrefix(string(p), "25565") {
-			bindings := inspect.NetworkSettings.Ports[p]
-			if len(bindings) > 0 {
-				if p, err := nat.ParsePort(bindings[0].HostPort); err == nil {
-					port = int(p)
-				}
-			}
-			break
-		}
+	edition := editionOf(inspect.Config.Image, inspect.Config.Labels)
+	port := hostPort(inspect.HostConfig.PortBindings, editionPort(edition))
+
+	info := &ServerInfo{
+		ID:      serverID,
+		UUID:    record.ID,
+		Name:    record.Name,
+		Edition: edition,
+		Status:  inspect.State.Status,
+		Version: version,
+		Port:    port,
 	}
+	m.addAddresses(info, inspect)
+	info.RestartRequired = m.restartRequired(record.ID, inspect)
+	info.RestartPolicy = restartPolicyOf(inspect.HostConfig)
+	info.RestartCount = inspect.RestartCount
 
 	// Get players if server is running
-	var players []string
 	if inspect.State.Running {
-		if playerList, err := m.getPlayers(serverID); err == nil {
-			players = playerList
+		if edition == EditionBedrock {
+			m.addBedrockStatus(info, inspect)
+		} else if playerList, err := m.getPlayers(inspect.ID); err == nil {
+			info.Players = playerList
+			info.PlayerCount = len(playerList)
 		}
 	}
-
-	return &ServerInfo{
-		ID:      serverID,
-		Name:    strings.TrimPrefix(strings.TrimPrefix(inspect.Name, "/"), "mboxmini-"),
-		Status:  inspect.State.Status,
-		Version: version,
-		Port:    port,
-		Players: players,
-	}, nil
+	if storage, err := storageOf(inspect.Mounts); err == nil {
+		info.Storage = storage.Type
+	}
+	if inspect.HostConfig != nil {
+		info.Resources = resourcesOf(inspect.HostConfig.Resources)
+	}
+	return info, nil
 }
 
 func (m *Manager) StartServer(serverID string) error {
-	return m.client.ContainerStart(context.Background(), serverID, types.ContainerStartOptions{})
-}
-
-func (m *Manager) StopServer(serverID string) error {
-	timeout := 30 // seconds
-	return m.client.ContainerStop(context.Background(), serverID, container.StopOptions{
-		Timeout: &timeout,
-	})
+	return m.client.ContainerStart(context.Background(), m.containerRef(serverID), types.ContainerStartOptions{})
 }
 
+// ExecuteCommand runs a console command. Java servers return the RCON
+// response; Bedrock has no RCON, so commands are written to the console with
+// send-command and there is no output.
 func (m *Manager) ExecuteCommand(ctx context.Context, serverID string, command string) (string, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %v", err)
+	}
+	cmd := []string{"rcon-cli", command}
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		cmd = []string{"send-command", command}
+	}
+
 	execConfig := types.ExecConfig{
-		Cmd:          []string{"rcon-cli", command},
+		Cmd:          cmd,
 		AttachStdout: true,
 		AttachStderr: true,
 	}
 
-	execID, err := m.client.ContainerExecCreate(ctx, serverID, execConfig)
+	execID, err := m.client.ContainerExecCreate(ctx, inspect.ID, execConfig)
 	if err != nil {
 		return "", fmt.Errorf("failed to create exec: %v", err)
 	}
@@ -432,11 +668,11 @@ func (m *Manager) ExecuteCommand(ctx context.Context, serverID string, command s
 func (m *Manager) getPlayers(serverID string) ([]string, error) {
 	execConfig := types.ExecConfig{
 		AttachStdin:  true,
-			AttachStdout: true,
-			AttachStderr: true,
-			Tty:          true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
 
-			Cmd: []string{"rcon-cli", "list"},
+		Cmd: []string{"rcon-cli", "list"},
 	}
 
 	log.Printf("Getting player list for server %s", serverID)
@@ -486,6 +722,7 @@ func (m *Manager) DeleteServer(serverID string, removeFiles bool) error {
 	log.Printf("Starting deletion process for server %s (removeFiles=%v)", serverID, removeFiles)
 
 	// Get container info to get the name - we might need it for removing files
+	serverID = m.containerRef(serverID)
 	inspect, err := m.client.ContainerInspect(context.Background(), serverID)
 	if err != nil {
 		log.Printf("Failed to inspect container %s: %v", serverID, err)
@@ -494,6 +731,11 @@ func (m *Manager) DeleteServer(serverID string, removeFiles bool) error {
 	// Get server name from container name - we might need it for removing files
 	serverName := strings.TrimPrefix(inspect.Name, "/")
 
+	record, err := m.serverRecord(inspect.ID, serverName, inspect.Config.Labels)
+	if err != nil {
+		return err
+	}
+
 	// Stop the container first if it's running
 	if err := m.StopServer(serverID); err != nil {
 		log.Printf("Failed to stop server %s before deletion: %v", serverID, err)
@@ -505,34 +747,39 @@ func (m *Manager) DeleteServer(serverID string, removeFiles bool) error {
 	if err := m.client.ContainerRemove(context.Background(), serverID, types.ContainerRemoveOptions{
 		Force: true,
 	}); err != nil {
-		log.Printf("Failed to remove container %s / %s: %v", serverName,serverID, err)
+		log.Printf("Failed to remove container %s / %s: %v", serverName, serverID, err)
 		return fmt.Errorf("failed to remove container: %v", err)
 	}
 	log.Printf("Successfully removed container %s %s", serverName, serverID)
 
 	// Remove server files if requested
 	if removeFiles {
-		serverDataDir := filepath.Join(m.dataPath, serverName)
-		log.Printf("Attempting to remove server files at %s", serverDataDir)
-		if err := os.RemoveAll(serverDataDir); err != nil {
-			log.Printf("Failed to remove server files at %s: %v", serverDataDir, err)
-			return fmt.Errorf("failed to remove server files: %v", err)
+		storage, err := storageOf(inspect.Mounts)
+		if err != nil {
+			storage = Storage{Type: StorageBind, Source: filepath.Join(m.dataPath, serverName)}
+		}
+		log.Printf("Attempting to remove server files (%s %s)", storage.Type, storage.Source)
+		if err := m.removeData(context.Background(), storage); err != nil {
+			log.Printf("Failed to remove server files: %v", err)
+			return err
 		}
-		log.Printf("Successfully removed server files at %s", serverDataDir)
 	} else {
 		log.Printf("Skipping server files removal as removeFiles=false")
 	}
 
-	// Remove port mapping
-	m.mu.Lock()
-	for port, id := range m.portInUse {
-		if id == serverID {
-			delete(m.portInUse, port)
-			log.Printf("Removed port mapping for server %s (port %d)", serverID, port)
-			break
-		}
+	// Take the server out of its proxy's server list
+	m.forgetNetworkServer(context.Background(), record.ID)
+
+	if err := m.db.DeleteServer(record.ID); err != nil {
+		log.Printf("Failed to remove server %s from registry: %v", record.ID, err)
+	}
+
+	// Free the server's ports for new servers
+	if err := m.db.ReleaseServerPorts(record.ID); err != nil {
+		log.Printf("Failed to release ports of server %s: %v", record.ID, err)
+	} else {
+		log.Printf("Released ports of server %s", serverID)
 	}
-	m.mu.Unlock()
 
 	log.Printf("Server deletion completed successfully for %s", serverID)
 	return nil
@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/modpack"
+)
+
+// modpackLoaders maps a pack's loader to the image's TYPE and the variable
+// that pins the loader version.
+var modpackLoaders = map[string]struct{ serverType, versionEnv string }{
+	modpack.LoaderFabric:   {"FABRIC", "FABRIC_LOADER_VERSION"},
+	modpack.LoaderQuilt:    {"QUILT", "QUILT_LOADER_VERSION"},
+	modpack.LoaderForge:    {"FORGE", "FORGE_VERSION"},
+	modpack.LoaderNeoForge: {"NEOFORGE", "NEOFORGE_VERSION"},
+}
+
+// SetModpackDownloader sets how files listed by imported modpacks are
+// fetched, e.g. modpack.Offline{} to require packs to bundle everything.
+func (m *Manager) SetModpackDownloader(d modpack.Downloader) {
+	m.downloader = d
+}
+
+// ModpackConfig applies a pack's Minecraft version and loader to cfg.
+func ModpackConfig(cfg ServerConfig, pack *modpack.Pack) ServerConfig {
+	loader := modpackLoaders[pack.Loader]
+	cfg.Edition = EditionJava
+	cfg.Type = loader.serverType
+	cfg.Version = pack.MinecraftVersion
+	if cfg.Name == "" {
+		cfg.Name = pack.Name
+	}
+
+	env := map[string]string{}
+	for key, value := range cfg.Env {
+		env[key] = value
+	}
+	if pack.LoaderVersion != "" {
+		env[loader.versionEnv] = pack.LoaderVersion
+	}
+	cfg.Env = env
+	return cfg
+}
+
+// CheckModpack verifies that every file a pack lists is either bundled or
+// can be downloaded, so an import doesn't fail halfway.
+func (m *Manager) CheckModpack(pack *modpack.Pack) error {
+	var missing []string
+	for _, f := range pack.Files {
+		if pack.Bundled(f) {
+			continue
+		}
+		if err := m.downloader.Check(f); err != nil {
+			missing = append(missing, err.Error())
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(missing) > 5 {
+		missing = append(missing[:5], fmt.Sprintf("and %d more", len(missing)-5))
+	}
+	return &catalog.ValidationError{Message: "modpack files are unavailable: " + strings.Join(missing, "; ")}
+}
+
+// ImportModpack creates a server for a pack's loader and Minecraft version
+// with the pack's files in its data directory before it first starts.
+func (m *Manager) ImportModpack(ctx context.Context, cfg ServerConfig, pack *modpack.Pack, progress Progress) (string, error) {
+	if err := m.CheckModpack(pack); err != nil {
+		return "", err
+	}
+	cfg = ModpackConfig(cfg, pack)
+	cfg.populate = func(ctx context.Context, st Storage, progress Progress) error {
+		return m.installModpack(ctx, st, pack, progress)
+	}
+	log.Printf("Importing %s modpack %s %s (%s %s, Minecraft %s)",
+		pack.Format, pack.Name, pack.Version, pack.Loader, pack.LoaderVersion, pack.MinecraftVersion)
+	return m.CreateServer(ctx, cfg, progress)
+}
+
+// installModpack downloads the files a pack lists and unpacks its overrides
+// into st as a single archive, so volumes need only one helper container.
+func (m *Manager) installModpack(ctx context.Context, st Storage, pack *modpack.Pack, progress Progress) error {
+	progress.Step("install modpack")
+
+	overrides := pack.Overrides()
+	var downloads []modpack.File
+	for _, f := range pack.Files {
+		if !pack.Bundled(f) {
+			downloads = append(downloads, f)
+		}
+	}
+	total := len(downloads) + len(overrides)
+
+	done := 0
+	err := m.streamData(ctx, st, func(a *dataArchive) error {
+		add := func(name string, mode, size int64, r io.Reader) error {
+			if err := a.add(name, mode, size, r); err != nil {
+				return err
+			}
+			done++
+			progress.Update(done*100/total, name)
+			return nil
+		}
+
+		for _, f := range downloads {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			resolved, data, err := m.downloader.Fetch(ctx, f)
+			if err != nil {
+				return err
+			}
+			if err := resolved.Verify(data); err != nil {
+				return err
+			}
+			if err := add(resolved.Path, 0644, int64(len(data)), bytes.NewReader(data)); err != nil {
+				return err
+			}
+		}
+
+		// Overrides go last so they replace downloaded files
+		for _, o := range overrides {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rc, err := o.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read %s from the modpack: %v", o.Path, err)
+			}
+			err = add(o.Path, o.Mode, o.Size, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to install modpack: %v", err)
+	}
+	log.Printf("Installed %d downloaded and %d bundled modpack files into %s", len(downloads), len(overrides), st.Source)
+	return nil
+}
@@ -0,0 +1,754 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// Proxies a network can run
+const (
+	ProxyVelocity   = "velocity"
+	ProxyBungeeCord = "bungeecord"
+)
+
+// proxyImage runs Velocity or BungeeCord depending on TYPE.
+const proxyImage = "itzg/mc-proxy:latest"
+
+// proxyLabel carries the network ID on proxy containers, which are named
+// like servers but are not listed as servers.
+const proxyLabel = "mboxmini.proxy"
+
+// proxyPort is the port proxies listen on inside their container.
+const proxyPort = nat.Port("25577/tcp")
+
+// defaultProxyMemory is the heap of proxies that don't specify one.
+const defaultProxyMemory = "512M"
+
+// Files written into the proxy's /server directory
+const (
+	velocityConfigPath = "velocity.toml"
+	velocitySecretPath = "forwarding.secret"
+	bungeeConfigPath   = "config.yml"
+	paperGlobalPath    = "config/paper-global.yml"
+	spigotConfigPath   = "spigot.yml"
+	proxyMountTarget   = "/server"
+)
+
+// aliasPattern is what proxies accept as a server name.
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// networkTypes are the backend server types each proxy can forward player
+// identities to. Velocity's modern forwarding needs Paper or a fork of it.
+var networkTypes = map[string][]string{
+	ProxyVelocity:   {"PAPER", "PURPUR", "FOLIA"},
+	ProxyBungeeCord: {"PAPER", "PURPUR", "FOLIA", "SPIGOT"},
+}
+
+// NetworkConfig describes a network to create. Port requests a specific host
+// port for the proxy; 0 picks one from the Java port range.
+type NetworkConfig struct {
+	Name    string
+	Proxy   string
+	Memory  string
+	Port    int
+	Storage string
+	OwnerID int64
+}
+
+// NetworkInfo is a network with the state of its proxy and members.
+type NetworkInfo struct {
+	database.Network
+	Status  string              `json:"status"`
+	Port    int                 `json:"port"`
+	Address string              `json:"address,omitempty"`
+	Servers []NetworkServerInfo `json:"servers"`
+}
+
+type NetworkServerInfo struct {
+	ServerID string `json:"serverId"`
+	Name     string `json:"name"`
+	Alias    string `json:"alias"`
+	Lobby    bool   `json:"lobby"`
+	Status   string `json:"status"`
+}
+
+// proxyContainerName is the container running a network's proxy.
+func proxyContainerName(name string) string {
+	return "mboxmini-proxy-" + strings.TrimPrefix(ContainerName(name), "mboxmini-")
+}
+
+// dockerNetworkName is the private network a proxy reaches its servers on.
+func dockerNetworkName(name string) string {
+	return "mboxmini-net-" + strings.TrimPrefix(ContainerName(name), "mboxmini-")
+}
+
+// defaultAlias derives a proxy server name from a server's display name.
+func defaultAlias(name string) string {
+	alias := strings.Trim(regexp.MustCompile(`[^a-z0-9_-]+`).ReplaceAllString(strings.ToLower(name), "-"), "-_")
+	if len(alias) > 32 {
+		alias = alias[:32]
+	}
+	return alias
+}
+
+func newForwardingSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ResolveNetwork looks a network up by ID or name.
+func (m *Manager) ResolveNetwork(idOrName string) (*database.Network, error) {
+	network, err := m.db.GetNetwork(idOrName)
+	if err != nil || network != nil {
+		return network, err
+	}
+	network, err = m.db.GetNetworkByName(idOrName)
+	if err != nil {
+		return nil, err
+	}
+	if network == nil {
+		return nil, fmt.Errorf("network %s not found", idOrName)
+	}
+	return network, nil
+}
+
+// ValidateNetworkConfig checks a network config before any work is done.
+func (m *Manager) ValidateNetworkConfig(cfg NetworkConfig) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+	}
+
+	if err := ValidateNetworkName(cfg.Name); err != nil {
+		return err
+	}
+	if _, ok := networkTypes[cfg.Proxy]; !ok && cfg.Proxy != "" {
+		return invalid("unknown proxy %q (expected %s or %s)", cfg.Proxy, ProxyVelocity, ProxyBungeeCord)
+	}
+	if err := ValidateStorage(cfg.Storage); err != nil {
+		return invalid("%v", err)
+	}
+	if cfg.Memory != "" {
+		if _, err := ParseMemory(cfg.Memory); err != nil {
+			return invalid("invalid memory: %v", err)
+		}
+	}
+
+	if existing, err := m.db.GetNetworkByName(cfg.Name); err != nil {
+		return err
+	} else if existing != nil {
+		return invalid("a network named %s already exists", cfg.Name)
+	}
+	if err := m.checkContainerName(context.Background(), proxyContainerName(cfg.Name)); err != nil {
+		return err
+	}
+
+	if cfg.Port != 0 {
+		if err := m.checkPort(context.Background(), cfg.Port, gamePort.Proto()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateNetwork creates the private Docker network and starts a proxy on a
+// public port. Servers are added with AddNetworkServer.
+func (m *Manager) CreateNetwork(ctx context.Context, cfg NetworkConfig, progress Progress) (*database.Network, error) {
+	progress = orNoProgress(progress)
+	if cfg.Proxy == "" {
+		cfg.Proxy = ProxyVelocity
+	}
+	if cfg.Memory == "" {
+		cfg.Memory = defaultProxyMemory
+	}
+	if err := m.ValidateNetworkConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	id, err := database.NewNetworkID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate network ID: %v", err)
+	}
+	secret, err := newForwardingSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate forwarding secret: %v", err)
+	}
+	record := &database.Network{
+		ID:               id,
+		Name:             cfg.Name,
+		ProxyType:        cfg.Proxy,
+		ContainerName:    proxyContainerName(cfg.Name),
+		DockerNetwork:    dockerNetworkName(cfg.Name),
+		ForwardingSecret: secret,
+		OwnerID:          cfg.OwnerID,
+	}
+
+	// Undo everything created so far unless the network is registered
+	var cleanups []func()
+	registered := false
+	defer func() {
+		if registered {
+			return
+		}
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
+
+	progress.Step("allocate port")
+	port, err := m.allocatePort(ctx, id, EditionJava, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Allocated port %d for network %s", port, cfg.Name)
+	cleanups = append(cleanups, func() {
+		if err := m.db.ReleaseServerPorts(id); err != nil {
+			log.Printf("Error releasing port %d: %v", port, err)
+		}
+	})
+
+	progress.Step("create network")
+	if _, err := m.client.NetworkCreate(ctx, record.DockerNetwork, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         map[string]string{proxyLabel: id},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create Docker network: %v", err)
+	}
+	cleanups = append(cleanups, func() {
+		if err := m.client.NetworkRemove(context.Background(), record.DockerNetwork); err != nil {
+			log.Printf("Error removing Docker network %s: %v", record.DockerNetwork, err)
+		}
+	})
+
+	progress.Step("create data directory")
+	storage, err := m.provisionData(ctx, record.ContainerName, id, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	cleanups = append(cleanups, func() {
+		if err := m.removeData(context.Background(), storage); err != nil {
+			log.Printf("Error removing proxy data %s: %v", storage.Source, err)
+		}
+	})
+	if err := m.writeProxyConfig(ctx, record, storage); err != nil {
+		return nil, err
+	}
+
+	progress.Step("pull proxy image")
+	if err := m.ensureImage(ctx, proxyImage, progress); err != nil {
+		return nil, err
+	}
+
+	progress.Step("create proxy container")
+	proxyMount := storage.mount()
+	proxyMount.Target = proxyMountTarget
+	env := append([]string{
+		fmt.Sprintf("TYPE=%s", strings.ToUpper(cfg.Proxy)),
+		fmt.Sprintf("MEMORY=%s", cfg.Memory),
+	}, m.ownerEnv()...)
+	if _, err := m.client.ContainerCreate(ctx,
+		&container.Config{
+			Image:  proxyImage,
+			Env:    env,
+			Labels: map[string]string{proxyLabel: id},
+		},
+		&container.HostConfig{
+			Mounts:      []mount.Mount{proxyMount},
+			NetworkMode: container.NetworkMode(record.DockerNetwork),
+			PortBindings: nat.PortMap{
+				proxyPort: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", port)}},
+			},
+		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{record.DockerNetwork: {}},
+		},
+		nil, record.ContainerName); err != nil {
+		log.Printf("Error creating proxy container: %v", err)
+		return nil, fmt.Errorf("failed to create proxy container: %v", err)
+	}
+	cleanups = append(cleanups, func() {
+		m.client.ContainerRemove(context.Background(), record.ContainerName, types.ContainerRemoveOptions{Force: true})
+	})
+
+	if err := m.db.CreateNetwork(record); err != nil {
+		return nil, fmt.Errorf("failed to register network: %v", err)
+	}
+	cleanups = append(cleanups, func() { m.db.DeleteNetwork(id) })
+
+	progress.Step("start proxy")
+	if err := m.client.ContainerStart(ctx, record.ContainerName, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start proxy: %v", err)
+	}
+	registered = true
+
+	log.Printf("Network %s created with %s proxy on port %d", cfg.Name, cfg.Proxy, port)
+	return record, nil
+}
+
+// DeleteNetwork gives every member server its own public port again and
+// removes the proxy, its data and the Docker network.
+func (m *Manager) DeleteNetwork(ctx context.Context, networkID string) error {
+	record, err := m.ResolveNetwork(networkID)
+	if err != nil {
+		return err
+	}
+
+	members, err := m.db.ListNetworkServers(record.ID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := m.RemoveNetworkServer(ctx, record.ID, member.ServerID); err != nil {
+			return fmt.Errorf("failed to remove server %s from the network: %v", member.Alias, err)
+		}
+	}
+
+	if inspect, err := m.client.ContainerInspect(ctx, record.ContainerName); err == nil {
+		if err := m.client.ContainerRemove(ctx, inspect.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return fmt.Errorf("failed to remove proxy container: %v", err)
+		}
+		if storage, err := storageAt(inspect.Mounts, proxyMountTarget); err == nil {
+			if err := m.removeData(ctx, storage); err != nil {
+				log.Printf("Error removing proxy data of %s: %v", record.Name, err)
+			}
+		}
+	} else {
+		log.Printf("Proxy container %s not found: %v", record.ContainerName, err)
+	}
+
+	if err := m.client.NetworkRemove(ctx, record.DockerNetwork); err != nil {
+		log.Printf("Error removing Docker network %s: %v", record.DockerNetwork, err)
+	}
+	if err := m.db.ReleaseServerPorts(record.ID); err != nil {
+		log.Printf("Error releasing ports of network %s: %v", record.Name, err)
+	}
+	if err := m.db.DeleteNetwork(record.ID); err != nil {
+		return fmt.Errorf("failed to delete network: %v", err)
+	}
+	log.Printf("Network %s deleted", record.Name)
+	return nil
+}
+
+// AddNetworkServer moves a server behind a network's proxy: its public port
+// is released, it joins the private network, and it is configured to accept
+// the player identities the proxy forwards. A running server is restarted.
+func (m *Manager) AddNetworkServer(ctx context.Context, networkID, serverID, alias string, lobby bool) error {
+	record, err := m.ResolveNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+	server, err := m.serverRecord(inspect.ID, containerName, inspect.Config.Labels)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := m.db.GetServerNetwork(server.ID); err != nil {
+		return err
+	} else if existing != nil {
+		return &catalog.ValidationError{Message: fmt.Sprintf("server %s is already in a network", server.Name)}
+	}
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) != EditionJava {
+		return &catalog.ValidationError{Message: "only Java Edition servers can join a network"}
+	}
+	serverType := catalog.NormalizeType(envValue(inspect.Config.Env, "TYPE"))
+	supported := false
+	for _, t := range networkTypes[record.ProxyType] {
+		supported = supported || t == serverType
+	}
+	if !supported {
+		return &catalog.ValidationError{Message: fmt.Sprintf("%s networks need a %s server, not %s",
+			record.ProxyType, strings.Join(networkTypes[record.ProxyType], ", "), serverType)}
+	}
+
+	if alias == "" {
+		alias = defaultAlias(server.Name)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return &catalog.ValidationError{Message: fmt.Sprintf("invalid alias %q (lowercase letters, digits, - and _)", alias)}
+	}
+	members, err := m.db.ListNetworkServers(record.ID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Alias == alias {
+			return &catalog.ValidationError{Message: fmt.Sprintf("alias %s is already used in network %s", alias, record.Name)}
+		}
+	}
+
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return err
+	}
+	if err := m.setForwarding(ctx, storage, record, true); err != nil {
+		return err
+	}
+
+	// The proxy authenticates players; the server only trusts the proxy.
+	// Its own setting is restored when it leaves.
+	var onlineMode *string
+	if value, ok := lookupEnv(inspect.Config.Env, "ONLINE_MODE"); ok {
+		onlineMode = &value
+	}
+	log.Printf("Moving %s behind the proxy of network %s", containerName, record.Name)
+	oldPort := hostPort(inspect.HostConfig.PortBindings, gamePort)
+	if err := m.recreateContainer(ctx, containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		cfg.Env = setEnv(cfg.Env, "ONLINE_MODE", "FALSE")
+		delete(hostConfig.PortBindings, gamePort)
+	}, false); err != nil {
+		return err
+	}
+	if err := m.client.NetworkConnect(ctx, record.DockerNetwork, containerName, nil); err != nil {
+		return fmt.Errorf("failed to connect to network: %v", err)
+	}
+	if inspect.State.Running {
+		if err := m.client.ContainerStart(ctx, containerName, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %v", err)
+		}
+	}
+	if oldPort != 0 {
+		if err := m.db.ReleasePort(oldPort, gamePort.Proto()); err != nil {
+			log.Printf("Error releasing port %d: %v", oldPort, err)
+		}
+	}
+
+	if err := m.db.AddNetworkServer(&database.NetworkServer{NetworkID: record.ID, ServerID: server.ID, Alias: alias, OnlineMode: onlineMode}); err != nil {
+		return fmt.Errorf("failed to record network member: %v", err)
+	}
+	if lobby || record.LobbyServerID == "" {
+		if err := m.db.SetNetworkLobby(record.ID, server.ID); err != nil {
+			return err
+		}
+		record.LobbyServerID = server.ID
+	}
+	return m.refreshProxy(ctx, record)
+}
+
+// RemoveNetworkServer takes a server out of a network and publishes it on a
+// port of its own again. A running server is restarted.
+func (m *Manager) RemoveNetworkServer(ctx context.Context, networkID, serverID string) error {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+	server, err := m.serverRecord(inspect.ID, containerName, inspect.Config.Labels)
+	if err != nil {
+		return err
+	}
+
+	member, err := m.db.GetServerNetwork(server.ID)
+	if err != nil {
+		return err
+	}
+	record, err := m.ResolveNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	if member == nil || member.NetworkID != record.ID {
+		return &catalog.ValidationError{Message: fmt.Sprintf("server %s is not in network %s", server.Name, record.Name)}
+	}
+
+	port, err := m.allocatePort(ctx, server.ID, EditionJava, 0)
+	if err != nil {
+		return err
+	}
+	if storage, err := storageOf(inspect.Mounts); err == nil {
+		if err := m.setForwarding(ctx, storage, record, false); err != nil {
+			log.Printf("Error disabling proxy forwarding on %s: %v", containerName, err)
+		}
+	}
+
+	log.Printf("Removing %s from network %s on port %d", containerName, record.Name, port)
+	if err := m.client.NetworkDisconnect(ctx, record.DockerNetwork, inspect.ID, true); err != nil {
+		log.Printf("Error disconnecting %s from %s: %v", containerName, record.DockerNetwork, err)
+	}
+	if err := m.recreateContainer(ctx, containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		if member.OnlineMode != nil {
+			cfg.Env = setEnv(cfg.Env, "ONLINE_MODE", *member.OnlineMode)
+		} else {
+			cfg.Env = removeEnv(cfg.Env, "ONLINE_MODE")
+		}
+		if hostConfig.PortBindings == nil {
+			hostConfig.PortBindings = nat.PortMap{}
+		}
+		hostConfig.PortBindings[gamePort] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", port)}}
+	}, inspect.State.Running); err != nil {
+		m.db.ReleasePort(port, gamePort.Proto())
+		return err
+	}
+
+	if err := m.db.RemoveNetworkServer(server.ID); err != nil {
+		return err
+	}
+	return m.refreshProxy(ctx, record)
+}
+
+// forgetNetworkServer drops a deleted server from its network, if any.
+func (m *Manager) forgetNetworkServer(ctx context.Context, serverID string) {
+	member, err := m.db.GetServerNetwork(serverID)
+	if err != nil || member == nil {
+		return
+	}
+	if err := m.db.RemoveNetworkServer(serverID); err != nil {
+		log.Printf("Error removing server %s from its network: %v", serverID, err)
+		return
+	}
+	if record, err := m.db.GetNetwork(member.NetworkID); err == nil && record != nil {
+		if err := m.refreshProxy(ctx, record); err != nil {
+			log.Printf("Error updating proxy of network %s: %v", record.Name, err)
+		}
+	}
+}
+
+// setForwarding turns the backend side of the proxy's player forwarding on
+// or off in a server's config files, creating them if the server hasn't
+// written them yet.
+func (m *Manager) setForwarding(ctx context.Context, st Storage, record *database.Network, enabled bool) error {
+	path := paperGlobalPath
+	values := map[string]string{}
+	if record.ProxyType == ProxyVelocity {
+		values["proxies.velocity.enabled"] = strconv.FormatBool(enabled)
+		values["proxies.velocity.online-mode"] = "true"
+		if enabled {
+			values["proxies.velocity.secret"] = yamlString(record.ForwardingSecret)
+		} else {
+			values["proxies.velocity.secret"] = "''"
+		}
+	} else {
+		path = spigotConfigPath
+		values["settings.bungeecord"] = strconv.FormatBool(enabled)
+	}
+
+	content, err := m.readDataFile(ctx, st, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	doc := string(content)
+	for key, value := range values {
+		doc = setYAMLValue(doc, strings.Split(key, "."), value)
+	}
+	if err := m.writeDataFile(ctx, st, path, []byte(doc)); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// refreshProxy rewrites the proxy's server list and restarts it if it is
+// running so the change takes effect.
+func (m *Manager) refreshProxy(ctx context.Context, record *database.Network) error {
+	inspect, err := m.client.ContainerInspect(ctx, record.ContainerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect proxy: %v", err)
+	}
+	storage, err := storageAt(inspect.Mounts, proxyMountTarget)
+	if err != nil {
+		return err
+	}
+	if err := m.writeProxyConfig(ctx, record, storage); err != nil {
+		return err
+	}
+
+	if inspect.State.Running {
+		log.Printf("Restarting proxy %s", record.ContainerName)
+		timeout := 10
+		if err := m.client.ContainerRestart(ctx, inspect.ID, container.StopOptions{Timeout: &timeout}); err != nil {
+			return fmt.Errorf("failed to restart proxy: %v", err)
+		}
+	}
+	return nil
+}
+
+// proxyServer is a member as the proxy config lists it.
+type proxyServer struct {
+	Alias   string
+	Address string
+}
+
+// proxyServers lists the members of a network with the lobby first, which
+// is the order proxies try them in.
+func (m *Manager) proxyServers(record *database.Network) ([]proxyServer, error) {
+	members, err := m.db.ListNetworkServers(record.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []proxyServer
+	for _, member := range members {
+		server, err := m.db.GetServer(member.ServerID)
+		if err != nil {
+			return nil, err
+		}
+		if server == nil {
+			continue
+		}
+		entry := proxyServer{Alias: member.Alias, Address: fmt.Sprintf("%s:%s", server.ContainerName, gamePort.Port())}
+		if member.ServerID == record.LobbyServerID {
+			servers = append([]proxyServer{entry}, servers...)
+		} else {
+			servers = append(servers, entry)
+		}
+	}
+	return servers, nil
+}
+
+// writeProxyConfig generates the proxy's config from the network members.
+func (m *Manager) writeProxyConfig(ctx context.Context, record *database.Network, st Storage) error {
+	servers, err := m.proxyServers(record)
+	if err != nil {
+		return err
+	}
+
+	if record.ProxyType == ProxyBungeeCord {
+		return m.writeDataFile(ctx, st, bungeeConfigPath, []byte(bungeeConfig(record, servers)))
+	}
+	if err := m.writeDataFile(ctx, st, velocitySecretPath, []byte(record.ForwardingSecret)); err != nil {
+		return err
+	}
+	return m.writeDataFile(ctx, st, velocityConfigPath, []byte(velocityConfig(record, servers)))
+}
+
+func velocityConfig(record *database.Network, servers []proxyServer) string {
+	var list, try strings.Builder
+	for i, server := range servers {
+		fmt.Fprintf(&list, "%s = %s\n", server.Alias, strconv.Quote(server.Address))
+		if i > 0 {
+			try.WriteString(", ")
+		}
+		try.WriteString(strconv.Quote(server.Alias))
+	}
+
+	return fmt.Sprintf(`# Generated by MboxMini from the servers in network %[1]s; changes are overwritten.
+config-version = "2.7"
+bind = "0.0.0.0:%[2]s"
+motd = %[3]s
+show-max-players = 500
+online-mode = true
+force-key-authentication = true
+player-info-forwarding-mode = "modern"
+forwarding-secret-file = "%[4]s"
+announce-forge = false
+kick-existing-players = false
+ping-passthrough = "DISABLED"
+
+[servers]
+%[5]stry = [%[6]s]
+
+[forced-hosts]
+
+[advanced]
+failover-on-unexpected-server-disconnect = true
+
+[query]
+enabled = false
+`, record.Name, proxyPort.Port(), strconv.Quote(record.Name), velocitySecretPath, list.String(), try.String())
+}
+
+func bungeeConfig(record *database.Network, servers []proxyServer) string {
+	var priorities, list strings.Builder
+	for _, server := range servers {
+		fmt.Fprintf(&priorities, "  - %s\n", server.Alias)
+		fmt.Fprintf(&list, "  %s:\n    address: %s\n    motd: %s\n    restricted: false\n", server.Alias, server.Address, yamlString(server.Alias))
+	}
+	if list.Len() == 0 {
+		list.WriteString("  {}\n")
+	}
+
+	return fmt.Sprintf(`# Generated by MboxMini from the servers in network %[1]s; changes are overwritten.
+listeners:
+- host: 0.0.0.0:%[2]s
+  motd: %[3]s
+  max_players: 500
+  priorities:
+%[4]s  force_default_server: false
+  forced_hosts: {}
+  tab_list: GLOBAL_PING
+  query_enabled: false
+  ping_passthrough: false
+servers:
+%[5]sip_forward: true
+online_mode: true
+`, record.Name, proxyPort.Port(), yamlString(record.Name), priorities.String(), list.String())
+}
+
+// ListNetworks returns every network with its proxy state and members.
+func (m *Manager) ListNetworks(ctx context.Context) ([]NetworkInfo, error) {
+	records, err := m.db.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	networks := []NetworkInfo{}
+	for i := range records {
+		info, err := m.networkInfo(ctx, &records[i])
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, *info)
+	}
+	return networks, nil
+}
+
+func (m *Manager) GetNetwork(ctx context.Context, networkID string) (*NetworkInfo, error) {
+	record, err := m.ResolveNetwork(networkID)
+	if err != nil {
+		return nil, err
+	}
+	return m.networkInfo(ctx, record)
+}
+
+func (m *Manager) networkInfo(ctx context.Context, record *database.Network) (*NetworkInfo, error) {
+	info := &NetworkInfo{Network: *record, Status: "missing", Servers: []NetworkServerInfo{}}
+	if inspect, err := m.client.ContainerInspect(ctx, record.ContainerName); err == nil {
+		info.Status = inspect.State.Status
+		info.Port = hostPort(inspect.HostConfig.PortBindings, proxyPort)
+		if host := m.db.SettingString("public_host"); host != "" && info.Port != 0 {
+			info.Address = fmt.Sprintf("%s:%d", host, info.Port)
+		}
+	} else {
+		log.Printf("Error inspecting proxy %s: %v", record.ContainerName, err)
+	}
+
+	members, err := m.db.ListNetworkServers(record.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		server := NetworkServerInfo{
+			ServerID: member.ServerID,
+			Alias:    member.Alias,
+			Lobby:    member.ServerID == record.LobbyServerID,
+			Status:   "missing",
+		}
+		if s, err := m.db.GetServer(member.ServerID); err == nil && s != nil {
+			server.Name = s.Name
+			if inspect, err := m.client.ContainerInspect(ctx, s.ContainerName); err == nil {
+				server.Status = inspect.State.Status
+			}
+		}
+		info.Servers = append(info.Servers, server)
+	}
+	return info, nil
+}
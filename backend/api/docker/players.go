@@ -0,0 +1,453 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/mojang"
+)
+
+// Access lists a Java server keeps in its data directory
+const (
+	AccessWhitelist = "whitelist"
+	AccessOps       = "ops"
+	AccessBans      = "bans"
+	AccessIPBans    = "ip-bans"
+)
+
+// accessList is an access list's file and the console commands that change
+// it; %s is the player name or IP.
+type accessList struct {
+	file   string
+	add    string
+	remove string
+	byIP   bool
+}
+
+var accessLists = map[string]accessList{
+	AccessWhitelist: {file: "whitelist.json", add: "whitelist add %s", remove: "whitelist remove %s"},
+	AccessOps:       {file: "ops.json", add: "op %s", remove: "deop %s"},
+	AccessBans:      {file: "banned-players.json", add: "ban %s", remove: "pardon %s"},
+	AccessIPBans:    {file: "banned-ips.json", add: "ban-ip %s", remove: "pardon-ip %s", byIP: true},
+}
+
+// profileCacheTTL is how long a looked up player UUID is trusted.
+const profileCacheTTL = 30 * 24 * time.Hour
+
+// banTimeFormat is how the server writes ban times.
+const banTimeFormat = "2006-01-02 15:04:05 -0700"
+
+var playerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+
+// AccessEntry is an entry of whitelist.json, ops.json, banned-players.json
+// or banned-ips.json, in the server's own format.
+type AccessEntry struct {
+	UUID                string `json:"uuid,omitempty"`
+	Name                string `json:"name,omitempty"`
+	IP                  string `json:"ip,omitempty"`
+	Level               int    `json:"level,omitempty"`
+	BypassesPlayerLimit bool   `json:"bypassesPlayerLimit,omitempty"`
+	Created             string `json:"created,omitempty"`
+	Source              string `json:"source,omitempty"`
+	Expires             string `json:"expires,omitempty"`
+	Reason              string `json:"reason,omitempty"`
+}
+
+// AccessRequest adds a player, or for IP bans an address, to an access
+// list. Level is the permission level of an op and Reason the reason for a
+// ban.
+type AccessRequest struct {
+	Name   string `json:"name"`
+	IP     string `json:"ip"`
+	Level  int    `json:"level"`
+	Reason string `json:"reason"`
+}
+
+// AccessEntryNotFoundError is returned when removing a player or IP that
+// isn't on an access list.
+type AccessEntryNotFoundError struct {
+	List string
+	Key  string
+}
+
+func (e *AccessEntryNotFoundError) Error() string {
+	return fmt.Sprintf("%s is not on the %s list", e.Key, e.List)
+}
+
+// accessServer is what access list changes need to know about a server.
+type accessServer struct {
+	id            string
+	containerName string
+	storage       Storage
+	env           []string
+	running       bool
+}
+
+func (m *Manager) accessServer(ctx context.Context, serverID string) (*accessServer, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		return nil, &catalog.ValidationError{Message: "access lists are only supported on Java Edition servers"}
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+	record, err := m.serverRecord(inspect.ID, containerName, inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return nil, err
+	}
+	return &accessServer{
+		id:            record.ID,
+		containerName: containerName,
+		storage:       storage,
+		env:           inspect.Config.Env,
+		running:       inspect.State.Running,
+	}, nil
+}
+
+func lookupAccessList(list string) (accessList, error) {
+	l, ok := accessLists[list]
+	if !ok {
+		return accessList{}, &catalog.ValidationError{Message: fmt.Sprintf("unknown access list %q: use whitelist, ops, bans or ip-bans", list)}
+	}
+	return l, nil
+}
+
+// ListAccess returns the entries of one of a server's access lists.
+func (m *Manager) ListAccess(ctx context.Context, serverID, list string) ([]AccessEntry, error) {
+	l, err := lookupAccessList(list)
+	if err != nil {
+		return nil, err
+	}
+	server, err := m.accessServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return m.readAccessList(ctx, server, l)
+}
+
+func (m *Manager) readAccessList(ctx context.Context, server *accessServer, l accessList) ([]AccessEntry, error) {
+	entries := []AccessEntry{}
+	content, err := m.readDataFile(ctx, server.storage, l.file)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", l.file, err)
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", l.file, err)
+	}
+	return entries, nil
+}
+
+func (m *Manager) writeAccessList(ctx context.Context, server *accessServer, l accessList, entries []AccessEntry) error {
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := m.writeDataFile(ctx, server.storage, l.file, content); err != nil {
+		return fmt.Errorf("failed to write %s: %v", l.file, err)
+	}
+	return nil
+}
+
+// findAccessEntry returns the index of the entry for a name, matched without
+// regard to case, or IP, or -1.
+func findAccessEntry(entries []AccessEntry, l accessList, key string) int {
+	for i, entry := range entries {
+		if l.byIP && entry.IP == key || !l.byIP && strings.EqualFold(entry.Name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddAccess adds a player or IP to an access list and returns the updated
+// list. A running server is changed through its console so the change
+// applies at once; a stopped server has its file edited, with player UUIDs
+// looked up from Mojang, or derived from the name in offline mode.
+func (m *Manager) AddAccess(ctx context.Context, serverID, list string, req AccessRequest) ([]AccessEntry, error) {
+	l, err := lookupAccessList(list)
+	if err != nil {
+		return nil, err
+	}
+	key := req.Name
+	if l.byIP {
+		key = req.IP
+		if net.ParseIP(key) == nil {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("invalid IP address %q", key)}
+		}
+	} else if !playerNamePattern.MatchString(key) {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("invalid player name %q", key)}
+	}
+	if list == AccessOps && (req.Level < 0 || req.Level > 4) {
+		return nil, &catalog.ValidationError{Message: "op level must be between 1 and 4"}
+	}
+	if len(req.Reason) > 256 || strings.IndexFunc(req.Reason, unicode.IsControl) >= 0 {
+		return nil, &catalog.ValidationError{Message: "reason must be at most 256 printable characters"}
+	}
+
+	server, err := m.accessServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if server.running {
+		return m.addAccessLive(ctx, server, list, l, key, req)
+	}
+
+	entries, err := m.readAccessList(ctx, server, l)
+	if err != nil {
+		return nil, err
+	}
+	i := findAccessEntry(entries, l, key)
+	if i < 0 {
+		entry := AccessEntry{}
+		if l.byIP {
+			entry.IP = key
+		} else {
+			profile, err := m.resolveProfile(ctx, server, key)
+			if err != nil {
+				return nil, err
+			}
+			entry.UUID, entry.Name = profile.UUID, profile.Name
+		}
+		entries = append(entries, entry)
+		i = len(entries) - 1
+	}
+
+	switch list {
+	case AccessOps:
+		entries[i].Level = req.Level
+		if entries[i].Level == 0 {
+			entries[i].Level = m.opLevel(ctx, server)
+		}
+	case AccessBans, AccessIPBans:
+		entries[i].Created = time.Now().Format(banTimeFormat)
+		entries[i].Source = "mboxmini"
+		entries[i].Expires = "forever"
+		entries[i].Reason = req.Reason
+		if entries[i].Reason == "" {
+			entries[i].Reason = "Banned by an operator."
+		}
+	}
+
+	if err := m.writeAccessList(ctx, server, l, entries); err != nil {
+		return nil, err
+	}
+	log.Printf("Added %s to the %s list of stopped server %s", key, list, server.containerName)
+	return entries, nil
+}
+
+// addAccessLive runs the console command for an addition and checks the
+// file the server saves to see whether it worked.
+func (m *Manager) addAccessLive(ctx context.Context, server *accessServer, list string, l accessList, key string, req AccessRequest) ([]AccessEntry, error) {
+	command := fmt.Sprintf(l.add, key)
+	if (list == AccessBans || list == AccessIPBans) && req.Reason != "" {
+		command += " " + req.Reason
+	}
+	output, err := m.ExecuteCommand(ctx, server.containerName, command)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := m.readAccessList(ctx, server, l)
+	if err != nil {
+		return nil, err
+	}
+	i := findAccessEntry(entries, l, key)
+	if i < 0 {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("server did not add %s: %s", key, strings.TrimSpace(output))}
+	}
+
+	// The op command can't set a level, so it goes into the file, which
+	// the server only reads when it starts
+	if list == AccessOps && req.Level != 0 && entries[i].Level != req.Level {
+		entries[i].Level = req.Level
+		if err := m.writeAccessList(ctx, server, l, entries); err != nil {
+			return nil, err
+		}
+		m.markRestartRequired(server.id)
+	}
+	log.Printf("Added %s to the %s list of %s: %s", key, list, server.containerName, strings.TrimSpace(output))
+	return entries, nil
+}
+
+// RemoveAccess removes a player or IP from an access list and returns the
+// updated list.
+func (m *Manager) RemoveAccess(ctx context.Context, serverID, list, key string) ([]AccessEntry, error) {
+	l, err := lookupAccessList(list)
+	if err != nil {
+		return nil, err
+	}
+	server, err := m.accessServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.readAccessList(ctx, server, l)
+	if err != nil {
+		return nil, err
+	}
+	i := findAccessEntry(entries, l, key)
+	if i < 0 {
+		return nil, &AccessEntryNotFoundError{List: list, Key: key}
+	}
+
+	if !server.running {
+		entries = append(entries[:i], entries[i+1:]...)
+		if err := m.writeAccessList(ctx, server, l, entries); err != nil {
+			return nil, err
+		}
+		log.Printf("Removed %s from the %s list of stopped server %s", key, list, server.containerName)
+		return entries, nil
+	}
+
+	target := entries[i].Name
+	if l.byIP {
+		target = entries[i].IP
+	}
+	output, err := m.ExecuteCommand(ctx, server.containerName, fmt.Sprintf(l.remove, target))
+	if err != nil {
+		return nil, err
+	}
+	if entries, err = m.readAccessList(ctx, server, l); err != nil {
+		return nil, err
+	}
+	if findAccessEntry(entries, l, key) >= 0 {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("server did not remove %s: %s", key, strings.TrimSpace(output))}
+	}
+	log.Printf("Removed %s from the %s list of %s: %s", key, list, server.containerName, strings.TrimSpace(output))
+	return entries, nil
+}
+
+// serverProperty returns a server.properties value. The image rewrites the
+// file from its variables on every start, so a set variable wins.
+func (m *Manager) serverProperty(ctx context.Context, server *accessServer, key, envKey string) string {
+	if value := envValue(server.env, envKey); value != "" {
+		return value
+	}
+	content, err := m.readDataFile(ctx, server.storage, "server.properties")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// opLevel is the level the server gives new ops.
+func (m *Manager) opLevel(ctx context.Context, server *accessServer) int {
+	if level, err := strconv.Atoi(m.serverProperty(ctx, server, "op-permission-level", "OP_PERMISSION_LEVEL")); err == nil && level >= 1 && level <= 4 {
+		return level
+	}
+	return 4
+}
+
+// resolveProfile finds the UUID a server knows a player by, using the local
+// cache while it is fresh. Network members run with online-mode off but get
+// the real UUIDs forwarded by their proxy, which does the authentication.
+func (m *Manager) resolveProfile(ctx context.Context, server *accessServer, name string) (*mojang.Profile, error) {
+	member, err := m.db.GetServerNetwork(server.id)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil && strings.EqualFold(m.serverProperty(ctx, server, "online-mode", "ONLINE_MODE"), "false") {
+		return mojang.OfflineProfile(name), nil
+	}
+
+	cached, err := m.db.GetPlayerProfile(name)
+	if err != nil {
+		log.Printf("Error reading cached profile of %s: %v", name, err)
+	}
+	if cached != nil && time.Since(cached.FetchedAt) < profileCacheTTL {
+		return &mojang.Profile{Name: cached.Name, UUID: cached.UUID}, nil
+	}
+
+	profile, err := m.profiles.Lookup(ctx, name)
+	if err == mojang.ErrNotFound {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("no Minecraft account is named %s", name)}
+	}
+	if err != nil {
+		if cached != nil {
+			log.Printf("Using stale profile of %s: %v", name, err)
+			return &mojang.Profile{Name: cached.Name, UUID: cached.UUID}, nil
+		}
+		return nil, err
+	}
+	if err := m.db.SavePlayerProfile(&database.PlayerProfile{Name: profile.Name, UUID: profile.UUID}); err != nil {
+		log.Printf("Error caching profile of %s: %v", name, err)
+	}
+	return profile, nil
+}
+
+// PlayerModeration is where a player stands on a server's access lists.
+type PlayerModeration struct {
+	Whitelisted bool   `json:"whitelisted"`
+	Op          bool   `json:"op"`
+	OpLevel     int    `json:"opLevel,omitempty"`
+	Banned      bool   `json:"banned"`
+	BanReason   string `json:"banReason,omitempty"`
+}
+
+// PlayerModeration looks a player up in a Java server's whitelist, ops and
+// bans.
+func (m *Manager) PlayerModeration(ctx context.Context, serverID, name string) (*PlayerModeration, error) {
+	server, err := m.accessServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	lists := []accessList{accessLists[AccessWhitelist], accessLists[AccessOps], accessLists[AccessBans]}
+	paths := make([]string, len(lists))
+	for i, l := range lists {
+		paths[i] = l.file
+	}
+	files, err := m.readDataFiles(ctx, server.storage, paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access lists: %v", err)
+	}
+
+	entry := func(l accessList) *AccessEntry {
+		var entries []AccessEntry
+		if content, ok := files[l.file]; ok {
+			if err := json.Unmarshal(content, &entries); err != nil {
+				log.Printf("Error parsing %s of %s: %v", l.file, server.containerName, err)
+				return nil
+			}
+		}
+		if i := findAccessEntry(entries, l, name); i >= 0 {
+			return &entries[i]
+		}
+		return nil
+	}
+
+	moderation := &PlayerModeration{}
+	moderation.Whitelisted = entry(lists[0]) != nil
+	if op := entry(lists[1]); op != nil {
+		moderation.Op, moderation.OpLevel = true, op.Level
+	}
+	if ban := entry(lists[2]); ban != nil {
+		moderation.Banned, moderation.BanReason = true, ban.Reason
+	}
+	return moderation, nil
+}
@@ -0,0 +1,281 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// gamePort is the Java Edition port inside server containers.
+const gamePort = nat.Port("25565/tcp")
+
+// portHelperImage is the small image the host's bound ports are read with.
+const portHelperImage = "busybox:stable"
+
+// boundPortsTTL is how long the host's bound ports are reused for, so that
+// allocations close together run a single helper.
+const boundPortsTTL = 10 * time.Second
+
+type boundPorts struct {
+	ports   map[int]bool
+	fetched time.Time
+}
+
+// PortConflictError is returned when a requested port is already taken.
+type PortConflictError struct {
+	Message string
+}
+
+func (e *PortConflictError) Error() string {
+	return e.Message
+}
+
+// hostPort returns the host port bound to port, or 0 if it isn't published.
+func hostPort(bindings nat.PortMap, port nat.Port) int {
+	for _, binding := range bindings[port] {
+		if p, err := strconv.Atoi(binding.HostPort); err == nil && p > 0 {
+			return p
+		}
+	}
+	return 0
+}
+
+// syncPorts records the ports of existing servers, including stopped ones,
+// and frees reservations left behind by servers that no longer exist.
+func (m *Manager) syncPorts(ctx context.Context) error {
+	containers, err := m.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	for _, cont := range containers {
+		if !isServerContainer(cont) {
+			continue
+		}
+		inspect, err := m.client.ContainerInspect(ctx, cont.ID)
+		if err != nil {
+			log.Printf("Error inspecting %s for port sync: %v", cont.ID, err)
+			continue
+		}
+		name := strings.TrimPrefix(inspect.Name, "/")
+		record, err := m.serverRecord(inspect.ID, name, inspect.Config.Labels)
+		if err != nil {
+			return err
+		}
+
+		// Java servers with crossplay publish a Bedrock port as well
+		for _, containerPort := range []nat.Port{gamePort, bedrockPort} {
+			port := hostPort(inspect.HostConfig.PortBindings, containerPort)
+			if port == 0 {
+				continue
+			}
+			ok, err := m.db.AllocatePort(port, containerPort.Proto(), record.ID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if existing, err := m.db.GetPortAllocation(port, containerPort.Proto()); err == nil && existing != nil && existing.ServerID != record.ID {
+					log.Printf("Warning: %s publishes port %d/%s which is reserved for server %s", name, port, containerPort.Proto(), existing.ServerID)
+				}
+			}
+		}
+	}
+
+	released, err := m.db.ReleaseOrphanedPorts()
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		log.Printf("Released %d ports of deleted servers", released)
+	}
+	return nil
+}
+
+// allocatePort reserves a host port for a server: the requested one if
+// non-zero, otherwise the first free port in the edition's configured range.
+// Unlike checkPort it also skips ports bound by host processes, which takes
+// a helper container, so it belongs in jobs rather than request handlers.
+func (m *Manager) allocatePort(ctx context.Context, serverID, edition string, requested int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	proto := editionPort(edition).Proto()
+	if requested != 0 {
+		if err := m.checkPort(ctx, requested, proto); err != nil {
+			return 0, err
+		}
+		if !m.hostPortFree(ctx, proto)(requested) {
+			return 0, &PortConflictError{Message: fmt.Sprintf("port %d/%s is in use by another process", requested, proto)}
+		}
+		ok, err := m.db.AllocatePort(requested, proto, serverID)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, &PortConflictError{Message: fmt.Sprintf("port %d is already allocated", requested)}
+		}
+		return requested, nil
+	}
+
+	published := m.publishedPorts(ctx, proto)
+	portFree := m.hostPortFree(ctx, proto)
+	startKey, endKey := portRangeKeys(edition)
+	portStart, portEnd := m.db.SettingInt(startKey), m.db.SettingInt(endKey)
+	for port := portStart; port <= portEnd; port++ {
+		if _, exists := published[port]; exists {
+			continue
+		}
+		if existing, err := m.db.GetPortAllocation(port, proto); err != nil {
+			return 0, err
+		} else if existing != nil {
+			continue
+		}
+		if !portFree(port) {
+			continue
+		}
+
+		ok, err := m.db.AllocatePort(port, proto, serverID)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no available %s ports in range %d-%d", proto, portStart, portEnd)
+}
+
+// checkPort returns an error if port can't be given to a new server because
+// it is reserved or published by another container. Ports bound by host
+// processes are only found when allocatePort reserves the port.
+func (m *Manager) checkPort(ctx context.Context, port int, proto string) error {
+	if port < 1024 || port > 65535 {
+		return &catalog.ValidationError{Message: fmt.Sprintf("port %d is outside 1024-65535", port)}
+	}
+
+	existing, err := m.db.GetPortAllocation(port, proto)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		name := existing.ServerID
+		if server, err := m.db.GetServer(existing.ServerID); err == nil && server != nil {
+			name = server.Name
+		}
+		return &PortConflictError{Message: fmt.Sprintf("port %d is already allocated to server %s", port, name)}
+	}
+
+	if owner, exists := m.publishedPorts(ctx, proto)[port]; exists {
+		return &PortConflictError{Message: fmt.Sprintf("port %d/%s is already published by container %s", port, proto, owner)}
+	}
+	return nil
+}
+
+// publishedPorts maps the host ports of protocol proto published by running
+// containers to the container name.
+func (m *Manager) publishedPorts(ctx context.Context, proto string) map[int]string {
+	ports := map[int]string{}
+	containers, err := m.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Printf("Error listing containers for port check: %v", err)
+		return ports
+	}
+	for _, cont := range containers {
+		for _, p := range cont.Ports {
+			if p.PublicPort != 0 && p.Type == proto {
+				ports[int(p.PublicPort)] = strings.TrimPrefix(cont.Names[0], "/")
+			}
+		}
+	}
+	return ports
+}
+
+// hostPortFree returns a check for whether no process on the host is bound
+// to a port. The API usually runs in a container with a network of its own,
+// where binding a port says nothing about the host, so the bound ports are
+// read from /proc/net in a helper container on the host network. If that
+// fails, ports are only checked in the API's own network.
+func (m *Manager) hostPortFree(ctx context.Context, proto string) func(port int) bool {
+	bound, err := m.hostBoundPorts(ctx, proto)
+	if err != nil {
+		log.Printf("Error reading bound host ports, checking from the API's network only: %v", err)
+		return func(port int) bool { return localPortFree(port, proto) }
+	}
+	return func(port int) bool { return !bound[port] && localPortFree(port, proto) }
+}
+
+// hostBoundPorts returns the ports of protocol proto that host processes are
+// listening on, reusing a recent read. Callers must hold mu.
+func (m *Manager) hostBoundPorts(ctx context.Context, proto string) (map[int]bool, error) {
+	if cached, ok := m.boundPorts[proto]; ok && time.Since(cached.fetched) < boundPortsTTL {
+		return cached.ports, nil
+	}
+
+	files := fmt.Sprintf("/proc/net/%[1]s /proc/net/%[1]s6", proto)
+	out, err := m.helperOutputWith(ctx, portHelperImage, &container.HostConfig{NetworkMode: "host"},
+		"sh", "-c", "cat "+files+" 2>/dev/null; true")
+	if err != nil {
+		return nil, err
+	}
+	ports := parseProcNet(string(out), proto)
+	if m.boundPorts == nil {
+		m.boundPorts = map[string]boundPorts{}
+	}
+	m.boundPorts[proto] = boundPorts{ports: ports, fetched: time.Now()}
+	return ports, nil
+}
+
+// parseProcNet reads the local ports of /proc/net/tcp or udp style tables.
+// Only listening sockets count for TCP; every UDP socket is bound.
+func parseProcNet(table, proto string) map[int]bool {
+	const tcpListen = "0A"
+	ports := map[int]bool{}
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "sl" {
+			continue
+		}
+		if proto == "tcp" && fields[3] != tcpListen {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		if port, err := strconv.ParseInt(fields[1][i+1:], 16, 32); err == nil {
+			ports[int(port)] = true
+		}
+	}
+	return ports
+}
+
+// localPortFree reports whether port can be bound in the API's own network,
+// which is the host's only when the API doesn't run in a container.
+func localPortFree(port int, proto string) bool {
+	address := fmt.Sprintf(":%d", port)
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
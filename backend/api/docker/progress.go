@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// Progress receives step and percentage updates from long operations.
+type Progress interface {
+	Step(name string)
+	Update(percent int, message string)
+}
+
+type noProgress struct{}
+
+func (noProgress) Step(string)        {}
+func (noProgress) Update(int, string) {}
+
+func orNoProgress(p Progress) Progress {
+	if p == nil {
+		return noProgress{}
+	}
+	return p
+}
+
+// ensureImage pulls image unless it is already present locally.
+func (m *Manager) ensureImage(ctx context.Context, image string, progress Progress) error {
+	if _, _, err := m.client.ImageInspectWithRaw(ctx, image); err == nil {
+		log.Printf("Image %s already present", image)
+		return nil
+	}
+	return m.pullImage(ctx, image, progress)
+}
+
+// pullImage pulls image and reports the combined download progress of its
+// layers, parsed from the Docker pull stream.
+func (m *Manager) pullImage(ctx context.Context, image string, progress Progress) error {
+	progress = orNoProgress(progress)
+	log.Printf("Pulling image %s...", image)
+
+	reader, err := m.client.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		log.Printf("Error pulling image %s: %v", image, err)
+		return fmt.Errorf("failed to pull image %s: %v", image, err)
+	}
+	defer reader.Close()
+
+	type layer struct {
+		current, total int64
+		done           bool
+	}
+	layers := map[string]*layer{}
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress for %s: %v", image, err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("failed to pull image %s: %s", image, msg.Error.Message)
+		}
+		if msg.ID == "" {
+			continue
+		}
+
+		l, ok := layers[msg.ID]
+		if !ok {
+			l = &layer{}
+			layers[msg.ID] = l
+		}
+		switch msg.Status {
+		case "Downloading":
+			if msg.Progress != nil && msg.Progress.Total > 0 {
+				l.current, l.total = msg.Progress.Current, msg.Progress.Total
+			}
+		case "Download complete", "Pull complete", "Already exists":
+			l.done = true
+			l.current = l.total
+		}
+
+		var current, total int64
+		pending := false
+		for _, l := range layers {
+			current += l.current
+			total += l.total
+			if !l.done && l.total == 0 {
+				pending = true
+			}
+		}
+		if total > 0 {
+			percent := int(current * 100 / total)
+			// Layers whose size is not known yet would make us overshoot
+			if pending && percent > 99 {
+				percent = 99
+			}
+			progress.Update(percent, fmt.Sprintf("%s %s", msg.Status, msg.ID))
+		}
+	}
+
+	progress.Update(100, "Pull complete")
+	log.Printf("Pulled image %s", image)
+	return nil
+}
@@ -0,0 +1,323 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// QuotaError is returned when an operation would take a user over quota.
+type QuotaError struct {
+	Message string
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// Quota is the effective limit for a user: the per-user override if one is
+// set, otherwise the defaults from settings. Zero or empty means unlimited.
+// MaxCPUs applies to each server rather than to all of them together.
+type Quota struct {
+	MaxServers int     `json:"maxServers"`
+	MaxMemory  string  `json:"maxMemory"`
+	MaxDisk    string  `json:"maxDisk"`
+	MaxRunning int     `json:"maxRunning"`
+	MaxCPUs    float64 `json:"maxCpus"`
+}
+
+// QuotaUsage is what a user's servers currently consume. Memory is the sum
+// of the container memory limits and Disk covers data and backups.
+type QuotaUsage struct {
+	Servers     int   `json:"servers"`
+	MemoryBytes int64 `json:"memoryBytes"`
+	DiskBytes   int64 `json:"diskBytes"`
+	Running     int   `json:"running"`
+}
+
+type QuotaStatus struct {
+	Limits Quota      `json:"limits"`
+	Usage  QuotaUsage `json:"usage"`
+}
+
+// QuotaRequest is what an operation is about to add to a user's usage.
+type QuotaRequest struct {
+	Servers     int
+	MemoryBytes int64
+	DiskBytes   int64
+	Running     int
+}
+
+// diskUsageTTL is how long a user's disk usage is reused for, so that
+// checking the quota doesn't walk every data directory each time.
+const diskUsageTTL = 30 * time.Second
+
+type diskUsage struct {
+	bytes   int64
+	fetched time.Time
+}
+
+// lockOwner serializes the operations that check a user's quota and then
+// consume it, so two of them can't both fit in the last of it. The returned
+// func unlocks and forgets the user's cached disk usage, which the operation
+// may have changed. Servers without an owner aren't serialized.
+func (m *Manager) lockOwner(userID int64) func() {
+	if userID == 0 {
+		return func() {}
+	}
+	m.ownerMu.Lock()
+	if m.ownerLocks == nil {
+		m.ownerLocks = map[int64]*sync.Mutex{}
+	}
+	lock, ok := m.ownerLocks[userID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.ownerLocks[userID] = lock
+	}
+	m.ownerMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		m.ownerMu.Lock()
+		delete(m.diskUsage, userID)
+		m.ownerMu.Unlock()
+		lock.Unlock()
+	}
+}
+
+// WithQuota runs fn if req fits in the user's quota, holding the user's
+// quota lock throughout so that concurrent operations see what fn used.
+// Callers that checked the quota up front to fail fast must still use it.
+func (m *Manager) WithQuota(ctx context.Context, userID int64, req QuotaRequest, fn func() error) error {
+	defer m.lockOwner(userID)()
+	if userID != 0 {
+		if err := m.CheckQuota(ctx, userID, req); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// UserQuota returns the limits that apply to a user.
+func (m *Manager) UserQuota(userID int64) (Quota, error) {
+	quota := Quota{
+		MaxServers: m.db.SettingInt("max_servers_per_user"),
+		MaxMemory:  m.db.SettingString("max_memory_per_user"),
+		MaxDisk:    m.db.SettingString("max_disk_per_user"),
+		MaxRunning: m.db.SettingInt("max_running_per_user"),
+		MaxCPUs:    m.db.SettingFloat("max_cpus"),
+	}
+
+	override, err := m.db.GetUserQuota(userID)
+	if err != nil || override == nil {
+		return quota, err
+	}
+	if override.MaxServers != nil {
+		quota.MaxServers = *override.MaxServers
+	}
+	if override.MaxMemory != nil {
+		quota.MaxMemory = *override.MaxMemory
+	}
+	if override.MaxDisk != nil {
+		quota.MaxDisk = *override.MaxDisk
+	}
+	if override.MaxRunning != nil {
+		quota.MaxRunning = *override.MaxRunning
+	}
+	if override.MaxCPUs != nil {
+		quota.MaxCPUs = *override.MaxCPUs
+	}
+	return quota, nil
+}
+
+// QuotaUsage adds up what the servers owned by a user consume. Disk usage is
+// reused for diskUsageTTL unless a quota-checked operation ran since.
+func (m *Manager) QuotaUsage(ctx context.Context, userID int64) (QuotaUsage, error) {
+	var usage QuotaUsage
+
+	servers, err := m.db.ListServersByOwner(userID)
+	if err != nil {
+		return usage, err
+	}
+	usage.Servers = len(servers)
+	if len(servers) == 0 {
+		return usage, nil
+	}
+
+	m.ownerMu.Lock()
+	cached, ok := m.diskUsage[userID]
+	m.ownerMu.Unlock()
+	fresh := ok && time.Since(cached.fetched) < diskUsageTTL
+
+	var volumeSizes map[string]int64
+	if !fresh {
+		volumeSizes = m.volumeSizes(ctx)
+	}
+	for _, server := range servers {
+		inspect, err := m.client.ContainerInspect(ctx, server.ContainerName)
+		if err != nil {
+			log.Printf("Skipping %s in quota usage: %v", server.ContainerName, err)
+			continue
+		}
+		if inspect.State.Running {
+			usage.Running++
+		}
+		usage.MemoryBytes += m.containerMemory(inspect)
+
+		if fresh {
+			continue
+		}
+		if storage, err := storageOf(inspect.Mounts); err == nil {
+			usage.DiskBytes += storageSize(storage, volumeSizes)
+		}
+		if dir, err := m.backupDir(server.ContainerName); err == nil {
+			usage.DiskBytes += dirSize(dir)
+		}
+	}
+
+	if fresh {
+		usage.DiskBytes = cached.bytes
+	} else {
+		m.ownerMu.Lock()
+		if m.diskUsage == nil {
+			m.diskUsage = map[int64]diskUsage{}
+		}
+		m.diskUsage[userID] = diskUsage{bytes: usage.DiskBytes, fetched: time.Now()}
+		m.ownerMu.Unlock()
+	}
+	return usage, nil
+}
+
+// QuotaStatus reports a user's limits alongside their current usage.
+func (m *Manager) QuotaStatus(ctx context.Context, userID int64) (*QuotaStatus, error) {
+	limits, err := m.UserQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := m.QuotaUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaStatus{Limits: limits, Usage: usage}, nil
+}
+
+// CheckQuota returns a *QuotaError if adding req to the user's usage would
+// exceed their quota. Disk is only checked for operations that write data.
+// On its own it only rejects early; see WithQuota.
+func (m *Manager) CheckQuota(ctx context.Context, userID int64, req QuotaRequest) error {
+	status, err := m.QuotaStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+	limits, usage := status.Limits, status.Usage
+
+	if req.Servers > 0 && limits.MaxServers > 0 && usage.Servers+req.Servers > limits.MaxServers {
+		return &QuotaError{Message: fmt.Sprintf("server quota exceeded: %d of %d servers in use", usage.Servers, limits.MaxServers)}
+	}
+	if req.Running > 0 && limits.MaxRunning > 0 && usage.Running+req.Running > limits.MaxRunning {
+		return &QuotaError{Message: fmt.Sprintf("running server quota exceeded: %d of %d servers running", usage.Running, limits.MaxRunning)}
+	}
+	if req.MemoryBytes > 0 && limits.MaxMemory != "" {
+		maxMemory, err := ParseMemory(limits.MaxMemory)
+		if err != nil {
+			log.Printf("Ignoring invalid memory quota %q: %v", limits.MaxMemory, err)
+		} else if usage.MemoryBytes+req.MemoryBytes > maxMemory {
+			return &QuotaError{Message: fmt.Sprintf("memory quota exceeded: %s requested with %s of %s allocated",
+				formatMemory(req.MemoryBytes), formatMemory(usage.MemoryBytes), limits.MaxMemory)}
+		}
+	}
+	if (req.Servers > 0 || req.DiskBytes > 0) && limits.MaxDisk != "" {
+		maxDisk, err := ParseMemory(limits.MaxDisk)
+		if err != nil {
+			log.Printf("Ignoring invalid disk quota %q: %v", limits.MaxDisk, err)
+		} else if usage.DiskBytes+req.DiskBytes > maxDisk {
+			return &QuotaError{Message: fmt.Sprintf("disk quota exceeded: %s of %s in use",
+				formatMemory(usage.DiskBytes), limits.MaxDisk)}
+		}
+	}
+	return nil
+}
+
+// ServerOwner returns the owner of a server, or 0 if it has none.
+func (m *Manager) ServerOwner(ctx context.Context, serverID string) (int64, error) {
+	server, err := m.ResolveServer(ctx, serverID)
+	if err != nil {
+		return 0, err
+	}
+	return server.OwnerID, nil
+}
+
+// ServerUsage reports the memory and disk a single server accounts for, so
+// callers can check the quota before cloning or backing it up.
+func (m *Manager) ServerUsage(ctx context.Context, serverID string) (QuotaRequest, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return QuotaRequest{}, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	req := QuotaRequest{MemoryBytes: m.containerMemory(inspect)}
+	if storage, err := storageOf(inspect.Mounts); err == nil {
+		req.DiskBytes = storageSize(storage, m.volumeSizes(ctx))
+	}
+	return req, nil
+}
+
+// containerMemory is the memory a container is allowed: its limit, or the
+// heap for containers created before limits were set.
+func (m *Manager) containerMemory(inspect types.ContainerJSON) int64 {
+	if inspect.HostConfig != nil && inspect.HostConfig.Resources.Memory > 0 {
+		return inspect.HostConfig.Resources.Memory
+	}
+	heap := envValue(inspect.Config.Env, "MEMORY")
+	if heap == "" {
+		heap = m.defaultMemory()
+	}
+	bytes, _ := ParseMemory(heap)
+	return bytes
+}
+
+// volumeSizes maps volume names to their size as reported by the daemon.
+func (m *Manager) volumeSizes(ctx context.Context) map[string]int64 {
+	sizes := map[string]int64{}
+	du, err := m.client.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		log.Printf("Error fetching volume sizes: %v", err)
+		return sizes
+	}
+	for _, v := range du.Volumes {
+		if v.UsageData != nil && v.UsageData.Size > 0 && strings.HasPrefix(v.Name, "mboxmini-") {
+			sizes[v.Name] = v.UsageData.Size
+		}
+	}
+	return sizes
+}
+
+func storageSize(storage Storage, volumeSizes map[string]int64) int64 {
+	if storage.Type == StorageVolume {
+		return volumeSizes[storage.Source]
+	}
+	return dirSize(storage.Source)
+}
+
+// dirSize is the total size of the regular files under dir. Missing or
+// unreadable directories count as empty.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
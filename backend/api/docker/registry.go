@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// serverIDLabel carries the stable server UUID on every container we create.
+// Containers created before UUIDs existed are matched by container name.
+const serverIDLabel = "mboxmini.server-id"
+
+// isServerContainer reports whether a container is a Minecraft server
+// managed by MboxMini rather than one of the application containers.
+func isServerContainer(cont types.Container) bool {
+	if len(cont.Names) == 0 {
+		return false
+	}
+	if strings.HasPrefix(cont.Image, "mboxmini-") {
+		return false
+	}
+	if cont.Labels[proxyLabel] != "" {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(cont.Names[0], "/"), "mboxmini-")
+}
+
+// serverRecord returns the registry entry for a container, registering the
+// container under a new UUID if it has never been seen before.
+func (m *Manager) serverRecord(containerID, containerName string, labels map[string]string) (*database.Server, error) {
+	if id := labels[serverIDLabel]; id != "" {
+		server, err := m.db.GetServer(id)
+		if err != nil || server != nil {
+			return server, err
+		}
+	}
+
+	server, err := m.db.GetServerByContainerName(containerName)
+	if err != nil || server != nil {
+		return server, err
+	}
+
+	id := labels[serverIDLabel]
+	if id == "" {
+		if id, err = database.NewServerID(); err != nil {
+			return nil, err
+		}
+	}
+
+	server = &database.Server{
+		ID:            id,
+		Name:          m.uniqueDisplayName(strings.TrimPrefix(containerName, "mboxmini-")),
+		ContainerName: containerName,
+	}
+	if err := m.db.CreateServer(server); err != nil {
+		return nil, fmt.Errorf("failed to register server %s: %v", containerName, err)
+	}
+	if err := m.db.AdoptServerHistory(server.ID, containerName, containerID); err != nil {
+		log.Printf("Error migrating history for server %s: %v", containerName, err)
+	}
+
+	log.Printf("Registered server %s with ID %s", containerName, server.ID)
+	return server, nil
+}
+
+// uniqueDisplayName appends a numeric suffix if name is already taken by
+// another server, which can happen after a rename frees a container name.
+func (m *Manager) uniqueDisplayName(name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		existing, err := m.db.GetServerByName(candidate)
+		if err != nil || existing == nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// containerRef maps a server UUID to its container name. Container IDs and
+// names are passed through unchanged so both kinds of reference keep working.
+func (m *Manager) containerRef(id string) string {
+	server, err := m.db.GetServer(id)
+	if err != nil {
+		log.Printf("Error looking up server %s: %v", id, err)
+		return id
+	}
+	if server == nil {
+		return id
+	}
+	return server.ContainerName
+}
+
+// migrateServers registers every existing server container in the database
+// so that servers created before stable IDs keep their stats history.
+func (m *Manager) migrateServers() error {
+	containers, err := m.client.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	for _, cont := range containers {
+		if !isServerContainer(cont) {
+			continue
+		}
+		name := strings.TrimPrefix(cont.Names[0], "/")
+		if _, err := m.serverRecord(cont.ID, name, cont.Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRename checks that a server can be renamed to name so callers can
+// reject it before changing anything. RenameServer runs the same checks.
+func (m *Manager) ValidateRename(ctx context.Context, serverID, name string) error {
+	_, err := m.renameTarget(ctx, serverID, name)
+	return err
+}
+
+// renameTarget returns the record of the server to rename once name has
+// been checked.
+func (m *Manager) renameTarget(ctx context.Context, serverID, name string) (*database.Server, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &catalog.ValidationError{Message: "name cannot be empty"}
+	}
+
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	server, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := m.db.GetServerByName(name); err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID != server.ID {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("a server named %s already exists", name)}
+	}
+	return server, nil
+}
+
+// RenameServer changes a server's display name. The container, data
+// directory and stats history stay linked through the server UUID.
+func (m *Manager) RenameServer(ctx context.Context, serverID, name string) error {
+	name = strings.TrimSpace(name)
+	server, err := m.renameTarget(ctx, serverID, name)
+	if err != nil {
+		return err
+	}
+
+	if err := m.db.RenameServer(server.ID, name); err != nil {
+		return fmt.Errorf("failed to rename server: %v", err)
+	}
+	log.Printf("Renamed server %s from %s to %s", server.ID, server.Name, name)
+
+	return nil
+}
+
+// serverNamePattern limits server names to what is safe in container,
+// volume and directory names.
+var serverNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+// ValidateServerName checks the name of a new server.
+func ValidateServerName(name string) error {
+	return validateName("server", name)
+}
+
+// ValidateNetworkName checks the name of a new network, which its proxy
+// container, Docker network and data directory are named after.
+func ValidateNetworkName(name string) error {
+	return validateName("network", name)
+}
+
+func validateName(kind, name string) error {
+	if !serverNamePattern.MatchString(name) {
+		return &catalog.ValidationError{Message: fmt.Sprintf("invalid %s name %q: use up to 63 letters, digits, dots, dashes and underscores, starting with a letter or digit", kind, name)}
+	}
+	return nil
+}
+
+// ContainerName returns the container (and data directory) name used for a
+// newly created server.
+func ContainerName(name string) string {
+	return fmt.Sprintf("mboxmini-%s", name)
+}
+
+// checkContainerName fails if a new container name, or the data directory
+// or volume named after it, is already taken. Renamed servers and network
+// proxies keep their container names, so the display names alone don't
+// rule out collisions.
+func (m *Manager) checkContainerName(ctx context.Context, containerName string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+	}
+
+	if existing, err := m.db.GetServerByContainerName(containerName); err != nil {
+		return err
+	} else if existing != nil {
+		return invalid("container name %s is already used by server %s", containerName, existing.Name)
+	}
+	networks, err := m.db.ListNetworks()
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if network.ContainerName == containerName {
+			return invalid("container name %s is already used by network %s", containerName, network.Name)
+		}
+	}
+
+	if _, err := m.client.ContainerInspect(ctx, containerName); err == nil {
+		return invalid("a container named %s already exists", containerName)
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	if m.dataPath != "" {
+		dir := filepath.Join(m.dataPath, containerName)
+		if _, err := os.Lstat(dir); err == nil {
+			return invalid("data directory %s already exists", dir)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if _, err := m.client.VolumeInspect(ctx, volumeName(containerName)); err == nil {
+		return invalid("volume %s already exists", volumeName(containerName))
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect volume: %v", err)
+	}
+	return nil
+}
+
+// ResolveServer returns the registry entry for a server referenced by UUID,
+// container ID or container name.
+func (m *Manager) ResolveServer(ctx context.Context, serverID string) (*database.Server, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	return m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+}
+
+// ServerRunning reports whether a server's container is running.
+func (m *Manager) ServerRunning(ctx context.Context, serverID string) (bool, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	return inspect.State != nil && inspect.State.Running, nil
+}
@@ -0,0 +1,313 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// defaultPidsLimit caps processes and threads in a server container.
+const defaultPidsLimit = 4096
+
+const (
+	mib = int64(1) << 20
+	gib = int64(1) << 30
+)
+
+// Resources are the container limits of a server. MemoryLimit bounds the
+// whole container, unlike MEMORY which only sizes the JVM heap, so it must
+// leave headroom above the heap. Zero values are filled in with defaults.
+type Resources struct {
+	MemoryLimit string  `json:"memoryLimit,omitempty"`
+	CPUs        float64 `json:"cpus,omitempty"`
+	CPUShares   int64   `json:"cpuShares,omitempty"`
+	PidsLimit   int64   `json:"pidsLimit,omitempty"`
+	BlkioWeight uint16  `json:"blkioWeight,omitempty"`
+}
+
+// ParseMemory parses sizes in the format of the image's MEMORY variable,
+// such as 2G, 1536M or 512m. A bare number is bytes.
+func ParseMemory(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty memory size")
+	}
+
+	unit := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = mib
+	case "G":
+		unit = gib
+	}
+	number := value
+	if unit != 1 {
+		number = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory size %q", value)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func formatMemory(bytes int64) string {
+	if bytes%gib == 0 {
+		return fmt.Sprintf("%dG", bytes/gib)
+	}
+	return fmt.Sprintf("%dM", int64(math.Ceil(float64(bytes)/float64(mib))))
+}
+
+// memoryHeadroom is what the JVM needs on top of the heap for metaspace,
+// thread stacks and native buffers.
+func memoryHeadroom(heap int64) int64 {
+	if headroom := heap / 4; headroom > 512*mib {
+		return headroom
+	}
+	return 512 * mib
+}
+
+// defaultMemory is the JVM heap for servers that don't specify one.
+func (m *Manager) defaultMemory() string {
+	return m.db.SettingString("default_memory")
+}
+
+// resourceMaxima are the largest limits one server may have; zero means
+// unlimited.
+type resourceMaxima struct {
+	memory int64
+	cpus   float64
+	pids   int64
+}
+
+// resourceMaxima reads the maxima from settings, narrowed by the quota of
+// the server's owner, if it has one: no server can have more memory than its
+// owner may use in total, and the owner's CPU quota replaces max_cpus.
+func (m *Manager) resourceMaxima(ownerID int64) resourceMaxima {
+	maxima := resourceMaxima{
+		cpus: m.db.SettingFloat("max_cpus"),
+		pids: int64(m.db.SettingInt("max_pids")),
+	}
+	if maxMemory := m.db.SettingString("max_memory_limit"); maxMemory != "" {
+		if maxBytes, err := ParseMemory(maxMemory); err != nil {
+			log.Printf("Ignoring invalid max_memory_limit setting %q: %v", maxMemory, err)
+		} else {
+			maxima.memory = maxBytes
+		}
+	}
+	if ownerID == 0 {
+		return maxima
+	}
+
+	quota, err := m.UserQuota(ownerID)
+	if err != nil {
+		log.Printf("Error reading quota of user %d, using the global maxima: %v", ownerID, err)
+		return maxima
+	}
+	if quota.MaxMemory != "" {
+		if maxBytes, err := ParseMemory(quota.MaxMemory); err != nil {
+			log.Printf("Ignoring invalid memory quota %q of user %d: %v", quota.MaxMemory, ownerID, err)
+		} else if maxima.memory == 0 || maxBytes < maxima.memory {
+			maxima.memory = maxBytes
+		}
+	}
+	maxima.cpus = quota.MaxCPUs
+	return maxima
+}
+
+// resolveResources fills in defaults for r and checks it against the heap
+// size and the maxima for servers of ownerID.
+func (m *Manager) resolveResources(r Resources, heap string, ownerID int64) (Resources, error) {
+	invalid := func(format string, args ...interface{}) (Resources, error) {
+		return Resources{}, &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+	}
+
+	if heap == "" {
+		heap = m.defaultMemory()
+	}
+	heapBytes, err := ParseMemory(heap)
+	if err != nil {
+		return invalid("invalid memory: %v", err)
+	}
+
+	limit := heapBytes + memoryHeadroom(heapBytes)
+	if r.MemoryLimit != "" {
+		if limit, err = ParseMemory(r.MemoryLimit); err != nil {
+			return invalid("invalid memoryLimit: %v", err)
+		}
+		if limit <= heapBytes {
+			return invalid("memoryLimit %s must be larger than the %s heap", r.MemoryLimit, heap)
+		}
+	}
+
+	maxima := m.resourceMaxima(ownerID)
+	if maxima.memory > 0 && limit > maxima.memory {
+		return invalid("memory limit %s exceeds the maximum of %s", formatMemory(limit), formatMemory(maxima.memory))
+	}
+	r.MemoryLimit = formatMemory(limit)
+
+	// Unset CPUs stay unlimited
+	if r.CPUs < 0 {
+		return invalid("cpus must not be negative")
+	}
+	if maxima.cpus > 0 && r.CPUs > maxima.cpus {
+		return invalid("cpus %g exceeds the maximum of %g", r.CPUs, maxima.cpus)
+	}
+
+	if r.CPUShares < 0 {
+		return invalid("cpuShares must not be negative")
+	}
+
+	maxPids := maxima.pids
+	if r.PidsLimit < 0 {
+		return invalid("pidsLimit must not be negative")
+	}
+	if r.PidsLimit == 0 {
+		r.PidsLimit = defaultPidsLimit
+		if maxPids > 0 && maxPids < r.PidsLimit {
+			r.PidsLimit = maxPids
+		}
+	} else if maxPids > 0 && r.PidsLimit > maxPids {
+		return invalid("pidsLimit %d exceeds the maximum of %d", r.PidsLimit, maxPids)
+	}
+
+	if r.BlkioWeight != 0 && (r.BlkioWeight < 10 || r.BlkioWeight > 1000) {
+		return invalid("blkioWeight must be between 10 and 1000")
+	}
+
+	return r, nil
+}
+
+// containerResources converts resolved limits to the Docker host config.
+// Swap is disabled so the memory limit is a hard cap.
+func (r Resources) containerResources() container.Resources {
+	memory, _ := ParseMemory(r.MemoryLimit)
+	pids := r.PidsLimit
+	return container.Resources{
+		Memory:      memory,
+		MemorySwap:  memory,
+		NanoCPUs:    int64(r.CPUs * 1e9),
+		CPUShares:   r.CPUShares,
+		PidsLimit:   &pids,
+		BlkioWeight: r.BlkioWeight,
+	}
+}
+
+// withDefaults fills the limits r leaves unset from defaults.
+func (r Resources) withDefaults(defaults Resources) Resources {
+	if r.MemoryLimit == "" {
+		r.MemoryLimit = defaults.MemoryLimit
+	}
+	if r.CPUs == 0 {
+		r.CPUs = defaults.CPUs
+	}
+	if r.CPUShares == 0 {
+		r.CPUShares = defaults.CPUShares
+	}
+	if r.PidsLimit == 0 {
+		r.PidsLimit = defaults.PidsLimit
+	}
+	if r.BlkioWeight == 0 {
+		r.BlkioWeight = defaults.BlkioWeight
+	}
+	return r
+}
+
+// resourcesOf reports the limits currently set on a container.
+func resourcesOf(res container.Resources) *Resources {
+	r := &Resources{
+		CPUs:        float64(res.NanoCPUs) / 1e9,
+		CPUShares:   res.CPUShares,
+		BlkioWeight: res.BlkioWeight,
+	}
+	if res.Memory > 0 {
+		r.MemoryLimit = formatMemory(res.Memory)
+	}
+	if res.PidsLimit != nil {
+		r.PidsLimit = *res.PidsLimit
+	}
+	return r
+}
+
+// resourceUpdate is a pending change to a server's limits.
+type resourceUpdate struct {
+	inspect  types.ContainerJSON
+	ownerID  int64
+	resolved Resources
+}
+
+func (m *Manager) resourceUpdate(ctx context.Context, serverID string, r Resources) (*resourceUpdate, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	if inspect.HostConfig != nil {
+		r = r.withDefaults(*resourcesOf(inspect.HostConfig.Resources))
+	}
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := m.resolveResources(r, envValue(inspect.Config.Env, "MEMORY"), record.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceUpdate{inspect: inspect, ownerID: record.OwnerID, resolved: resolved}, nil
+}
+
+// checkQuota checks that the update fits in the owner's memory quota. Only
+// growth has to fit.
+func (u *resourceUpdate) checkQuota(ctx context.Context, m *Manager) error {
+	if u.ownerID == 0 {
+		return nil
+	}
+	memory, _ := ParseMemory(u.resolved.MemoryLimit)
+	if delta := memory - m.containerMemory(u.inspect); delta > 0 {
+		return m.CheckQuota(ctx, u.ownerID, QuotaRequest{MemoryBytes: delta})
+	}
+	return nil
+}
+
+// ValidateResources checks that r can be applied to a server so callers can
+// reject it before changing anything. UpdateResources runs the same checks.
+func (m *Manager) ValidateResources(ctx context.Context, serverID string, r Resources) error {
+	update, err := m.resourceUpdate(ctx, serverID, r)
+	if err != nil {
+		return err
+	}
+	return update.checkQuota(ctx, m)
+}
+
+// UpdateResources applies new limits to a server's container, keeping the
+// current value of any limit r leaves unset. Docker applies them to a
+// running container without a restart.
+func (m *Manager) UpdateResources(ctx context.Context, serverID string, r Resources) (*Resources, error) {
+	update, err := m.resourceUpdate(ctx, serverID, r)
+	if err != nil {
+		return nil, err
+	}
+	defer m.lockOwner(update.ownerID)()
+	if err := update.checkQuota(ctx, m); err != nil {
+		return nil, err
+	}
+
+	inspect, resolved := update.inspect, update.resolved
+	log.Printf("Updating resources of %s: %+v", inspect.Name, resolved)
+	if _, err := m.client.ContainerUpdate(ctx, inspect.ID, container.UpdateConfig{
+		Resources: resolved.containerResources(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update container resources: %v", err)
+	}
+	return &resolved, nil
+}
@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Restart policies, applied by Docker when a server's container exits or
+// the Docker daemon starts
+const (
+	RestartNever         = "never"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// MaxRestartRetries caps the retries of an on-failure policy.
+const MaxRestartRetries = 100
+
+// defaultRestartRetries is used for an on-failure policy without retries.
+const defaultRestartRetries = 5
+
+// RestartPolicy is when Docker starts a server again by itself. on-failure
+// restarts a server that crashed, up to MaxRetries times; always and
+// unless-stopped also restart it after a host reboot, always even if it was
+// stopped before.
+type RestartPolicy struct {
+	Name       string `json:"name"`
+	MaxRetries int    `json:"maxRetries,omitempty"`
+}
+
+// Validate checks the policy and fills in the default retries.
+func (p *RestartPolicy) Validate() error {
+	switch p.Name {
+	case RestartOnFailure:
+		if p.MaxRetries < 0 || p.MaxRetries > MaxRestartRetries {
+			return &catalog.ValidationError{Message: fmt.Sprintf("maxRetries must be between 0 and %d", MaxRestartRetries)}
+		}
+		if p.MaxRetries == 0 {
+			p.MaxRetries = defaultRestartRetries
+		}
+	case RestartNever, RestartAlways, RestartUnlessStopped:
+		if p.MaxRetries != 0 {
+			return &catalog.ValidationError{Message: "only on-failure restart policies take maxRetries"}
+		}
+	default:
+		return &catalog.ValidationError{Message: fmt.Sprintf("unknown restart policy %q: use never, on-failure, always or unless-stopped", p.Name)}
+	}
+	return nil
+}
+
+func (p RestartPolicy) containerPolicy() container.RestartPolicy {
+	switch p.Name {
+	case RestartOnFailure:
+		return container.RestartPolicy{Name: p.Name, MaximumRetryCount: p.MaxRetries}
+	case RestartAlways, RestartUnlessStopped:
+		return container.RestartPolicy{Name: p.Name}
+	}
+	return container.RestartPolicy{Name: "no"}
+}
+
+// restartPolicyOf reads a container's restart policy. Containers created
+// before restart policies have none, which is never.
+func restartPolicyOf(hostConfig *container.HostConfig) RestartPolicy {
+	if hostConfig == nil {
+		return RestartPolicy{Name: RestartNever}
+	}
+	policy := hostConfig.RestartPolicy
+	switch {
+	case policy.IsOnFailure():
+		return RestartPolicy{Name: RestartOnFailure, MaxRetries: policy.MaximumRetryCount}
+	case policy.IsAlways():
+		return RestartPolicy{Name: RestartAlways}
+	case policy.IsUnlessStopped():
+		return RestartPolicy{Name: RestartUnlessStopped}
+	}
+	return RestartPolicy{Name: RestartNever}
+}
+
+// restartsOnExit reports whether Docker starts the container again when its
+// server exits cleanly, as it does after a stop command.
+func restartsOnExit(hostConfig *container.HostConfig) bool {
+	return hostConfig != nil && (hostConfig.RestartPolicy.IsAlways() || hostConfig.RestartPolicy.IsUnlessStopped())
+}
+
+// defaultRestartPolicy is the policy of servers created without one.
+func (m *Manager) defaultRestartPolicy() RestartPolicy {
+	policy := RestartPolicy{Name: m.db.SettingString("default_restart_policy")}
+	if err := policy.Validate(); err != nil {
+		log.Printf("Invalid default restart policy %q, using %s", policy.Name, RestartNever)
+		return RestartPolicy{Name: RestartNever}
+	}
+	return policy
+}
+
+// SetRestartPolicy changes a server's restart policy. It applies straight
+// away, without restarting the server.
+func (m *Manager) SetRestartPolicy(ctx context.Context, serverID string, policy RestartPolicy) (*RestartPolicy, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	log.Printf("Setting restart policy of %s to %+v", inspect.Name, policy)
+	if _, err := m.client.ContainerUpdate(ctx, inspect.ID, container.UpdateConfig{
+		RestartPolicy: policy.containerPolicy(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update restart policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// RestartServer stops a server gracefully, warning its players first if
+// opts has a warning, and starts it again. A stopped server is just started.
+func (m *Manager) RestartServer(ctx context.Context, serverID string, opts StopOptions, progress Progress) (*StopResult, error) {
+	progress = orNoProgress(progress)
+	opts.Restart = true
+	result, err := m.GracefulStop(ctx, serverID, opts, progress)
+	if err != nil {
+		return result, err
+	}
+
+	var startErr error
+	result.phase(progress, "start server", func() (string, string) {
+		if startErr = m.StartServer(serverID); startErr != nil {
+			return PhaseFailed, startErr.Error()
+		}
+		return PhaseDone, ""
+	})
+	if startErr != nil {
+		return result, fmt.Errorf("failed to start server: %v", startErr)
+	}
+	return result, nil
+}
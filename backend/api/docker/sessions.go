@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// TrackPlayers records player sessions until ctx is done, checking which
+// players are online on each running Java server every interval.
+func (m *Manager) TrackPlayers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.syncPlayerSessions(ctx, interval); err != nil {
+			log.Printf("Error tracking player sessions: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncPlayerSessions opens sessions for players who joined since the last
+// check and closes those of players who left or whose server stopped.
+// Bedrock servers and servers whose console doesn't answer yet are left as
+// they are.
+func (m *Manager) syncPlayerSessions(ctx context.Context, interval time.Duration) error {
+	open, err := m.db.OpenPlayerSessions()
+	if err != nil {
+		return fmt.Errorf("failed to read open sessions: %v", err)
+	}
+	byServer := map[string][]database.PlayerSession{}
+	for _, session := range open {
+		byServer[session.ServerID] = append(byServer[session.ServerID], session)
+	}
+
+	containers, err := m.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	now := time.Now()
+	known := map[string]bool{}
+	for _, cont := range containers {
+		if !isServerContainer(cont) {
+			continue
+		}
+		record, err := m.serverRecord(cont.ID, strings.TrimPrefix(cont.Names[0], "/"), cont.Labels)
+		if err != nil {
+			log.Printf("Error looking up server %s: %v", cont.Names[0], err)
+			continue
+		}
+		known[record.ID] = true
+
+		online := []string{}
+		if cont.State == "running" {
+			if editionOf(cont.Image, cont.Labels) == EditionBedrock {
+				continue
+			}
+			if online, err = m.GetServerPlayers(ctx, cont.ID); err != nil {
+				continue
+			}
+		}
+		m.syncServerSessions(record.ID, byServer[record.ID], online, now, interval)
+	}
+
+	// Sessions on deleted servers end too
+	for serverID, sessions := range byServer {
+		if !known[serverID] {
+			m.syncServerSessions(serverID, sessions, nil, now, interval)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) syncServerSessions(serverID string, open []database.PlayerSession, online []string, now time.Time, interval time.Duration) {
+	for _, session := range open {
+		if containsFold(online, session.PlayerName) {
+			continue
+		}
+		// A session left open while players weren't being tracked ends
+		// when its player was last seen
+		end := now
+		lastSeen := session.JoinTime
+		if session.LastSeen != nil {
+			lastSeen = *session.LastSeen
+		}
+		if now.Sub(lastSeen) > 2*interval {
+			end = lastSeen
+		}
+		if err := m.db.EndPlayerSession(session, end); err != nil {
+			log.Printf("Error ending session of %s on server %s: %v", session.PlayerName, serverID, err)
+			continue
+		}
+		log.Printf("Player %s left server %s", session.PlayerName, serverID)
+	}
+
+	for _, name := range online {
+		joined := true
+		for _, session := range open {
+			if strings.EqualFold(session.PlayerName, name) {
+				joined = false
+				break
+			}
+		}
+		if !joined {
+			continue
+		}
+		if err := m.db.RecordPlayerJoin(serverID, name); err != nil {
+			log.Printf("Error recording %s joining server %s: %v", name, serverID, err)
+			continue
+		}
+		log.Printf("Player %s joined server %s", name, serverID)
+	}
+
+	if len(online) > 0 {
+		if err := m.db.TouchPlayerSessions(serverID, now); err != nil {
+			log.Printf("Error updating sessions on server %s: %v", serverID, err)
+		}
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// MaxStopWarning caps the countdown before a stop, in seconds.
+const MaxStopWarning = 3600
+
+// killTimeout is how long Docker waits after SIGTERM before killing a server
+// that ignored its stop command.
+const killTimeout = 10
+
+// stopWarningMarks are the seconds before a stop at which players are
+// reminded, after the first warning.
+var stopWarningMarks = []int{1800, 900, 600, 300, 120, 60, 30, 10, 5, 4, 3, 2, 1}
+
+// Stop phase statuses
+const (
+	PhaseDone    = "done"
+	PhaseSkipped = "skipped"
+	PhaseFailed  = "failed"
+)
+
+// StopOptions control a graceful stop. Warning is a countdown in seconds
+// announced to online players first. Timeout is how long the server gets to
+// save and exit after the stop command before it is killed, 0 for the
+// stop_timeout setting. Restart only changes the wording of the warning.
+type StopOptions struct {
+	Warning int  `json:"warning"`
+	Timeout int  `json:"timeout"`
+	Restart bool `json:"-"`
+}
+
+func (opts StopOptions) Validate() error {
+	if opts.Warning < 0 || opts.Warning > MaxStopWarning {
+		return &catalog.ValidationError{Message: fmt.Sprintf("warning must be between 0 and %d seconds", MaxStopWarning)}
+	}
+	if opts.Timeout != 0 && (opts.Timeout < 10 || opts.Timeout > 3600) {
+		return &catalog.ValidationError{Message: "timeout must be between 10 and 3600 seconds"}
+	}
+	return nil
+}
+
+// StopPhase is one step of a graceful stop.
+type StopPhase struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// StopResult reports how a stop went. Forced is set when Docker had to stop
+// the server because its own stop command didn't.
+type StopResult struct {
+	Phases []StopPhase `json:"phases"`
+	Forced bool        `json:"forced"`
+}
+
+func (r *StopResult) phase(progress Progress, name string, fn func() (string, string)) {
+	progress.Step(name)
+	started := time.Now()
+	status, message := fn()
+	r.Phases = append(r.Phases, StopPhase{
+		Name:       name,
+		Status:     status,
+		Message:    message,
+		DurationMs: time.Since(started).Milliseconds(),
+	})
+	log.Printf("Stop phase %q: %s %s", name, status, message)
+}
+
+// StopServer stops a server gracefully with the default timeout.
+func (m *Manager) StopServer(serverID string) error {
+	_, err := m.GracefulStop(context.Background(), serverID, StopOptions{}, nil)
+	return err
+}
+
+// GracefulStop warns online players, flushes the world to disk and stops the
+// server through its console, then waits for the container to exit. If the
+// console can't be reached or the server doesn't exit in time, Docker stops
+// it, killing it if it still doesn't exit. Servers that Docker would restart
+// are always stopped through Docker. Cancelling ctx during the warning
+// leaves the server running.
+func (m *Manager) GracefulStop(ctx context.Context, serverID string, opts StopOptions, progress Progress) (*StopResult, error) {
+	progress = orNoProgress(progress)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = m.db.SettingInt("stop_timeout")
+	}
+
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	result := &StopResult{Phases: []StopPhase{}}
+	if inspect.State == nil || !inspect.State.Running {
+		return result, nil
+	}
+	id := inspect.ID
+	java := editionOf(inspect.Config.Image, inspect.Config.Labels) != EditionBedrock
+	log.Printf("Stopping server %s (warning %ds, timeout %ds)", inspect.Name, opts.Warning, timeout)
+
+	if opts.Warning > 0 {
+		result.phase(progress, "warn players", func() (string, string) {
+			// Bedrock can't list its players, so it always gets the warning
+			if java {
+				if players, err := m.GetServerPlayers(ctx, id); err == nil && len(players) == 0 {
+					return PhaseSkipped, "no players online"
+				}
+			}
+			if err := m.countdown(ctx, id, opts); err != nil {
+				return PhaseFailed, err.Error()
+			}
+			return PhaseDone, fmt.Sprintf("warned %s ahead", formatSeconds(opts.Warning))
+		})
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("stop cancelled: %v", err)
+		}
+	}
+
+	if java {
+		result.phase(progress, "save world", func() (string, string) {
+			output, err := m.ExecuteCommand(ctx, id, "save-all flush")
+			if err != nil {
+				return PhaseFailed, err.Error()
+			}
+			return PhaseDone, output
+		})
+	}
+
+	if restartsOnExit(inspect.HostConfig) {
+		return m.dockerStop(progress, result, id, timeout)
+	}
+
+	stopSent := false
+	result.phase(progress, "stop server", func() (string, string) {
+		if _, err := m.ExecuteCommand(ctx, id, "stop"); err != nil {
+			return PhaseFailed, err.Error()
+		}
+		stopSent = true
+		return PhaseDone, ""
+	})
+
+	exited := false
+	if stopSent {
+		result.phase(progress, "wait for exit", func() (string, string) {
+			// Once the server is stopping it must be allowed to finish
+			waitCtx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+			defer cancel()
+			statusCh, errCh := m.client.ContainerWait(waitCtx, id, container.WaitConditionNotRunning)
+			select {
+			case status := <-statusCh:
+				exited = true
+				return PhaseDone, fmt.Sprintf("exited with code %d", status.StatusCode)
+			case err := <-errCh:
+				if waitCtx.Err() != nil {
+					return PhaseFailed, fmt.Sprintf("server did not exit within %ds", timeout)
+				}
+				return PhaseFailed, err.Error()
+			}
+		})
+	}
+
+	if !exited {
+		// Docker sends SIGTERM, which the image also turns into a stop
+		// command, and kills the server when the timeout runs out. A server
+		// that ignored the stop command has had its time already.
+		seconds := timeout
+		if stopSent {
+			seconds = killTimeout
+		}
+		result.Forced = true
+		var stopErr error
+		result.phase(progress, "force stop", func() (string, string) {
+			if stopErr = m.client.ContainerStop(context.Background(), id, container.StopOptions{Timeout: &seconds}); stopErr != nil {
+				return PhaseFailed, stopErr.Error()
+			}
+			return PhaseDone, fmt.Sprintf("stopped by Docker, killed after %ds if still running", seconds)
+		})
+		if stopErr != nil {
+			return result, fmt.Errorf("failed to stop server: %v", stopErr)
+		}
+	}
+	return result, nil
+}
+
+// dockerStop stops a server whose restart policy would start it again if it
+// exited after a stop command. Docker's SIGTERM makes the image send the stop
+// command instead, and a container stopped through Docker stays stopped.
+func (m *Manager) dockerStop(progress Progress, result *StopResult, id string, timeout int) (*StopResult, error) {
+	var stopErr error
+	result.phase(progress, "stop server", func() (string, string) {
+		if stopErr = m.client.ContainerStop(context.Background(), id, container.StopOptions{Timeout: &timeout}); stopErr != nil {
+			return PhaseFailed, stopErr.Error()
+		}
+		inspect, err := m.client.ContainerInspect(context.Background(), id)
+		if err != nil || inspect.State == nil {
+			return PhaseDone, "stopped by Docker"
+		}
+		// 137 is SIGKILL, sent when the server didn't exit in time
+		if inspect.State.ExitCode == 137 {
+			result.Forced = true
+			return PhaseDone, fmt.Sprintf("server did not exit within %ds and was killed", timeout)
+		}
+		return PhaseDone, fmt.Sprintf("stopped by Docker, exited with code %d", inspect.State.ExitCode)
+	})
+	if stopErr != nil {
+		return result, fmt.Errorf("failed to stop server: %v", stopErr)
+	}
+	return result, nil
+}
+
+// countdown announces a stop in chat when the warning starts and at each of
+// stopWarningMarks, returning when the warning is over.
+func (m *Manager) countdown(ctx context.Context, id string, opts StopOptions) error {
+	event := "shutting down"
+	if opts.Restart {
+		event = "restarting"
+	}
+
+	marks := []int{opts.Warning}
+	for _, mark := range stopWarningMarks {
+		if mark < opts.Warning {
+			marks = append(marks, mark)
+		}
+	}
+	deadline := time.Now().Add(time.Duration(opts.Warning) * time.Second)
+	for _, mark := range marks {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(deadline.Add(-time.Duration(mark) * time.Second))):
+		}
+		if _, err := m.ExecuteCommand(ctx, id, fmt.Sprintf("say Server %s in %s", event, formatSeconds(mark))); err != nil {
+			log.Printf("Error warning players of %s: %v", id, err)
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(deadline)):
+	}
+	return nil
+}
+
+func formatSeconds(seconds int) string {
+	unit, n := "second", seconds
+	if seconds >= 60 && seconds%60 == 0 {
+		unit, n = "minute", seconds/60
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
@@ -0,0 +1,778 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Storage backends for a server's /data
+const (
+	StorageBind   = "bind"
+	StorageVolume = "volume"
+)
+
+// helperLabel marks short-lived containers used to reach volume data.
+const helperLabel = "mboxmini.helper"
+
+// imageUID is the user the server images run as unless UID is set.
+const imageUID = 1000
+
+// Storage is where a server's /data lives: a host directory under dataPath
+// or a named volume labeled with the server ID.
+type Storage struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+}
+
+func (s Storage) mount() mount.Mount {
+	if s.Type == StorageVolume {
+		return mount.Mount{Type: mount.TypeVolume, Source: s.Source, Target: "/data"}
+	}
+	return mount.Mount{Type: mount.TypeBind, Source: s.Source, Target: "/data"}
+}
+
+// local reports whether the API can work on the data directly instead of
+// going through a helper container.
+func (s Storage) local() bool {
+	if s.Type != StorageBind {
+		return false
+	}
+	info, err := os.Stat(s.Source)
+	return err == nil && info.IsDir()
+}
+
+// storageOf finds the /data mount among a container's mounts.
+func storageOf(mounts []types.MountPoint) (Storage, error) {
+	return storageAt(mounts, "/data")
+}
+
+// storageAt finds the mount at target among a container's mounts.
+func storageAt(mounts []types.MountPoint, target string) (Storage, error) {
+	for _, mp := range mounts {
+		if mp.Destination != target {
+			continue
+		}
+		if mp.Type == mount.TypeVolume {
+			return Storage{Type: StorageVolume, Source: mp.Name}, nil
+		}
+		return Storage{Type: StorageBind, Source: mp.Source}, nil
+	}
+	return Storage{}, fmt.Errorf("container has no %s mount", target)
+}
+
+// ValidateStorage checks a storage backend name. Empty means the default.
+func ValidateStorage(kind string) error {
+	switch kind {
+	case "", StorageBind, StorageVolume:
+		return nil
+	}
+	return fmt.Errorf("unknown storage type %q (expected %s or %s)", kind, StorageBind, StorageVolume)
+}
+
+// SetDefaultStorage sets the backend used for new servers that don't ask for
+// one. Empty uses a bind mount when dataPath is available and a volume
+// otherwise.
+func (m *Manager) SetDefaultStorage(kind string) error {
+	if err := ValidateStorage(kind); err != nil {
+		return err
+	}
+	m.defaultStorage = kind
+	return nil
+}
+
+// SetDataOwner sets the UID and GID that own server data directories and
+// that the Minecraft image runs as. A negative value leaves ownership alone.
+func (m *Manager) SetDataOwner(uid, gid int) {
+	m.dataUID = uid
+	m.dataGID = gid
+}
+
+// ownerEnv tells the itzg/minecraft-server image which user to run as so it
+// can write to data directories created by the API.
+func (m *Manager) ownerEnv() []string {
+	var env []string
+	if m.dataUID >= 0 {
+		env = append(env, fmt.Sprintf("UID=%d", m.dataUID))
+	}
+	if m.dataGID >= 0 {
+		env = append(env, fmt.Sprintf("GID=%d", m.dataGID))
+	}
+	return env
+}
+
+// dataOwner is the UID and GID files written into /data should have.
+func (m *Manager) dataOwner() (uid, gid int) {
+	uid, gid = m.dataUID, m.dataGID
+	if uid < 0 {
+		uid = imageUID
+	}
+	if gid < 0 {
+		gid = imageUID
+	}
+	return uid, gid
+}
+
+// volumeName is the named volume used for a server's data.
+func volumeName(containerName string) string {
+	return containerName + "-data"
+}
+
+// provisionData prepares the /data storage for a new server. With no
+// explicit kind the data directory is created directly under dataPath when
+// the API has it mounted, falling back to a named volume when it doesn't.
+func (m *Manager) provisionData(ctx context.Context, containerName, uuid, kind string) (Storage, error) {
+	if kind == "" {
+		kind = m.defaultStorage
+	}
+
+	if kind != StorageVolume {
+		serverDataDir := filepath.Join(m.dataPath, containerName)
+		err := m.createDataDir(serverDataDir)
+		if err == nil {
+			return Storage{Type: StorageBind, Source: serverDataDir}, nil
+		}
+		if kind == StorageBind || os.IsExist(err) {
+			return Storage{}, fmt.Errorf("failed to create data directory %s: %v", serverDataDir, err)
+		}
+		log.Printf("Cannot use data directory %s (%v), falling back to a named volume", serverDataDir, err)
+	}
+
+	name := volumeName(containerName)
+	if _, err := m.client.VolumeInspect(ctx, name); err == nil {
+		return Storage{}, fmt.Errorf("volume %s already exists", name)
+	}
+	if _, err := m.client.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   name,
+		Labels: map[string]string{serverIDLabel: uuid},
+	}); err != nil {
+		log.Printf("Error creating volume %s: %v", name, err)
+		return Storage{}, fmt.Errorf("failed to create volume: %v", err)
+	}
+	log.Printf("Created volume %s", name)
+	return Storage{Type: StorageVolume, Source: name}, nil
+}
+
+// createDataDir creates a server data directory under dataPath and hands it
+// to the configured owner. It fails if dataPath is unset or not mounted.
+func (m *Manager) createDataDir(serverDataDir string) error {
+	if m.dataPath == "" {
+		return fmt.Errorf("DATA_PATH is not set")
+	}
+	if info, err := os.Stat(m.dataPath); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", m.dataPath)
+	}
+
+	// Mkdir rather than MkdirAll so an existing directory is never reused
+	log.Printf("Creating server data directory: %s", serverDataDir)
+	if err := os.Mkdir(serverDataDir, 0755); err != nil {
+		return err
+	}
+	if err := m.chownData(serverDataDir); err != nil {
+		os.Remove(serverDataDir)
+		return err
+	}
+	return nil
+}
+
+// chownData recursively hands path to the configured data owner.
+func (m *Manager) chownData(path string) error {
+	if m.dataUID < 0 && m.dataGID < 0 {
+		return nil
+	}
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(p, m.dataUID, m.dataGID); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %v", p, err)
+		}
+		return nil
+	})
+}
+
+// removeData deletes a server's data directory or volume.
+func (m *Manager) removeData(ctx context.Context, st Storage) error {
+	if st.Type == StorageVolume {
+		if err := m.client.VolumeRemove(ctx, st.Source, true); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %v", st.Source, err)
+		}
+		log.Printf("Removed volume %s", st.Source)
+		return nil
+	}
+
+	if !st.local() {
+		// Empty it through a helper; the host directory itself stays behind
+		return m.clearData(ctx, st)
+	}
+	if err := os.RemoveAll(st.Source); err != nil {
+		return fmt.Errorf("failed to remove server files: %v", err)
+	}
+	log.Printf("Removed server files at %s", st.Source)
+	return nil
+}
+
+// clearData removes everything inside /data but keeps the directory or
+// volume itself.
+func (m *Manager) clearData(ctx context.Context, st Storage) error {
+	if !st.local() {
+		return m.runHelper(ctx, st, "find", "/data", "-mindepth", "1", "-delete")
+	}
+
+	entries, err := os.ReadDir(st.Source)
+	if err != nil {
+		return fmt.Errorf("failed to read data directory: %v", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(st.Source, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear data directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// archiveData writes the contents of /data to w as a gzipped tarball with
+// paths relative to /data.
+func (m *Manager) archiveData(ctx context.Context, st Storage, w io.Writer) error {
+	if st.local() {
+		return writeTarGz(ctx, st.Source, w)
+	}
+
+	id, err := m.createHelper(ctx, st, "true")
+	if err != nil {
+		return err
+	}
+	defer m.removeHelper(id)
+
+	reader, _, err := m.client.CopyFromContainer(ctx, id, "/data")
+	if err != nil {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	defer reader.Close()
+	return rebaseTar(reader, "data", w)
+}
+
+// extractData unpacks a gzipped tarball into /data on top of what is there.
+func (m *Manager) extractData(ctx context.Context, st Storage, r io.Reader) error {
+	if st.local() {
+		if err := extractTarGz(r, st.Source); err != nil {
+			return err
+		}
+		return m.chownData(st.Source)
+	}
+
+	id, err := m.createHelper(ctx, st, "true")
+	if err != nil {
+		return err
+	}
+	defer m.removeHelper(id)
+
+	// The daemon accepts gzipped archives and keeps entries inside /data
+	if err := m.client.CopyToContainer(ctx, id, "/data", r, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to write data: %v", err)
+	}
+	return nil
+}
+
+// writeDataFile writes a single file, and any missing parent directories,
+// into /data owned by the user the server runs as.
+func (m *Manager) writeDataFile(ctx context.Context, st Storage, path string, content []byte) error {
+	return m.writeDataStream(ctx, st, path, bytes.NewReader(content), int64(len(content)))
+}
+
+// writeDataStream is writeDataFile for size bytes read from r. The archive
+// is streamed, so the content is never held in memory.
+func (m *Manager) writeDataStream(ctx context.Context, st Storage, path string, r io.Reader, size int64) error {
+	uid, gid := m.dataOwner()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(func() error {
+			gz := gzip.NewWriter(pw)
+			tw := tar.NewWriter(gz)
+			now := time.Now()
+
+			parts := strings.Split(path, "/")
+			for i := 1; i < len(parts); i++ {
+				if err := tw.WriteHeader(&tar.Header{
+					Typeflag: tar.TypeDir,
+					Name:     strings.Join(parts[:i], "/") + "/",
+					Mode:     0755,
+					Uid:      uid,
+					Gid:      gid,
+					ModTime:  now,
+				}); err != nil {
+					return err
+				}
+			}
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path,
+				Mode:     0644,
+				Size:     size,
+				Uid:      uid,
+				Gid:      gid,
+				ModTime:  now,
+			}); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(tw, r, size); err != nil {
+				return err
+			}
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}())
+	}()
+	err := m.extractData(ctx, st, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// dataArchive writes files as a gzipped tarball for extractData, owned by
+// the user the server runs as and with their parent directories.
+type dataArchive struct {
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	dirs     map[string]bool
+	uid, gid int
+	now      time.Time
+}
+
+func (a *dataArchive) add(name string, mode, size int64, r io.Reader) error {
+	var parents []string
+	for dir := path.Dir(name); dir != "." && !a.dirs[dir]; dir = path.Dir(dir) {
+		a.dirs[dir] = true
+		parents = append([]string{dir}, parents...)
+	}
+	for _, dir := range parents {
+		if err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, Uid: a.uid, Gid: a.gid, ModTime: a.now}); err != nil {
+			return err
+		}
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: size, Uid: a.uid, Gid: a.gid, ModTime: a.now}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(a.tw, r, size)
+	return err
+}
+
+func newDataArchive(w io.Writer, uid, gid int) *dataArchive {
+	gz := gzip.NewWriter(w)
+	return &dataArchive{gz: gz, tw: tar.NewWriter(gz), dirs: map[string]bool{}, uid: uid, gid: gid, now: time.Now()}
+}
+
+func (a *dataArchive) close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// streamData extracts the files write adds into /data as they are added, so
+// large installs need neither memory nor more than one helper container.
+func (m *Manager) streamData(ctx context.Context, st Storage, write func(a *dataArchive) error) error {
+	uid, gid := m.dataOwner()
+	pr, pw := io.Pipe()
+	go func() {
+		a := newDataArchive(pw, uid, gid)
+		err := write(a)
+		if err == nil {
+			err = a.close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err := m.extractData(ctx, st, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// readDataFile reads a single file from /data. Missing files return an
+// error satisfying os.IsNotExist.
+func (m *Manager) readDataFile(ctx context.Context, st Storage, path string) ([]byte, error) {
+	if st.local() {
+		return os.ReadFile(filepath.Join(st.Source, filepath.FromSlash(path)))
+	}
+
+	id, err := m.createHelper(ctx, st, "true")
+	if err != nil {
+		return nil, err
+	}
+	defer m.removeHelper(id)
+
+	reader, _, err := m.client.CopyFromContainer(ctx, id, "/data/"+path)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return io.ReadAll(tr)
+}
+
+// readDataFiles reads several files from /data through at most one helper
+// container. Missing files are left out of the result.
+func (m *Manager) readDataFiles(ctx context.Context, st Storage, paths []string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	if st.local() {
+		for _, p := range paths {
+			content, err := os.ReadFile(filepath.Join(st.Source, filepath.FromSlash(p)))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			files[p] = content
+		}
+		return files, nil
+	}
+	if len(paths) == 0 {
+		return files, nil
+	}
+
+	id, err := m.createHelper(ctx, st, "true")
+	if err != nil {
+		return nil, err
+	}
+	defer m.removeHelper(id)
+
+	for _, p := range paths {
+		reader, _, err := m.client.CopyFromContainer(ctx, id, "/data/"+p)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		tr := tar.NewReader(reader)
+		_, err = tr.Next()
+		if err == nil {
+			files[p], err = io.ReadAll(tr)
+		}
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", p, err)
+		}
+	}
+	return files, nil
+}
+
+// dataDirSizes returns the total size of each directory directly in /data.
+func (m *Manager) dataDirSizes(ctx context.Context, st Storage) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	if st.local() {
+		entries, err := os.ReadDir(st.Source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data directory: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				sizes[entry.Name()] = dirSize(filepath.Join(st.Source, entry.Name()))
+			}
+		}
+		return sizes, nil
+	}
+
+	out, err := m.helperOutput(ctx, st, "sh", "-c", `cd /data && for d in */; do [ -d "$d" ] && du -sb -- "$d"; done; true`)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		size, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes[strings.TrimSuffix(name, "/")] = n
+	}
+	return sizes, nil
+}
+
+// walkDataFiles calls fn with the path relative to /data and the contents
+// of every regular file under dir. A missing dir has no files. Without local
+// access the whole dir is streamed out of a helper container.
+func (m *Manager) walkDataFiles(ctx context.Context, st Storage, dir string, fn func(path string, size int64, r io.Reader) error) error {
+	if st.local() {
+		root := filepath.Join(st.Source, filepath.FromSlash(dir))
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(st.Source, p)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return fn(filepath.ToSlash(rel), info.Size(), f)
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	id, err := m.createHelper(ctx, st, "true")
+	if err != nil {
+		return err
+	}
+	defer m.removeHelper(id)
+
+	reader, _, err := m.client.CopyFromContainer(ctx, id, "/data/"+dir)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+	defer reader.Close()
+
+	// Entries are named after the last element of dir
+	parent := path.Dir(dir)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", dir, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(path.Join(parent, header.Name), header.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// moveDataFile moves a file within /data, creating the destination directory
+// with the same owner as the source directory.
+func (m *Manager) moveDataFile(ctx context.Context, st Storage, from, to string) error {
+	if !st.local() {
+		return m.runHelper(ctx, st, "sh", "-c",
+			`mkdir -p "$(dirname "$2")" && chown --reference="$(dirname "$1")" "$(dirname "$2")" && mv "$1" "$2"`,
+			"sh", "/data/"+from, "/data/"+to)
+	}
+
+	src := filepath.Join(st.Source, filepath.FromSlash(from))
+	dst := filepath.Join(st.Source, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := m.chownData(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// replaceDataDir moves a directory within /data over another, replacing it
+// if it exists.
+func (m *Manager) replaceDataDir(ctx context.Context, st Storage, from, to string) error {
+	if !st.local() {
+		return m.runHelper(ctx, st, "sh", "-c", `rm -rf "$2" && mv "$1" "$2"`, "sh", "/data/"+from, "/data/"+to)
+	}
+
+	dst := filepath.Join(st.Source, filepath.FromSlash(to))
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(st.Source, filepath.FromSlash(from)), dst)
+}
+
+// removeDataFile deletes a single file from /data.
+func (m *Manager) removeDataFile(ctx context.Context, st Storage, path string) error {
+	if !st.local() {
+		return m.runHelper(ctx, st, "rm", "-f", "/data/"+path)
+	}
+	err := os.Remove(filepath.Join(st.Source, filepath.FromSlash(path)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// removeDataDir deletes a directory and everything in it from /data.
+func (m *Manager) removeDataDir(ctx context.Context, st Storage, path string) error {
+	if !st.local() {
+		return m.runHelper(ctx, st, "rm", "-rf", "/data/"+path)
+	}
+	return os.RemoveAll(filepath.Join(st.Source, filepath.FromSlash(path)))
+}
+
+// copyData copies the contents of one server's /data into another's.
+func (m *Manager) copyData(ctx context.Context, src, dst Storage) error {
+	if src.local() && dst.local() {
+		if err := copyDir(ctx, src.Source, dst.Source); err != nil {
+			return err
+		}
+		return m.chownData(dst.Source)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.archiveData(ctx, src, pw))
+	}()
+	err := m.extractData(ctx, dst, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// createHelper creates (without starting) a container from the server image
+// with st mounted at /data. Created containers are enough for copying files
+// in and out.
+func (m *Manager) createHelper(ctx context.Context, st Storage, cmd ...string) (string, error) {
+	return m.createHelperWith(ctx, m.serverImage(), &container.HostConfig{Mounts: []mount.Mount{st.mount()}}, cmd...)
+}
+
+// createHelperWith creates a helper container from image with the given
+// host config.
+func (m *Manager) createHelperWith(ctx context.Context, image string, hostConfig *container.HostConfig, cmd ...string) (string, error) {
+	if err := m.ensureImage(ctx, image, nil); err != nil {
+		return "", err
+	}
+
+	resp, err := m.client.ContainerCreate(ctx,
+		&container.Config{
+			Image:      image,
+			Entrypoint: cmd,
+			User:       "root",
+			Labels:     map[string]string{helperLabel: "true"},
+		},
+		hostConfig,
+		nil, nil, "")
+	if err != nil {
+		log.Printf("Error creating helper container: %v", err)
+		return "", fmt.Errorf("failed to create helper container: %v", err)
+	}
+	return resp.ID, nil
+}
+
+func (m *Manager) removeHelper(id string) {
+	if err := m.client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.Printf("Error removing helper container %s: %v", id, err)
+	}
+}
+
+// runHelper runs cmd in a helper container and waits for it to succeed.
+func (m *Manager) runHelper(ctx context.Context, st Storage, cmd ...string) error {
+	_, err := m.helperOutput(ctx, st, cmd...)
+	return err
+}
+
+// helperOutput runs cmd in a helper container, waits for it to succeed and
+// returns what it printed to stdout.
+func (m *Manager) helperOutput(ctx context.Context, st Storage, cmd ...string) ([]byte, error) {
+	return m.helperOutputWith(ctx, m.serverImage(), &container.HostConfig{Mounts: []mount.Mount{st.mount()}}, cmd...)
+}
+
+// helperOutputWith is helperOutput for a helper from image with the given
+// host config.
+func (m *Manager) helperOutputWith(ctx context.Context, image string, hostConfig *container.HostConfig, cmd ...string) ([]byte, error) {
+	id, err := m.createHelperWith(ctx, image, hostConfig, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	defer m.removeHelper(id)
+
+	if err := m.client.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start helper container: %v", err)
+	}
+
+	statusCh, errCh := m.client.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return nil, fmt.Errorf("failed waiting for helper container: %v", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return nil, fmt.Errorf("%s exited with status %d", strings.Join(cmd, " "), status.StatusCode)
+		}
+	}
+
+	logs, err := m.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{ShowStdout: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read helper output: %v", err)
+	}
+	defer logs.Close()
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, io.Discard, logs); err != nil {
+		return nil, fmt.Errorf("failed to read helper output: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// rebaseTar converts a tar stream whose entries live under root into a
+// gzipped tarball with paths relative to root, like writeTarGz produces.
+func rebaseTar(r io.Reader, root string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(header.Name, root), "/")
+		if name == "" || filepath.Base(name) == "session.lock" {
+			continue
+		}
+		header.Name = name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,399 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// DefaultUpgradeTimeout is how long an upgraded server gets to load its world.
+const DefaultUpgradeTimeout = 10 * time.Minute
+
+// PrepareUpgrade validates the target version and records a pending
+// upgrade. The upgrade itself is performed by RunUpgrade.
+func (m *Manager) PrepareUpgrade(ctx context.Context, serverID, version string) (*database.ServerUpgrade, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	containerName := strings.TrimPrefix(inspect.Name, "/")
+
+	record, err := m.serverRecord(inspect.ID, containerName, inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if active, err := m.db.GetActiveUpgrade(record.ID); err != nil {
+		return nil, err
+	} else if active != nil {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("an upgrade to %s is already in progress", active.ToVersion)}
+	}
+
+	// Bedrock servers on LATEST update themselves on restart and have no
+	// version catalog to validate against
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		return nil, &catalog.ValidationError{Message: "upgrades are only supported for Java Edition servers"}
+	}
+
+	fromVersion := envValue(inspect.Config.Env, "VERSION")
+	if strings.EqualFold(fromVersion, version) {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("server is already on version %s", version)}
+	}
+	if err := m.validateUpgradeVersion(envValue(inspect.Config.Env, "TYPE"), fromVersion, version); err != nil {
+		return nil, err
+	}
+
+	upgrade := &database.ServerUpgrade{
+		ServerID:    record.ID,
+		FromVersion: fromVersion,
+		ToVersion:   version,
+		Status:      database.UpgradeBackingUp,
+	}
+	if err := m.db.CreateUpgrade(upgrade); err != nil {
+		return nil, fmt.Errorf("failed to record upgrade: %v", err)
+	}
+	return upgrade, nil
+}
+
+// RunUpgrade backs up the data directory, recreates the container with the
+// new VERSION and waits for the world to load. On failure the server is
+// stopped and the upgrade is left ready for RollbackUpgrade.
+func (m *Manager) RunUpgrade(ctx context.Context, upgrade *database.ServerUpgrade, timeout time.Duration, progress Progress) error {
+	progress = orNoProgress(progress)
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+
+	server, err := m.db.GetServer(upgrade.ServerID)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return fmt.Errorf("server %s not found", upgrade.ServerID)
+	}
+	containerName := server.ContainerName
+	log.Printf("Upgrading %s from %s to %s", containerName, upgrade.FromVersion, upgrade.ToVersion)
+
+	fail := func(err error) error {
+		log.Printf("Upgrade of %s to %s failed: %v", containerName, upgrade.ToVersion, err)
+		upgrade.Status = database.UpgradeFailed
+		upgrade.Error = err.Error()
+		if err := m.db.UpdateUpgrade(upgrade); err != nil {
+			log.Printf("Error recording upgrade failure: %v", err)
+		}
+		return err
+	}
+
+	backup, err := m.backupServer(ctx, containerName, preUpgradeLabel+upgrade.ToVersion, progress)
+	if err != nil {
+		return fail(fmt.Errorf("backup failed: %v", err))
+	}
+
+	upgrade.BackupPath = backup.Path
+	upgrade.Status = database.UpgradeStarting
+	if err := m.db.UpdateUpgrade(upgrade); err != nil {
+		log.Printf("Error recording upgrade progress: %v", err)
+	}
+
+	progress.Step("pull server image")
+	inspect, err := m.client.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return fail(fmt.Errorf("failed to inspect container: %v", err))
+	}
+	if err := m.ensureImage(ctx, inspect.Config.Image, progress); err != nil {
+		return fail(err)
+	}
+
+	progress.Step("recreate container")
+	if err := m.recreateWithVersion(ctx, containerName, upgrade.ToVersion); err != nil {
+		return fail(err)
+	}
+
+	progress.Step("wait for world to load")
+	if err := m.waitForStartup(ctx, containerName, timeout); err != nil {
+		// Don't leave a crash-looping server behind; the backup is ready for rollback
+		m.StopServer(containerName)
+		return fail(err)
+	}
+
+	upgrade.Status = database.UpgradeSucceeded
+	if err := m.db.UpdateUpgrade(upgrade); err != nil {
+		log.Printf("Error recording upgrade success: %v", err)
+	}
+	log.Printf("Upgrade of %s to %s succeeded", containerName, upgrade.ToVersion)
+	return nil
+}
+
+// UpgradeNotFoundError is returned when a server has no upgrade with the
+// given ID.
+type UpgradeNotFoundError struct {
+	ID int64
+}
+
+func (e *UpgradeNotFoundError) Error() string {
+	return fmt.Sprintf("upgrade %d not found", e.ID)
+}
+
+// PrepareRollback checks that an upgrade can be rolled back so callers can
+// reject it before starting RollbackUpgrade.
+func (m *Manager) PrepareRollback(ctx context.Context, serverID string, upgradeID int64) (*database.ServerUpgrade, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade, err := m.db.GetUpgrade(upgradeID)
+	if err != nil {
+		return nil, err
+	}
+	if upgrade == nil || upgrade.ServerID != record.ID {
+		return nil, &UpgradeNotFoundError{ID: upgradeID}
+	}
+	if upgrade.Status != database.UpgradeFailed && upgrade.Status != database.UpgradeSucceeded {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("upgrade %d cannot be rolled back while %s", upgradeID, upgrade.Status)}
+	}
+	if upgrade.BackupPath == "" {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("upgrade %d has no backup to restore", upgradeID)}
+	}
+	if active, err := m.db.GetActiveUpgrade(record.ID); err != nil {
+		return nil, err
+	} else if active != nil {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("an upgrade to %s is in progress", active.ToVersion)}
+	}
+	return upgrade, nil
+}
+
+// RollbackUpgrade restores the pre-upgrade backup and recreates the
+// container on the previous version.
+func (m *Manager) RollbackUpgrade(ctx context.Context, upgrade *database.ServerUpgrade, progress Progress) error {
+	progress = orNoProgress(progress)
+
+	server, err := m.db.GetServer(upgrade.ServerID)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return fmt.Errorf("server %s not found", upgrade.ServerID)
+	}
+	containerName := server.ContainerName
+	inspect, err := m.client.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	log.Printf("Rolling back %s to %s from %s", containerName, upgrade.FromVersion, upgrade.BackupPath)
+
+	if inspect.State.Running {
+		progress.Step("stop server")
+		if err := m.StopServer(containerName); err != nil {
+			return fmt.Errorf("failed to stop server: %v", err)
+		}
+	}
+
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return err
+	}
+	progress.Step("restore backup")
+	if err := m.restoreBackup(ctx, storage, upgrade.BackupPath); err != nil {
+		return err
+	}
+
+	progress.Step("recreate container")
+	if err := m.recreateWithVersion(ctx, containerName, upgrade.FromVersion); err != nil {
+		return err
+	}
+
+	upgrade.Status = database.UpgradeRolledBack
+	if err := m.db.UpdateUpgrade(upgrade); err != nil {
+		return fmt.Errorf("failed to record rollback: %v", err)
+	}
+
+	log.Printf("Rolled back %s to %s", containerName, upgrade.FromVersion)
+	return nil
+}
+
+// ListUpgrades returns the upgrade history of a server, newest first.
+func (m *Manager) ListUpgrades(ctx context.Context, serverID string) ([]database.ServerUpgrade, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	record, err := m.serverRecord(inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+	return m.db.ListUpgrades(record.ID)
+}
+
+// recreateWithVersion replaces the container with an identical one running
+// the given VERSION and starts it.
+func (m *Manager) recreateWithVersion(ctx context.Context, containerName, version string) error {
+	return m.recreateContainer(ctx, containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		cfg.Env = setEnv(cfg.Env, "VERSION", version)
+	}, true)
+}
+
+// recreateContainer stops and removes a container and creates a new one with
+// the same name, configuration, host configuration and networks after
+// applying modify. Data lives in the mounted directory so nothing is lost.
+// Once the old container is gone the new one is created regardless of ctx,
+// so a cancelled caller can't leave the server without a container.
+func (m *Manager) recreateContainer(ctx context.Context, containerName string, modify func(*container.Config, *container.HostConfig), start bool) error {
+	inspect, err := m.client.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+
+	cfg := inspect.Config
+	hostConfig := inspect.HostConfig
+	cfg.Hostname = ""
+	modify(cfg, hostConfig)
+
+	if inspect.State.Running {
+		if err := m.StopServer(containerName); err != nil {
+			return fmt.Errorf("failed to stop server: %v", err)
+		}
+	}
+
+	if err := m.client.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
+	ctx = context.Background()
+
+	if _, err := m.client.ContainerCreate(ctx, cfg, hostConfig, nil, nil, containerName); err != nil {
+		return fmt.Errorf("failed to recreate container: %v", err)
+	}
+
+	// The new container starts on the default network only, so rejoin the
+	// others, such as a server network's
+	for name := range inspect.NetworkSettings.Networks {
+		if name == "bridge" || name == string(hostConfig.NetworkMode) {
+			continue
+		}
+		if err := m.client.NetworkConnect(ctx, name, containerName, nil); err != nil {
+			return fmt.Errorf("failed to reconnect to network %s: %v", name, err)
+		}
+	}
+
+	if start {
+		if err := m.client.ContainerStart(ctx, containerName, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %v", err)
+		}
+	}
+	return nil
+}
+
+// waitForStartup follows the container log until the server reports that
+// the world has loaded, the container exits, or the timeout expires.
+func (m *Manager) waitForStartup(ctx context.Context, containerName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logs, err := m.client.ContainerLogs(ctx, containerName, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to follow container logs: %v", err)
+	}
+	defer logs.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(logs)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// e.g. [Server thread/INFO]: Done (12.345s)! For help, type "help"
+			if strings.Contains(line, "]: Done (") && strings.Contains(line, "For help") {
+				done <- nil
+				return
+			}
+		}
+		done <- fmt.Errorf("server exited before the world finished loading")
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return ctx.Err()
+		}
+		return fmt.Errorf("server did not finish loading within %s", timeout)
+	}
+}
+
+// validateUpgradeVersion checks the target against the version catalog and
+// refuses downgrades, which Minecraft worlds do not support. LATEST stands
+// for the catalog's newest release, and versions the catalog can't order
+// are refused rather than risking a downgrade.
+func (m *Manager) validateUpgradeVersion(serverType, fromVersion, toVersion string) error {
+	if err := m.catalog.Validate(serverType, toVersion); err != nil {
+		return err
+	}
+	// CUSTOM servers bring their own jar, so there is nothing to compare
+	if catalog.NormalizeType(serverType) == "CUSTOM" {
+		return nil
+	}
+	cmp, ok := m.catalog.Compare(serverType, toVersion, fromVersion)
+	if !ok {
+		return &catalog.ValidationError{Message: fmt.Sprintf("cannot tell whether %s is older than %s; refresh the version catalog and try again", toVersion, fromVersion)}
+	}
+	if cmp < 0 {
+		return &catalog.ValidationError{Message: fmt.Sprintf("cannot downgrade from %s to %s", fromVersion, toVersion)}
+	}
+	return nil
+}
+
+// envValue returns the value of key in a KEY=value environment list.
+func envValue(env []string, key string) string {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return strings.TrimPrefix(e, key+"=")
+		}
+	}
+	return ""
+}
+
+// lookupEnv is envValue that also reports whether key is set at all.
+func lookupEnv(env []string, key string) (string, bool) {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return strings.TrimPrefix(e, key+"="), true
+		}
+	}
+	return "", false
+}
+
+// setEnv replaces or appends key in a KEY=value environment list.
+func setEnv(env []string, key, value string) []string {
+	return append(removeEnv(env, key), fmt.Sprintf("%s=%s", key, value))
+}
+
+// removeEnv drops key from a KEY=value environment list.
+func removeEnv(env []string, key string) []string {
+	out := make([]string, 0, len(env))
+	for _, e := range env {
+		if !strings.HasPrefix(e, key+"=") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
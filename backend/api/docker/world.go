@@ -0,0 +1,324 @@
+package docker
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/world"
+)
+
+// defaultLevelName is the world folder the image uses unless LEVEL is set.
+const defaultLevelName = "world"
+
+// worldImportPrefix marks the hidden folders a world is unpacked into before
+// it replaces the server's.
+const worldImportPrefix = ".import-"
+
+var levelNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,63}$`)
+
+// WorldImport says where an uploaded world is installed.
+type WorldImport struct {
+	// LevelName is the world folder and level-name; it defaults to the
+	// folder name in the archive, or "world".
+	LevelName string `json:"levelName"`
+	// Replace overwrites an existing world with the same name.
+	Replace bool `json:"replace"`
+}
+
+// ImportedWorld describes a world installed into a server.
+type ImportedWorld struct {
+	LevelName string       `json:"levelName"`
+	Layout    string       `json:"layout"`
+	World     *world.World `json:"world"`
+}
+
+// worldServer is what a world import needs to know about a server.
+type worldServer struct {
+	containerName string
+	serverType    string
+	version       string
+	levelName     string
+	storage       Storage
+	inspect       types.ContainerJSON
+}
+
+func (m *Manager) worldServer(ctx context.Context, serverID string) (*worldServer, error) {
+	inspect, err := m.client.ContainerInspect(ctx, m.containerRef(serverID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	if editionOf(inspect.Config.Image, inspect.Config.Labels) == EditionBedrock {
+		return nil, &catalog.ValidationError{Message: "worlds can only be managed on Java Edition servers, and this is a Bedrock Edition server"}
+	}
+	storage, err := storageOf(inspect.Mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	levelName := envValue(inspect.Config.Env, "LEVEL")
+	if levelName == "" {
+		levelName = defaultLevelName
+	}
+	return &worldServer{
+		containerName: strings.TrimPrefix(inspect.Name, "/"),
+		serverType:    catalog.NormalizeType(envValue(inspect.Config.Env, "TYPE")),
+		version:       envValue(inspect.Config.Env, "VERSION"),
+		levelName:     levelName,
+		storage:       storage,
+		inspect:       inspect,
+	}, nil
+}
+
+// CheckWorldImport verifies that w can be installed into a server as opts
+// asks and returns the level name it would get.
+func (m *Manager) CheckWorldImport(ctx context.Context, serverID string, w *world.World, opts WorldImport) (string, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return "", err
+	}
+	return m.checkWorldImport(ctx, server, w, opts)
+}
+
+func (m *Manager) checkWorldImport(ctx context.Context, server *worldServer, w *world.World, opts WorldImport) (string, error) {
+	if server.inspect.State.Running {
+		return "", &catalog.ValidationError{Message: "stop the server before importing a world"}
+	}
+
+	levelName := opts.LevelName
+	if levelName == "" {
+		levelName = w.Name()
+		if !levelNamePattern.MatchString(levelName) {
+			levelName = defaultLevelName
+		}
+	}
+	if !levelNamePattern.MatchString(levelName) {
+		return "", &catalog.ValidationError{Message: fmt.Sprintf("invalid level name %q: use up to 64 letters, digits, dots, dashes and underscores", levelName)}
+	}
+
+	// Worlds can be upgraded but not downgraded
+	if cmp, _ := m.catalog.Compare(server.serverType, w.Version, server.version); w.Version != "" && cmp > 0 {
+		return "", &catalog.ValidationError{Message: fmt.Sprintf("world was saved by Minecraft %s, newer than the server's %s", w.Version, server.version)}
+	}
+
+	if !opts.Replace {
+		_, err := m.readDataFile(ctx, server.storage, levelName+"/level.dat")
+		if err == nil {
+			return "", &catalog.ValidationError{Message: fmt.Sprintf("server already has a world named %s; choose another level name or replace it", levelName)}
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to check for an existing world: %v", err)
+		}
+	}
+	return levelName, nil
+}
+
+// ImportWorld installs an uploaded world into a stopped server and makes it
+// the world the server loads. Bukkit-based servers keep a split Nether and
+// End; other servers get them merged into the world folder.
+func (m *Manager) ImportWorld(ctx context.Context, serverID string, w *world.World, opts WorldImport, progress Progress) (*ImportedWorld, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	levelName, err := m.checkWorldImport(ctx, server, w, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	split := containsString(typeLoaders[server.serverType], LoaderBukkit)
+	layout := world.LayoutVanilla
+	if split && w.Layout == world.LayoutBukkit {
+		layout = world.LayoutBukkit
+	}
+	log.Printf("Importing %s world %q (%s layout, Minecraft %s) into %s as %s",
+		w.Format, w.LevelName, w.Layout, w.Version, server.containerName, levelName)
+
+	// The world is unpacked next to any existing one and only moved into
+	// place once it is complete, so a failed import leaves the old world
+	progress.Step("install world")
+	stage := worldImportPrefix + levelName
+	for _, folder := range worldFolders(stage) {
+		if err := m.removeDataDir(ctx, server.storage, folder); err != nil {
+			return nil, fmt.Errorf("failed to clear an earlier import: %v", err)
+		}
+	}
+
+	done := 0
+	staged := map[string]bool{}
+	err = m.streamData(ctx, server.storage, func(a *dataArchive) error {
+		return w.Walk(stage, split, func(name string, mode, size int64, r io.Reader) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := a.add(name, mode, size, r); err != nil {
+				return err
+			}
+			staged[strings.SplitN(name, "/", 2)[0]] = true
+			done++
+			progress.Update(done*100/w.Files, name)
+			return nil
+		})
+	})
+	if err != nil {
+		for _, folder := range worldFolders(stage) {
+			if rmErr := m.removeDataDir(context.Background(), server.storage, folder); rmErr != nil {
+				log.Printf("Error removing failed import %s: %v", folder, rmErr)
+			}
+		}
+		return nil, fmt.Errorf("failed to install world: %v", err)
+	}
+
+	// The swap must not be cut short half way
+	folders := worldFolders(levelName)
+	for i, folder := range worldFolders(stage) {
+		if staged[folder] {
+			err = m.replaceDataDir(context.Background(), server.storage, folder, folders[i])
+		} else if opts.Replace {
+			err = m.removeDataDir(context.Background(), server.storage, folders[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to move the imported world into place: %v", err)
+		}
+	}
+	log.Printf("Installed %d world files into %s", done, server.storage.Source)
+
+	if levelName != server.levelName {
+		progress.Step("set level name")
+		if err := m.recreateContainer(ctx, server.containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+			cfg.Env = setEnv(cfg.Env, "LEVEL", levelName)
+		}, false); err != nil {
+			return nil, err
+		}
+		log.Printf("Server %s now loads world %s", server.containerName, levelName)
+	}
+
+	return &ImportedWorld{LevelName: levelName, Layout: layout, World: w}, nil
+}
+
+// MaxWorldSize is the most a world archive may unpack to.
+func (m *Manager) MaxWorldSize() int64 {
+	size, err := ParseMemory(m.db.SettingString("max_world_size"))
+	if err != nil {
+		log.Printf("Invalid max_world_size setting: %v", err)
+		return 10 * gib
+	}
+	return size
+}
+
+// WorldExport is a world being downloaded. Files and Bytes count what has
+// been written so far.
+type WorldExport struct {
+	LevelName string `json:"levelName"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+
+	server *worldServer
+}
+
+// ExportWorld prepares a download of the world a Java server loads.
+func (m *Manager) ExportWorld(ctx context.Context, serverID string) (*WorldExport, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.readDataFile(ctx, server.storage, server.levelName+"/level.dat"); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("server has no world %s yet; start it once to generate one", server.levelName)}
+		}
+		return nil, fmt.Errorf("failed to read the world: %v", err)
+	}
+	return &WorldExport{LevelName: server.levelName, server: server}, nil
+}
+
+// WriteWorld streams an export to w as a zip that singleplayer can open: one
+// folder named after the level, with the Nether and End that Bukkit-based
+// servers keep in separate folders moved back inside it. Nothing is staged
+// on disk: a running server has saving paused while the zip is streamed and
+// resumed when the download ends, however it ends.
+func (m *Manager) WriteWorld(ctx context.Context, export *WorldExport, w io.Writer) error {
+	server := export.server
+	if server.inspect.State.Running {
+		if err := m.flushSaves(ctx, server.inspect); err != nil {
+			return err
+		}
+		defer func() {
+			if err := m.resumeSaves(context.Background(), server.inspect); err != nil {
+				log.Printf("Error resuming saves on %s: %v", server.containerName, err)
+			}
+		}()
+	}
+
+	level := server.levelName
+	split := containsString(typeLoaders[server.serverType], LoaderBukkit)
+	zw := zip.NewWriter(w)
+	now := time.Now()
+
+	// add writes a file found under dir to the zip, below the level folder
+	add := func(dir string) func(p string, size int64, r io.Reader) error {
+		return func(p string, size int64, r io.Reader) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel := strings.TrimPrefix(p, dir+"/")
+			if path.Base(rel) == "session.lock" {
+				return nil
+			}
+			// Bukkit leaves stale dimension folders in the main world
+			if dir == level && split && (isWithin(rel, "DIM-1") || isWithin(rel, "DIM1")) {
+				return nil
+			}
+
+			// Region files are compressed already
+			method := zip.Deflate
+			if strings.HasSuffix(rel, ".mca") {
+				method = zip.Store
+			}
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: level + "/" + rel, Method: method, Modified: now})
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(fw, r)
+			if err != nil {
+				return err
+			}
+			export.Files++
+			export.Bytes += n
+			return nil
+		}
+	}
+
+	if err := m.walkDataFiles(ctx, server.storage, level, add(level)); err != nil {
+		return fmt.Errorf("failed to export world: %v", err)
+	}
+	if split {
+		for _, dim := range []struct{ folder, data string }{
+			{level + "_nether", "DIM-1"},
+			{level + "_the_end", "DIM1"},
+		} {
+			if err := m.walkDataFiles(ctx, server.storage, dim.folder+"/"+dim.data, add(dim.folder)); err != nil {
+				return fmt.Errorf("failed to export world: %v", err)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	log.Printf("Exported world %s of %s: %d files, %d bytes", level, server.containerName, export.Files, export.Bytes)
+	return nil
+}
+
+// isWithin reports whether rel is dir or inside it.
+func isWithin(rel, dir string) bool {
+	return rel == dir || strings.HasPrefix(rel, dir+"/")
+}
@@ -0,0 +1,367 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/world"
+)
+
+// levelTypes maps the world types a new world can have to the image's
+// LEVEL_TYPE values.
+var levelTypes = map[string]string{
+	"normal":       "minecraft:normal",
+	"flat":         "minecraft:flat",
+	"large_biomes": "minecraft:large_biomes",
+	"amplified":    "minecraft:amplified",
+}
+
+// WorldInfo is a world folder in a server's data directory. Size includes a
+// Bukkit server's separate Nether and End folders.
+type WorldInfo struct {
+	Name       string     `json:"name"`
+	Active     bool       `json:"active"`
+	Size       int64      `json:"size"`
+	LastPlayed *time.Time `json:"lastPlayed,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	// Pending is set for the active world before the server has generated it
+	Pending bool `json:"pending,omitempty"`
+}
+
+// NewWorld describes a world for the server to generate.
+type NewWorld struct {
+	Name      string `json:"name"`
+	Seed      string `json:"seed"`
+	LevelType string `json:"levelType"`
+}
+
+// WorldArchive is an archived world in a server's backup storage. It can be
+// brought back by importing it as a world.
+type WorldArchive struct {
+	Name      string    `json:"name"`
+	World     string    `json:"world"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// worldFolders are the folders a world occupies in /data.
+func worldFolders(name string) []string {
+	return []string{name, name + "_nether", name + "_the_end"}
+}
+
+// ListWorlds returns the worlds in a Java server's data directory.
+func (m *Manager) ListWorlds(ctx context.Context, serverID string) ([]WorldInfo, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return m.listWorlds(ctx, server)
+}
+
+func hasKey(m map[string]int64, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func (m *Manager) listWorlds(ctx context.Context, server *worldServer) ([]WorldInfo, error) {
+	sizes, err := m.dataDirSizes(ctx, server.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var names, paths []string
+	for name := range sizes {
+		// Hidden folders hold worlds still being imported
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		// A Bukkit Nether or End folder belongs to the world next to it
+		if base, ok := strings.CutSuffix(name, "_nether"); ok && hasKey(sizes, base) {
+			continue
+		}
+		if base, ok := strings.CutSuffix(name, "_the_end"); ok && hasKey(sizes, base) {
+			continue
+		}
+		names = append(names, name)
+		paths = append(paths, name+"/level.dat")
+	}
+	levels, err := m.readDataFiles(ctx, server.storage, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	worlds := []WorldInfo{}
+	activeFound := false
+	for _, name := range names {
+		data, ok := levels[name+"/level.dat"]
+		if !ok {
+			continue
+		}
+		info := WorldInfo{Name: name, Active: name == server.levelName}
+		for _, folder := range worldFolders(name) {
+			info.Size += sizes[folder]
+		}
+		if level, err := world.ReadLevel(data); err != nil {
+			log.Printf("Error reading level.dat of world %s on %s: %v", name, server.containerName, err)
+		} else {
+			info.Version = level.Version
+			if !level.LastPlayed.IsZero() {
+				info.LastPlayed = &level.LastPlayed
+			}
+		}
+		activeFound = activeFound || info.Active
+		worlds = append(worlds, info)
+	}
+	if !activeFound {
+		worlds = append(worlds, WorldInfo{Name: server.levelName, Active: true, Pending: true})
+	}
+
+	sort.Slice(worlds, func(i, j int) bool { return worlds[i].Name < worlds[j].Name })
+	return worlds, nil
+}
+
+// findWorld returns a world of the server by folder name.
+func (m *Manager) findWorld(ctx context.Context, serverID, name string) (*worldServer, *WorldInfo, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	worlds, err := m.listWorlds(ctx, server)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range worlds {
+		if worlds[i].Name == name && !worlds[i].Pending {
+			return server, &worlds[i], nil
+		}
+	}
+	return nil, nil, &WorldNotFoundError{Name: name}
+}
+
+// WorldNotFoundError is returned for a world a server doesn't have.
+type WorldNotFoundError struct {
+	Name string
+}
+
+func (e *WorldNotFoundError) Error() string {
+	return fmt.Sprintf("world %s not found", e.Name)
+}
+
+// CreateWorld makes a new world the server's active world. The server
+// generates it with the given seed and type when it next starts, so a
+// running server is restarted.
+func (m *Manager) CreateWorld(ctx context.Context, serverID string, req NewWorld) (*WorldInfo, error) {
+	if !levelNamePattern.MatchString(req.Name) {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("invalid world name %q: use up to 64 letters, digits, dots, dashes and underscores", req.Name)}
+	}
+	if len(req.Seed) > 64 || strings.IndexFunc(req.Seed, unicode.IsControl) >= 0 {
+		return nil, &catalog.ValidationError{Message: "seed must be at most 64 printable characters"}
+	}
+	levelType := ""
+	if req.LevelType != "" {
+		var ok bool
+		if levelType, ok = levelTypes[strings.ToLower(req.LevelType)]; !ok {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("unknown level type %q: use normal, flat, large_biomes or amplified", req.LevelType)}
+		}
+	}
+
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := m.dataDirSizes(ctx, server.storage)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range worldFolders(req.Name) {
+		if _, exists := sizes[folder]; exists {
+			return nil, &catalog.ValidationError{Message: fmt.Sprintf("server already has a folder named %s", folder)}
+		}
+	}
+
+	log.Printf("Creating world %s on %s (seed %q, type %q)", req.Name, server.containerName, req.Seed, levelType)
+	// Seed and type only apply to worlds that don't exist yet, so a stale
+	// seed must not carry over to the new world
+	if err := m.recreateContainer(ctx, server.containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		cfg.Env = setEnv(cfg.Env, "LEVEL", req.Name)
+		cfg.Env = removeEnv(cfg.Env, "SEED")
+		if req.Seed != "" {
+			cfg.Env = setEnv(cfg.Env, "SEED", req.Seed)
+		}
+		cfg.Env = removeEnv(cfg.Env, "LEVEL_TYPE")
+		if levelType != "" {
+			cfg.Env = setEnv(cfg.Env, "LEVEL_TYPE", levelType)
+		}
+	}, server.inspect.State.Running); err != nil {
+		return nil, err
+	}
+	return &WorldInfo{Name: req.Name, Active: true, Pending: true}, nil
+}
+
+// SetActiveWorld switches the world a server loads, restarting it if it is
+// running.
+func (m *Manager) SetActiveWorld(ctx context.Context, serverID, name string) (*WorldInfo, error) {
+	server, info, err := m.findWorld(ctx, serverID, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Active {
+		return info, nil
+	}
+
+	log.Printf("Switching %s from world %s to %s", server.containerName, server.levelName, name)
+	if err := m.recreateContainer(ctx, server.containerName, func(cfg *container.Config, hostConfig *container.HostConfig) {
+		cfg.Env = setEnv(cfg.Env, "LEVEL", name)
+	}, server.inspect.State.Running); err != nil {
+		return nil, err
+	}
+	info.Active = true
+	return info, nil
+}
+
+// worldArchiveStamp is the time format that ends archive names.
+const worldArchiveStamp = "20060102-150405"
+
+// worldArchiveDir is where a server's archived worlds are kept, inside its
+// backup storage so they count towards the owner's disk usage.
+func (m *Manager) worldArchiveDir(containerName string) (string, error) {
+	dir, err := m.backupDir(containerName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "worlds"), nil
+}
+
+// ListWorldArchives returns a server's archived worlds, newest first.
+func (m *Manager) ListWorldArchives(ctx context.Context, serverID string) ([]WorldArchive, error) {
+	server, err := m.worldServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := m.worldArchiveDir(server.containerName)
+	if err != nil {
+		return []WorldArchive{}, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []WorldArchive{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read world archive directory: %v", err)
+	}
+
+	archives := []WorldArchive{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// Names are <world>-<timestamp>.tar.gz
+		worldName := strings.TrimSuffix(name, ".tar.gz")
+		if len(worldName) > len(worldArchiveStamp)+1 {
+			worldName = worldName[:len(worldName)-len(worldArchiveStamp)-1]
+		}
+		archives = append(archives, WorldArchive{
+			Name:      name,
+			World:     worldName,
+			Path:      filepath.Join(dir, name),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime(),
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].CreatedAt.After(archives[j].CreatedAt) })
+	return archives, nil
+}
+
+// ArchiveWorld moves an inactive world out of the data directory into a
+// gzipped tarball in the server's backup storage.
+func (m *Manager) ArchiveWorld(ctx context.Context, serverID, name string, progress Progress) (*WorldArchive, error) {
+	progress = orNoProgress(progress)
+	server, info, err := m.findWorld(ctx, serverID, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Active {
+		return nil, &catalog.ValidationError{Message: fmt.Sprintf("world %s is active; switch to another world before archiving it", name)}
+	}
+
+	dir, err := m.worldArchiveDir(server.containerName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create world archive directory: %v", err)
+	}
+	now := time.Now()
+	fileName := name + "-" + now.Format(worldArchiveStamp) + ".tar.gz"
+	archivePath := filepath.Join(dir, fileName)
+
+	progress.Step("archive world")
+	log.Printf("Archiving world %s of %s to %s", name, server.containerName, archivePath)
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create world archive: %v", err)
+	}
+	uid, gid := m.dataOwner()
+	a := newDataArchive(f, uid, gid)
+	var written int64
+	err = func() error {
+		for _, folder := range worldFolders(name) {
+			err := m.walkDataFiles(ctx, server.storage, folder, func(p string, size int64, r io.Reader) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := a.add(p, 0644, size, r); err != nil {
+					return err
+				}
+				written += size
+				if info.Size > 0 {
+					progress.Update(int(written*100/info.Size), p)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err := a.close(); err != nil {
+			return err
+		}
+		return f.Close()
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(archivePath)
+		return nil, fmt.Errorf("failed to archive world: %v", err)
+	}
+
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Step("remove world")
+	for _, folder := range worldFolders(name) {
+		if err := m.removeDataDir(ctx, server.storage, folder); err != nil {
+			return nil, fmt.Errorf("world was archived to %s but could not be removed: %v", fileName, err)
+		}
+	}
+	log.Printf("Archived world %s of %s (%d bytes)", name, server.containerName, stat.Size())
+	return &WorldArchive{Name: fileName, World: name, Path: archivePath, Size: stat.Size(), CreatedAt: now}, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+)
+
+// maxAddonUpload caps an uploaded jar or zip of jars.
+const maxAddonUpload = 256 << 20
+
+type AddonHandler struct {
+	dockerManager *docker.Manager
+}
+
+func NewAddonHandler(dm *docker.Manager) *AddonHandler {
+	return &AddonHandler{dockerManager: dm}
+}
+
+type UpdateAddonRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (h *AddonHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/servers/{id}/addons", h.ListAddons).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/addons", h.UploadAddon).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/addons/{file}", h.UpdateAddon).Methods("PATCH", "OPTIONS")
+	r.HandleFunc("/servers/{id}/addons/{file}", h.DeleteAddon).Methods("DELETE", "OPTIONS")
+}
+
+func (h *AddonHandler) ListAddons(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	addons, err := h.dockerManager.ListAddons(r.Context(), serverID)
+	if err != nil {
+		log.Printf("Error listing addons of server %s: %v", serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addons)
+}
+
+// UploadAddon installs the jar, or zip of jars, sent as the "file" field of
+// a multipart form. ?force=true installs addons built for another loader.
+func (h *AddonHandler) UploadAddon(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAddonUpload+1<<20)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+
+	// Jars can be large, so the upload is spooled to disk rather than memory
+	upload, err := os.CreateTemp("", "mboxmini-addon-*")
+	if err != nil {
+		log.Printf("Error creating addon upload file: %v", err)
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		upload.Close()
+		os.Remove(upload.Name())
+	}()
+
+	var fileName string
+	var size int64
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			fileName = part.FileName()
+			if size, err = io.Copy(upload, io.LimitReader(part, maxAddonUpload+1)); err != nil {
+				log.Printf("Error receiving addon upload: %v", err)
+				http.Error(w, "Failed to read upload", http.StatusBadRequest)
+				return
+			}
+			if size > maxAddonUpload {
+				http.Error(w, fmt.Sprintf("Uploads are limited to %d MB", maxAddonUpload>>20), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+	}
+	if fileName == "" || size == 0 {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	addons, err := h.dockerManager.InstallAddon(r.Context(), serverID, fileName, upload, size, force)
+	if err != nil {
+		log.Printf("Error installing %s on server %s: %v", fileName, serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(addons)
+}
+
+func (h *AddonHandler) UpdateAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req UpdateAddonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	addon, err := h.dockerManager.SetAddonEnabled(r.Context(), vars["id"], vars["file"], *req.Enabled)
+	if err != nil {
+		log.Printf("Error updating addon %s on server %s: %v", vars["file"], vars["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addon)
+}
+
+func (h *AddonHandler) DeleteAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.dockerManager.DeleteAddon(r.Context(), vars["id"], vars["file"]); err != nil {
+		log.Printf("Error deleting addon %s from server %s: %v", vars["file"], vars["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
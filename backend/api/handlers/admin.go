@@ -8,6 +8,7 @@
 
 	"github.com/gorilla/mux"
 	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
 )
 
 type AdminHandler struct {
@@ -19,9 +20,64 @@ func NewAdminHandler(db *database.DB) *AdminHandler {
 }
 
 func (h *AdminHandler) RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/admin/users", h.ListUsers).Methods("GET", "OPTIONS")
-	r.HandleFunc("/admin/users", h.CreateUser).Methods("POST", "OPTIONS")
-	r.HandleFunc("/admin/users/{id}", h.DeleteUser).Methods("DELETE", "OPTIONS")
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin)
+	admin.HandleFunc("/users", h.ListUsers).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/users", h.CreateUser).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/users/{id}", h.DeleteUser).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc("/ports", h.ListPorts).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/audit", h.ListAudit).Methods("GET", "OPTIONS")
+}
+
+// ListPorts returns the host ports reserved for servers.
+func (h *AdminHandler) ListPorts(w http.ResponseWriter, r *http.Request) {
+	ports, err := h.db.ListPortAllocations()
+	if err != nil {
+		log.Printf("Error listing port allocations: %v", err)
+		http.Error(w, "Failed to fetch ports", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ports)
+}
+
+// ListAudit returns recent audit log entries, optionally filtered by
+// ?action=, ?serverId= and ?userId=.
+func (h *AdminHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	filter := database.AuditFilter{
+		Action:   query.Get("action"),
+		ServerID: query.Get("serverId"),
+	}
+	if value := query.Get("userId"); value != "" {
+		userID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = userID
+	}
+
+	entries, err := h.db.ListAudit(filter, limit)
+	if err != nil {
+		log.Printf("Error listing audit log: %v", err)
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
 }
 
 func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +95,7 @@ func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 		safeUsers[i] = map[string]interface{}{
 			"_id":       user.ID,
 			"email":     user.Username, // Using username as email
+			"isAdmin":   user.IsAdmin,
 			"createdAt": user.CreatedAt,
 		}
 	}
@@ -51,6 +108,7 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		IsAdmin  bool   `json:"isAdmin"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -64,7 +122,11 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.db.CreateUser(input.Email, input.Password)
+	create := h.db.CreateUser
+	if input.IsAdmin {
+		create = h.db.CreateAdmin
+	}
+	user, err := create(input.Email, input.Password)
 	if err != nil {
 		log.Printf("Error creating user: %v", err)
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
@@ -77,6 +139,7 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		"message": "User created successfully",
 		"_id":     user.ID,
 		"email":   user.Username,
+		"isAdmin": user.IsAdmin,
 	})
 }
 
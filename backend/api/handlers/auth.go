@@ -109,6 +109,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.db.SettingString("registration_mode") == database.RegistrationClosed {
+		http.Error(w, "Registration is closed", http.StatusForbidden)
+		return
+	}
+
 	user, err := h.db.CreateUser(req.Username, req.Password)
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
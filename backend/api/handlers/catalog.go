@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
+)
+
+type CatalogHandler struct {
+	catalog *catalog.Catalog
+}
+
+type CatalogResponse struct {
+	Types     []string            `json:"types"`
+	Versions  map[string][]string `json:"versions"`
+	UpdatedAt string              `json:"updatedAt,omitempty"`
+	Offline   bool                `json:"offline"`
+}
+
+func NewCatalogHandler(c *catalog.Catalog) *CatalogHandler {
+	return &CatalogHandler{catalog: c}
+}
+
+func (h *CatalogHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/catalog/versions", h.ListVersions).Methods("GET", "OPTIONS")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin)
+	admin.HandleFunc("/catalog/refresh", h.Refresh).Methods("POST", "OPTIONS")
+}
+
+// ListVersions returns the known server types and their versions. The
+// optional type query parameter limits the response to a single type.
+func (h *CatalogHandler) ListVersions(w http.ResponseWriter, r *http.Request) {
+	manifest := h.catalog.Manifest()
+
+	response := CatalogResponse{
+		Types:    catalog.Types,
+		Versions: manifest.Versions,
+		Offline:  h.catalog.Offline(),
+	}
+	if !manifest.UpdatedAt.IsZero() {
+		response.UpdatedAt = manifest.UpdatedAt.Format(time.RFC3339)
+	}
+
+	if serverType := r.URL.Query().Get("type"); serverType != "" {
+		serverType = catalog.NormalizeType(serverType)
+		response.Types = []string{serverType}
+		response.Versions = map[string][]string{serverType: manifest.Versions[serverType]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *CatalogHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if err := h.catalog.Refresh(r.Context()); err != nil {
+		log.Printf("Error refreshing version catalog: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Version catalog refreshed",
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+)
+
+type JobHandler struct {
+	jobs *jobs.Runner
+}
+
+func NewJobHandler(runner *jobs.Runner) *JobHandler {
+	return &JobHandler{jobs: runner}
+}
+
+func (h *JobHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/jobs", h.ListJobs).Methods("GET", "OPTIONS")
+	r.HandleFunc("/jobs/{id}", h.GetJob).Methods("GET", "OPTIONS")
+	r.HandleFunc("/jobs/{id}/cancel", h.CancelJob).Methods("POST", "OPTIONS")
+}
+
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	list, err := h.jobs.List(r.URL.Query().Get("serverId"), limit)
+	if err != nil {
+		log.Printf("Error listing jobs: %v", err)
+		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	job, err := h.jobs.Get(jobID)
+	if err != nil {
+		log.Printf("Error fetching job %s: %v", jobID, err)
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	if err := h.jobs.Cancel(jobID); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelling"})
+}
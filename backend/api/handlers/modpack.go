@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+	"github.com/mboxmini/mboxmini/backend/api/modpack"
+)
+
+// maxModpackUpload caps an uploaded .mrpack or CurseForge zip.
+const maxModpackUpload = 4 << 30
+
+// ImportModpack creates a server from a modpack uploaded as the "file" field
+// of a multipart form. The optional name, memory, storage and port fields
+// work as in CreateServerRequest; the pack decides the type and version.
+func (h *ServerHandler) ImportModpack(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxModpackUpload)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+
+	// The job outlives the request, so the upload goes to a file of our own
+	upload, err := os.CreateTemp("", "mboxmini-modpack-*.zip")
+	if err != nil {
+		log.Printf("Error creating modpack upload file: %v", err)
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			upload.Close()
+			os.Remove(upload.Name())
+		}
+	}()
+
+	var size int64
+	fields := map[string]string{}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			if size, err = io.Copy(upload, part); err != nil {
+				log.Printf("Error receiving modpack upload: %v", err)
+				http.Error(w, "Failed to read upload", http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, 4096))
+		if err != nil {
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		fields[part.FormName()] = string(value)
+	}
+	if size == 0 {
+		http.Error(w, "A modpack file is required", http.StatusBadRequest)
+		return
+	}
+
+	pack, err := modpack.Open(upload, size)
+	if err != nil {
+		log.Printf("Invalid modpack upload: %v", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	cfg := docker.ServerConfig{
+		Name:    fields["name"],
+		Memory:  fields["memory"],
+		Storage: fields["storage"],
+		OwnerID: currentUserID(r),
+	}
+	if value := fields["port"]; value != "" {
+		if cfg.Port, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid port", http.StatusBadRequest)
+			return
+		}
+	}
+	cfg = docker.ModpackConfig(cfg, pack)
+	if cfg.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.dockerManager.ValidateServerConfig(cfg); err != nil {
+		log.Printf("Invalid server config for modpack %s: %v", pack.Name, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if err := h.dockerManager.CheckModpack(pack); err != nil {
+		log.Printf("Modpack %s can't be imported: %v", pack.Name, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	job, err := h.jobs.Start("import_modpack", "", func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		defer os.Remove(upload.Name())
+		defer upload.Close()
+
+		serverID, err := h.dockerManager.ImportModpack(ctx, cfg, pack, progress)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Server %s created from modpack %s", serverID, pack.Name)
+		return h.serverResult(serverID, progress), nil
+	})
+	if err != nil {
+		log.Printf("Error starting modpack import job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keep = true
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    docker.ContainerName(cfg.Name),
+		"jobId": job.ID,
+		"modpack": map[string]interface{}{
+			"format":           pack.Format,
+			"name":             pack.Name,
+			"version":          pack.Version,
+			"minecraftVersion": pack.MinecraftVersion,
+			"loader":           pack.Loader,
+			"loaderVersion":    pack.LoaderVersion,
+			"files":            len(pack.Files),
+		},
+	})
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+)
+
+type NetworkHandler struct {
+	dockerManager *docker.Manager
+	db            *database.DB
+	jobs          *jobs.Runner
+}
+
+func NewNetworkHandler(dm *docker.Manager, db *database.DB, runner *jobs.Runner) *NetworkHandler {
+	return &NetworkHandler{
+		dockerManager: dm,
+		db:            db,
+		jobs:          runner,
+	}
+}
+
+// CreateNetworkRequest creates a proxy network. Proxy is "velocity" (the
+// default) or "bungeecord"; Port requests a specific public port.
+type CreateNetworkRequest struct {
+	Name    string `json:"name"`
+	Proxy   string `json:"proxy"`
+	Memory  string `json:"memory"`
+	Port    int    `json:"port"`
+	Storage string `json:"storage"`
+}
+
+// AddNetworkServerRequest moves a server behind the proxy. Alias is the
+// name the proxy knows it by and defaults to one derived from the server
+// name; Lobby makes it the server players join first.
+type AddNetworkServerRequest struct {
+	ServerID string `json:"serverId"`
+	Alias    string `json:"alias"`
+	Lobby    bool   `json:"lobby"`
+}
+
+func (h *NetworkHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/networks", h.ListNetworks).Methods("GET", "OPTIONS")
+	r.HandleFunc("/networks", h.CreateNetwork).Methods("POST", "OPTIONS")
+	r.HandleFunc("/networks/{id}", h.GetNetwork).Methods("GET", "OPTIONS")
+	r.HandleFunc("/networks/{id}", h.DeleteNetwork).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/networks/{id}/servers", h.AddServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/networks/{id}/servers/{serverId}", h.RemoveServer).Methods("DELETE", "OPTIONS")
+}
+
+func (h *NetworkHandler) ListNetworks(w http.ResponseWriter, r *http.Request) {
+	networks, err := h.dockerManager.ListNetworks(r.Context())
+	if err != nil {
+		log.Printf("Error listing networks: %v", err)
+		http.Error(w, "Failed to list networks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(networks)
+}
+
+func (h *NetworkHandler) CreateNetwork(w http.ResponseWriter, r *http.Request) {
+	var req CreateNetworkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cfg := docker.NetworkConfig{
+		Name:    req.Name,
+		Proxy:   req.Proxy,
+		Memory:  req.Memory,
+		Port:    req.Port,
+		Storage: req.Storage,
+		OwnerID: currentUserID(r),
+	}
+	if err := h.dockerManager.ValidateNetworkConfig(cfg); err != nil {
+		log.Printf("Invalid network config: %v", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	job, err := h.jobs.Start("create_network", "", func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		network, err := h.dockerManager.CreateNetwork(ctx, cfg, progress)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"id": network.ID, "name": network.Name}, nil
+	})
+	if err != nil {
+		log.Printf("Error starting create network job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+func (h *NetworkHandler) GetNetwork(w http.ResponseWriter, r *http.Request) {
+	if !h.networkExists(w, r) {
+		return
+	}
+
+	network, err := h.dockerManager.GetNetwork(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Error fetching network %s: %v", mux.Vars(r)["id"], err)
+		http.Error(w, "Failed to fetch network", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(network)
+}
+
+func (h *NetworkHandler) DeleteNetwork(w http.ResponseWriter, r *http.Request) {
+	if !h.networkExists(w, r) {
+		return
+	}
+
+	if err := h.dockerManager.DeleteNetwork(r.Context(), mux.Vars(r)["id"]); err != nil {
+		log.Printf("Error deleting network %s: %v", mux.Vars(r)["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *NetworkHandler) AddServer(w http.ResponseWriter, r *http.Request) {
+	if !h.networkExists(w, r) {
+		return
+	}
+
+	var req AddNetworkServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ServerID == "" {
+		http.Error(w, "serverId is required", http.StatusBadRequest)
+		return
+	}
+
+	// The server is recreated, which must not be cut short by the request
+	networkID := mux.Vars(r)["id"]
+	job, err := h.jobs.Start("add_network_server", req.ServerID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		progress.Step("move server behind proxy")
+		if err := h.dockerManager.AddNetworkServer(ctx, networkID, req.ServerID, req.Alias, req.Lobby); err != nil {
+			log.Printf("Error adding server %s to network %s: %v", req.ServerID, networkID, err)
+			return nil, err
+		}
+		return h.dockerManager.GetNetwork(ctx, networkID)
+	})
+	if err != nil {
+		log.Printf("Error starting add network server job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+func (h *NetworkHandler) RemoveServer(w http.ResponseWriter, r *http.Request) {
+	if !h.networkExists(w, r) {
+		return
+	}
+
+	networkID, serverID := mux.Vars(r)["id"], mux.Vars(r)["serverId"]
+	job, err := h.jobs.Start("remove_network_server", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		progress.Step("publish server")
+		if err := h.dockerManager.RemoveNetworkServer(ctx, networkID, serverID); err != nil {
+			log.Printf("Error removing server %s from network %s: %v", serverID, networkID, err)
+			return nil, err
+		}
+		return h.dockerManager.GetNetwork(ctx, networkID)
+	})
+	if err != nil {
+		log.Printf("Error starting remove network server job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+// networkExists writes a 404 unless the network in the path exists.
+func (h *NetworkHandler) networkExists(w http.ResponseWriter, r *http.Request) bool {
+	if _, err := h.dockerManager.ResolveNetwork(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, "Network not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
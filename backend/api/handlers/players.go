@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+)
+
+// maxPageSize caps the limit of paginated player listings.
+const maxPageSize = 500
+
+type PlayerHandler struct {
+	dockerManager *docker.Manager
+	db            *database.DB
+}
+
+func NewPlayerHandler(dm *docker.Manager, db *database.DB) *PlayerHandler {
+	return &PlayerHandler{dockerManager: dm, db: db}
+}
+
+// playerServer is a player's activity and standing on one server.
+type playerServer struct {
+	database.PlayerActivity
+	ServerName string                   `json:"serverName,omitempty"`
+	Moderation *docker.PlayerModeration `json:"moderation,omitempty"`
+}
+
+type playerDetail struct {
+	Name          string                   `json:"name"`
+	FirstSeen     time.Time                `json:"firstSeen"`
+	LastSeen      time.Time                `json:"lastSeen"`
+	Playtime      int64                    `json:"playtime"`
+	Online        bool                     `json:"online"`
+	Servers       []playerServer           `json:"servers"`
+	Sessions      []database.PlayerSession `json:"sessions"`
+	TotalSessions int                      `json:"totalSessions"`
+}
+
+func (h *PlayerHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/players", h.ListPlayers).Methods("GET", "OPTIONS")
+	r.HandleFunc("/players/{name}", h.GetPlayer).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/players/{list}", h.ListAccess).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/players/{list}", h.AddAccess).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/players/{list}/{entry}", h.RemoveAccess).Methods("DELETE", "OPTIONS")
+}
+
+// ListAccess returns the whitelist, ops, bans or ip-bans of a server.
+func (h *PlayerHandler) ListAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entries, err := h.dockerManager.ListAccess(r.Context(), vars["id"], vars["list"])
+	if err != nil {
+		log.Printf("Error listing %s of server %s: %v", vars["list"], vars["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// AddAccess adds a player, or an IP for ip-bans, and returns the updated list.
+func (h *PlayerHandler) AddAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req docker.AccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.dockerManager.AddAccess(r.Context(), vars["id"], vars["list"], req)
+	if err != nil {
+		log.Printf("Error adding to %s of server %s: %v", vars["list"], vars["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// RemoveAccess removes a player name or IP and returns the updated list.
+func (h *PlayerHandler) RemoveAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entries, err := h.dockerManager.RemoveAccess(r.Context(), vars["id"], vars["list"], vars["entry"])
+	if err != nil {
+		log.Printf("Error removing %s from %s of server %s: %v", vars["entry"], vars["list"], vars["id"], err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// page reads the limit and offset query parameters.
+func page(r *http.Request, defaultLimit int) (int, int, bool) {
+	limit, offset := defaultLimit, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxPageSize {
+			return 0, 0, false
+		}
+		limit = parsed
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return limit, offset, true
+}
+
+// ListPlayers returns every player seen on any server, most recently seen
+// first. ?search= matches part of a name; ?limit= and ?offset= page through
+// the results.
+func (h *PlayerHandler) ListPlayers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := page(r, 50)
+	if !ok {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+
+	players, total, err := h.db.ListPlayers(r.URL.Query().Get("search"), limit, offset)
+	if err != nil {
+		log.Printf("Error listing players: %v", err)
+		http.Error(w, "Failed to fetch players", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"players": players,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetPlayer returns a player's activity on each server they have been seen
+// on, with their whitelist, op and ban status there, and their session
+// history paged by ?limit= and ?offset=.
+func (h *PlayerHandler) GetPlayer(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	limit, offset, ok := page(r, 50)
+	if !ok {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+
+	activity, err := h.db.PlayerServers(name)
+	if err != nil {
+		log.Printf("Error fetching player %s: %v", name, err)
+		http.Error(w, "Failed to fetch player", http.StatusInternalServerError)
+		return
+	}
+	if len(activity) == 0 {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
+
+	detail := playerDetail{Name: activity[0].Name, FirstSeen: activity[0].FirstSeen, Servers: []playerServer{}}
+	for _, a := range activity {
+		if a.FirstSeen.Before(detail.FirstSeen) {
+			detail.FirstSeen = a.FirstSeen
+		}
+		if a.LastSeen.After(detail.LastSeen) {
+			detail.LastSeen = a.LastSeen
+		}
+		detail.Playtime += a.Playtime
+		detail.Online = detail.Online || a.Online
+
+		server := playerServer{PlayerActivity: a}
+		record, err := h.db.GetServer(a.ServerID)
+		if err != nil {
+			log.Printf("Error looking up server %s: %v", a.ServerID, err)
+		}
+		// Deleted servers keep their history but have no access lists
+		if record != nil {
+			server.ServerName = record.Name
+			if server.Moderation, err = h.dockerManager.PlayerModeration(r.Context(), a.ServerID, name); err != nil {
+				log.Printf("Error reading access lists of server %s: %v", a.ServerID, err)
+			}
+		}
+		detail.Servers = append(detail.Servers, server)
+	}
+
+	if detail.Sessions, detail.TotalSessions, err = h.db.PlayerSessions(name, limit, offset); err != nil {
+		log.Printf("Error fetching sessions of player %s: %v", name, err)
+		http.Error(w, "Failed to fetch player", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
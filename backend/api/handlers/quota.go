@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
+)
+
+type QuotaHandler struct {
+	dockerManager *docker.Manager
+	db            *database.DB
+}
+
+func NewQuotaHandler(dm *docker.Manager, db *database.DB) *QuotaHandler {
+	return &QuotaHandler{
+		dockerManager: dm,
+		db:            db,
+	}
+}
+
+// UserQuotaRequest sets a user's quota overrides. Omitted fields fall back to
+// the defaults from settings; 0 or "" means unlimited.
+type UserQuotaRequest struct {
+	MaxServers *int    `json:"maxServers"`
+	MaxMemory  *string `json:"maxMemory"`
+	MaxDisk    *string `json:"maxDisk"`
+	MaxRunning *int    `json:"maxRunning"`
+	// MaxCPUs caps the CPUs of each of the user's servers
+	MaxCPUs *float64 `json:"maxCpus"`
+}
+
+type ServerOwnerRequest struct {
+	OwnerID int64 `json:"ownerId"`
+}
+
+func (h *QuotaHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/me/quota", h.GetMyQuota).Methods("GET", "OPTIONS")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin)
+	admin.HandleFunc("/users/{id}/quota", h.GetUserQuota).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/users/{id}/quota", h.SetUserQuota).Methods("PUT", "OPTIONS")
+	admin.HandleFunc("/users/{id}/quota", h.ResetUserQuota).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc("/servers/{id}/owner", h.SetServerOwner).Methods("PUT", "OPTIONS")
+}
+
+func (h *QuotaHandler) GetMyQuota(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.dockerManager.QuotaStatus(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching quota for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *QuotaHandler) GetUserQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userID(w, r)
+	if !ok {
+		return
+	}
+
+	override, err := h.db.GetUserQuota(userID)
+	if err != nil {
+		log.Printf("Error fetching quota override for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch quota", http.StatusInternalServerError)
+		return
+	}
+	status, err := h.dockerManager.QuotaStatus(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching quota for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"override": override,
+		"limits":   status.Limits,
+		"usage":    status.Usage,
+	})
+}
+
+func (h *QuotaHandler) SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userID(w, r)
+	if !ok {
+		return
+	}
+
+	var req UserQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if (req.MaxServers != nil && *req.MaxServers < 0) || (req.MaxRunning != nil && *req.MaxRunning < 0) || (req.MaxCPUs != nil && *req.MaxCPUs < 0) {
+		http.Error(w, "Quotas must not be negative", http.StatusBadRequest)
+		return
+	}
+	for _, size := range []*string{req.MaxMemory, req.MaxDisk} {
+		if size == nil || *size == "" {
+			continue
+		}
+		if _, err := docker.ParseMemory(*size); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	quota := &database.UserQuota{
+		UserID:     userID,
+		MaxServers: req.MaxServers,
+		MaxMemory:  req.MaxMemory,
+		MaxDisk:    req.MaxDisk,
+		MaxRunning: req.MaxRunning,
+		MaxCPUs:    req.MaxCPUs,
+	}
+	if err := h.db.SetUserQuota(quota); err != nil {
+		log.Printf("Error saving quota for user %d: %v", userID, err)
+		http.Error(w, "Failed to save quota", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Updated quota for user %d", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quota)
+}
+
+func (h *QuotaHandler) ResetUserQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteUserQuota(userID); err != nil {
+		log.Printf("Error resetting quota for user %d: %v", userID, err)
+		http.Error(w, "Failed to reset quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetServerOwner assigns a server to a user, e.g. for servers created before
+// owners were recorded. An ownerId of 0 clears the owner.
+func (h *QuotaHandler) SetServerOwner(w http.ResponseWriter, r *http.Request) {
+	server, err := h.dockerManager.ResolveServer(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var req ServerOwnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.OwnerID != 0 {
+		user, err := h.db.GetUserByID(req.OwnerID)
+		if err != nil {
+			http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.db.SetServerOwner(server.ID, req.OwnerID); err == sql.ErrNoRows {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error setting owner of server %s: %v", server.ID, err)
+		http.Error(w, "Failed to set owner", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": server.ID, "ownerId": req.OwnerID})
+}
+
+// userID parses the {id} route variable and checks that the user exists.
+func (h *QuotaHandler) userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	user, err := h.db.GetUserByID(id)
+	if err != nil {
+		log.Printf("Error fetching user %d: %v", id, err)
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return 0, false
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
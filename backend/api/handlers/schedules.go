@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/schedule"
+)
+
+type ScheduleHandler struct {
+	dockerManager *docker.Manager
+	db            *database.DB
+	scheduler     *schedule.Scheduler
+}
+
+func NewScheduleHandler(dm *docker.Manager, db *database.DB, scheduler *schedule.Scheduler) *ScheduleHandler {
+	return &ScheduleHandler{dockerManager: dm, db: db, scheduler: scheduler}
+}
+
+// ScheduleRequest creates or replaces a schedule. Enabled defaults to true.
+type ScheduleRequest struct {
+	Name     string   `json:"name"`
+	Cron     string   `json:"cron"`
+	Timezone string   `json:"timezone"`
+	Action   string   `json:"action"`
+	Commands []string `json:"commands"`
+	Warning  int      `json:"warning"`
+	CatchUp  string   `json:"catchUp"`
+	Enabled  *bool    `json:"enabled"`
+}
+
+func (req ScheduleRequest) apply(s *database.Schedule) {
+	s.Name = req.Name
+	s.Cron = req.Cron
+	s.Timezone = req.Timezone
+	s.Action = req.Action
+	s.Commands = req.Commands
+	s.Warning = req.Warning
+	s.CatchUp = req.CatchUp
+	s.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+func (h *ScheduleHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/servers/{id}/schedules", h.ListSchedules).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules", h.CreateSchedule).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules/{scheduleId}", h.GetSchedule).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules/{scheduleId}", h.UpdateSchedule).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules/{scheduleId}", h.DeleteSchedule).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules/{scheduleId}/runs", h.ListRuns).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/schedules/{scheduleId}/run", h.RunSchedule).Methods("POST", "OPTIONS")
+}
+
+// findSchedule looks up the schedule in the URL, writing an error response
+// if the server or schedule doesn't exist.
+func (h *ScheduleHandler) findSchedule(w http.ResponseWriter, r *http.Request) *database.Schedule {
+	vars := mux.Vars(r)
+	server, err := h.dockerManager.ResolveServer(r.Context(), vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	id, err := strconv.ParseInt(vars["scheduleId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return nil
+	}
+	s, err := h.db.GetSchedule(id)
+	if err != nil {
+		log.Printf("Error fetching schedule %d: %v", id, err)
+		http.Error(w, "Failed to fetch schedule", http.StatusInternalServerError)
+		return nil
+	}
+	if s == nil || s.ServerID != server.ID {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return nil
+	}
+	return s
+}
+
+func (h *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	server, err := h.dockerManager.ResolveServer(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	schedules, err := h.db.ListSchedules(server.ID)
+	if err != nil {
+		log.Printf("Error listing schedules of server %s: %v", server.ID, err)
+		http.Error(w, "Failed to fetch schedules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// CreateSchedule adds a restart, stop, start, backup or command schedule to
+// a server.
+func (h *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	server, err := h.dockerManager.ResolveServer(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	s := &database.Schedule{ServerID: server.ID, CreatedBy: currentUserID(r)}
+	req.apply(s)
+	if err := schedule.Prepare(s); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if err := h.db.CreateSchedule(s); err != nil {
+		log.Printf("Error creating schedule for server %s: %v", server.ID, err)
+		http.Error(w, "Failed to create schedule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created schedule %d (%s at %q %s) for server %s", s.ID, s.Action, s.Cron, s.Timezone, server.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	s := h.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// UpdateSchedule replaces a schedule's settings. Its next run is worked out
+// again from the new cron expression.
+func (h *ScheduleHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	s := h.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.apply(s)
+	if err := schedule.Prepare(s); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if err := h.db.UpdateSchedule(s); err != nil {
+		log.Printf("Error updating schedule %d: %v", s.ID, err)
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	s := h.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+
+	if err := h.db.DeleteSchedule(s.ID); err != nil {
+		log.Printf("Error deleting schedule %d: %v", s.ID, err)
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRuns returns a schedule's run history, newest first, up to ?limit=.
+func (h *ScheduleHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	s := h.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := h.db.ListScheduleRuns(s.ID, limit)
+	if err != nil {
+		log.Printf("Error listing runs of schedule %d: %v", s.ID, err)
+		http.Error(w, "Failed to fetch schedule runs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// RunSchedule runs a schedule now. The run continues in the background and
+// shows up in the run history.
+func (h *ScheduleHandler) RunSchedule(w http.ResponseWriter, r *http.Request) {
+	s := h.findSchedule(w, r)
+	if s == nil {
+		return
+	}
+
+	run, err := h.scheduler.RunNow(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
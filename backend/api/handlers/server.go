@@ -1,26 +1,50 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+	"github.com/mboxmini/mboxmini/backend/api/database"
 	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
 )
 
 type ServerHandler struct {
 	dockerManager *docker.Manager
+	db            *database.DB
+	jobs          *jobs.Runner
 }
 
+// CreateServerRequest creates a server from scratch or, when TemplateID is
+// set, from a template whose fields are overridden by any non-empty field here.
 type CreateServerRequest struct {
-	Name           string `json:"name"`
-	Version        string `json:"version"`
-	Memory         string `json:"memory,omitempty"`
-	Type           string `json:"type,omitempty"`
-	PauseWhenEmpty int    `json:"pauseWhenEmpty,omitempty"`
-	ViewDistance   int    `json:"viewDistance,omitempty"`
+	Name           string                `json:"name"`
+	Edition        string                `json:"edition,omitempty"`
+	TemplateID     *int64                `json:"templateId,omitempty"`
+	Version        string                `json:"version"`
+	Memory         string                `json:"memory,omitempty"`
+	Type           string                `json:"type,omitempty"`
+	PauseWhenEmpty int                   `json:"pauseWhenEmpty,omitempty"`
+	ViewDistance   int                   `json:"viewDistance,omitempty"`
+	Env            map[string]string     `json:"env,omitempty"`
+	Properties     map[string]string     `json:"properties,omitempty"`
+	Datapacks      []string              `json:"datapacks,omitempty"`
+	Plugins        []string              `json:"plugins,omitempty"`
+	Storage        string                `json:"storage,omitempty"`
+	Resources      docker.Resources      `json:"resources,omitempty"`
+	Port           int                   `json:"port,omitempty"`
+	Crossplay      bool                  `json:"crossplay,omitempty"`
+	RestartPolicy  *docker.RestartPolicy `json:"restartPolicy,omitempty"`
 }
 
 type ServerResponse struct {
@@ -39,19 +63,57 @@ type DeleteServerRequest struct {
 	RemoveFiles bool `json:"remove_files"`
 }
 
-func NewServerHandler(dm *docker.Manager) *ServerHandler {
+// UpdateServerRequest edits an existing server. Fields left nil are
+// unchanged. Toggling Crossplay recreates the container, restarting it if it
+// was running, so it runs as a job and the response is the job; the other
+// changes apply straight away without a restart.
+type UpdateServerRequest struct {
+	Name          *string               `json:"name,omitempty"`
+	Resources     *docker.Resources     `json:"resources,omitempty"`
+	Crossplay     *bool                 `json:"crossplay,omitempty"`
+	RestartPolicy *docker.RestartPolicy `json:"restartPolicy,omitempty"`
+}
+
+type UpgradeServerRequest struct {
+	Version        string `json:"version"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+type BackupRequest struct {
+	Label string `json:"label,omitempty"`
+}
+
+type CloneServerRequest struct {
+	Name  string `json:"name"`
+	Start bool   `json:"start,omitempty"`
+}
+
+func NewServerHandler(dm *docker.Manager, db *database.DB, runner *jobs.Runner) *ServerHandler {
 	return &ServerHandler{
 		dockerManager: dm,
+		db:            db,
+		jobs:          runner,
 	}
 }
 
 func (h *ServerHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/servers", h.ListServers).Methods("GET", "OPTIONS")
 	r.HandleFunc("/servers", h.CreateServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/import", h.ImportModpack).Methods("POST", "OPTIONS")
 	r.HandleFunc("/servers/{id}", h.GetServerStatus).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}", h.UpdateServer).Methods("PATCH", "OPTIONS")
 	r.HandleFunc("/servers/{id}", h.DeleteServer).Methods("DELETE", "OPTIONS")
 	r.HandleFunc("/servers/{id}/start", h.StartServer).Methods("POST", "OPTIONS")
 	r.HandleFunc("/servers/{id}/stop", h.StopServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/restart", h.RestartServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/clone", h.CloneServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/world", h.ImportWorld).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/world/download", h.DownloadWorld).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/backups", h.ListBackups).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/backups", h.CreateBackup).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/upgrade", h.UpgradeServer).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/upgrades", h.ListUpgrades).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/upgrades/{upgradeId}/rollback", h.RollbackUpgrade).Methods("POST", "OPTIONS")
 	r.HandleFunc("/servers/{id}/command", h.ExecuteCommand).Methods("POST", "OPTIONS")
 	r.HandleFunc("/servers/{id}/players", h.GetPlayers).Methods("GET", "OPTIONS")
 }
@@ -85,28 +147,192 @@ func (h *ServerHandler) CreateServer(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Decoded request: %+v", req)
 
-	if req.Name == "" || req.Version == "" {
+	cfg := docker.ServerConfig{
+		Name:           req.Name,
+		Edition:        req.Edition,
+		Version:        req.Version,
+		Memory:         req.Memory,
+		Type:           req.Type,
+		PauseWhenEmpty: req.PauseWhenEmpty,
+		ViewDistance:   req.ViewDistance,
+		Env:            req.Env,
+		Properties:     req.Properties,
+		Datapacks:      req.Datapacks,
+		Plugins:        req.Plugins,
+		Storage:        req.Storage,
+		Resources:      req.Resources,
+		Port:           req.Port,
+		Crossplay:      req.Crossplay,
+		RestartPolicy:  req.RestartPolicy,
+		OwnerID:        currentUserID(r),
+	}
+
+	if req.TemplateID != nil {
+		template, err := h.db.GetTemplate(*req.TemplateID)
+		if err != nil {
+			log.Printf("Error fetching template %d: %v", *req.TemplateID, err)
+			http.Error(w, "Failed to fetch template", http.StatusInternalServerError)
+			return
+		}
+		if template == nil {
+			http.Error(w, "Template not found", http.StatusBadRequest)
+			return
+		}
+		cfg = applyTemplate(template, cfg)
+		log.Printf("Applied template %q: %+v", template.Name, cfg)
+	}
+
+	// Bedrock servers default to the latest release
+	if cfg.Name == "" || (cfg.Version == "" && cfg.Edition != docker.EditionBedrock) {
 		log.Printf("Invalid request: name or version is empty")
 		http.Error(w, "Name and version are required", http.StatusBadRequest)
 		return
 	}
 
-	serverID, err := h.dockerManager.CreateServer(
-		req.Name,
-		req.Version,
-		req.Memory,
-		req.Type,
-		req.PauseWhenEmpty,
-		req.ViewDistance,
-	)
+	if err := h.dockerManager.ValidateServerConfig(cfg); err != nil {
+		log.Printf("Invalid server config: %v", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	job, err := h.jobs.Start("create_server", "", func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		serverID, err := h.dockerManager.CreateServer(ctx, cfg, progress)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Server created successfully with ID: %s", serverID)
+		return h.serverResult(serverID, progress), nil
+	})
 	if err != nil {
-		log.Printf("Error creating server: %v", err)
+		log.Printf("Error starting create job: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Server created successfully with ID: %s", serverID)
 
-	json.NewEncoder(w).Encode(map[string]string{"id": serverID})
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":    docker.ContainerName(cfg.Name),
+		"jobId": job.ID,
+	})
+}
+
+// serverResult is the job result for operations that create a server. It
+// also links the job to the new server.
+func (h *ServerHandler) serverResult(containerName string, progress *jobs.Progress) map[string]string {
+	result := map[string]string{"id": containerName}
+	if record, err := h.db.GetServerByContainerName(containerName); err == nil && record != nil {
+		result["uuid"] = record.ID
+		progress.SetServer(record.ID)
+	}
+	return result
+}
+
+// errorStatus maps validation errors to 400, quota errors to 403, missing
+// addons, worlds and access list entries to 404, port conflicts to 409 and
+// everything else to 500.
+func errorStatus(err error) int {
+	var validationErr *catalog.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusBadRequest
+	}
+	var quotaErr *docker.QuotaError
+	if errors.As(err, &quotaErr) {
+		return http.StatusForbidden
+	}
+	var addonErr *docker.AddonNotFoundError
+	if errors.As(err, &addonErr) {
+		return http.StatusNotFound
+	}
+	var worldErr *docker.WorldNotFoundError
+	if errors.As(err, &worldErr) {
+		return http.StatusNotFound
+	}
+	var accessErr *docker.AccessEntryNotFoundError
+	if errors.As(err, &accessErr) {
+		return http.StatusNotFound
+	}
+	var upgradeErr *docker.UpgradeNotFoundError
+	if errors.As(err, &upgradeErr) {
+		return http.StatusNotFound
+	}
+	var portErr *docker.PortConflictError
+	if errors.As(err, &portErr) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// currentUserID returns the authenticated user, or 0 if there is none.
+func currentUserID(r *http.Request) int64 {
+	if user := middleware.GetUserFromContext(r.Context()); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// checkStartQuota checks that starting a stopped server fits in its owner's
+// running server quota.
+func (h *ServerHandler) checkStartQuota(ctx context.Context, serverID string) error {
+	status, err := h.dockerManager.GetServerStatus(serverID)
+	if err != nil || status.Status == "running" {
+		return err
+	}
+	ownerID, err := h.dockerManager.ServerOwner(ctx, serverID)
+	if err != nil || ownerID == 0 {
+		return err
+	}
+	return h.dockerManager.CheckQuota(ctx, ownerID, docker.QuotaRequest{Running: 1})
+}
+
+// startWithQuota runs start, which starts the server if it is stopped, under
+// its owner's quota lock once the running server quota has been checked.
+func (h *ServerHandler) startWithQuota(ctx context.Context, serverID string, start func() error) error {
+	status, err := h.dockerManager.GetServerStatus(serverID)
+	if err != nil {
+		return err
+	}
+	if status.Status == "running" {
+		return start()
+	}
+	ownerID, err := h.dockerManager.ServerOwner(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	return h.dockerManager.WithQuota(ctx, ownerID, docker.QuotaRequest{Running: 1}, start)
+}
+
+// applyTemplate fills the fields of cfg that were left empty from the template.
+// Env and Properties are merged key by key with cfg taking precedence.
+func applyTemplate(template *database.ServerTemplate, cfg docker.ServerConfig) docker.ServerConfig {
+	if cfg.Version == "" {
+		cfg.Version = template.Version
+	}
+	if cfg.Memory == "" {
+		cfg.Memory = template.Memory
+	}
+	if cfg.Type == "" {
+		cfg.Type = template.Type
+	}
+	if cfg.Datapacks == nil {
+		cfg.Datapacks = template.Datapacks
+	}
+	if cfg.Plugins == nil {
+		cfg.Plugins = template.Plugins
+	}
+	cfg.Env = mergeStringMaps(template.Env, cfg.Env)
+	cfg.Properties = mergeStringMaps(template.Properties, cfg.Properties)
+	return cfg
+}
+
+func mergeStringMaps(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
 }
 
 func (h *ServerHandler) GetServerStatus(w http.ResponseWriter, r *http.Request) {
@@ -125,18 +351,112 @@ func (h *ServerHandler) GetServerStatus(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(status)
 }
 
-func (h *ServerHandler) StartServer(w http.ResponseWriter, r *http.Request) {
+func (h *ServerHandler) UpdateServer(w http.ResponseWriter, r *http.Request) {
 	serverID := mux.Vars(r)["id"]
 	if serverID == "" {
 		http.Error(w, "Server ID is required", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.dockerManager.StartServer(serverID); err != nil {
+	var req UpdateServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Nothing is changed unless every field can be applied
+	if req.Name != nil {
+		if err := h.dockerManager.ValidateRename(r.Context(), serverID, *req.Name); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	if req.Resources != nil {
+		if err := h.dockerManager.ValidateResources(r.Context(), serverID, *req.Resources); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	if req.RestartPolicy != nil {
+		if err := req.RestartPolicy.Validate(); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	if req.Crossplay != nil {
+		if err := h.dockerManager.ValidateCrossplay(r.Context(), serverID, *req.Crossplay); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	if req.Name != nil {
+		if err := h.dockerManager.RenameServer(r.Context(), serverID, *req.Name); err != nil {
+			log.Printf("Error renaming server %s: %v", serverID, err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	if req.Resources != nil {
+		if _, err := h.dockerManager.UpdateResources(r.Context(), serverID, *req.Resources); err != nil {
+			log.Printf("Error updating resources of server %s: %v", serverID, err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	if req.RestartPolicy != nil {
+		if _, err := h.dockerManager.SetRestartPolicy(r.Context(), serverID, *req.RestartPolicy); err != nil {
+			log.Printf("Error changing restart policy of server %s: %v", serverID, err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	if req.Crossplay != nil {
+		crossplay := *req.Crossplay
+		job, err := h.jobs.Start("set_crossplay", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+			progress.Step("recreate container")
+			if err := h.dockerManager.SetCrossplay(ctx, serverID, crossplay); err != nil {
+				log.Printf("Error changing crossplay of server %s: %v", serverID, err)
+				return nil, err
+			}
+			return h.dockerManager.GetServerStatus(serverID)
+		})
+		if err != nil {
+			log.Printf("Error starting crossplay job: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+		return
+	}
+
+	status, err := h.dockerManager.GetServerStatus(serverID)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *ServerHandler) StartServer(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.startWithQuota(r.Context(), serverID, func() error {
+		return h.dockerManager.StartServer(serverID)
+	}); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
 	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
 }
 
@@ -147,12 +467,356 @@ func (h *ServerHandler) StopServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.dockerManager.StopServer(serverID); err != nil {
+	opts, ok := decodeStopOptions(w, r)
+	if !ok {
+		return
+	}
+
+	// A countdown can take a while, so it runs as a job
+	if opts.Warning > 0 {
+		job, err := h.jobs.Start("stop_server", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+			return h.dockerManager.GracefulStop(ctx, serverID, opts, progress)
+		})
+		if err != nil {
+			log.Printf("Error starting stop job: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+		return
+	}
+
+	result, err := h.dockerManager.GracefulStop(context.Background(), serverID, opts, nil)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "stopped",
+		"phases": result.Phases,
+		"forced": result.Forced,
+	})
+}
+
+// RestartServer stops a server gracefully, like StopServer, and starts it
+// again. A stopped server is just started.
+func (h *ServerHandler) RestartServer(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	opts, ok := decodeStopOptions(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.checkStartQuota(r.Context(), serverID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if opts.Warning > 0 {
+		job, err := h.jobs.Start("restart_server", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+			var result *docker.StopResult
+			err := h.startWithQuota(ctx, serverID, func() (err error) {
+				result, err = h.dockerManager.RestartServer(ctx, serverID, opts, progress)
+				return err
+			})
+			return result, err
+		})
+		if err != nil {
+			log.Printf("Error starting restart job: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+		return
+	}
+
+	var result *docker.StopResult
+	if err := h.startWithQuota(r.Context(), serverID, func() (err error) {
+		result, err = h.dockerManager.RestartServer(context.Background(), serverID, opts, nil)
+		return err
+	}); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "restarted",
+		"phases": result.Phases,
+		"forced": result.Forced,
+	})
+}
+
+// decodeStopOptions reads the optional stop options in the body of a stop or
+// restart request, writing an error response if they are invalid.
+func decodeStopOptions(w http.ResponseWriter, r *http.Request) (docker.StopOptions, bool) {
+	var opts docker.StopOptions
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return opts, false
+		}
+	}
+
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return opts, false
+	}
+	return opts, true
+}
+
+func (h *ServerHandler) CloneServer(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req CloneServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dockerManager.ValidateClone(r.Context(), serverID, req.Name); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// The clone counts against the user cloning it
+	ownerID := currentUserID(r)
+	if ownerID != 0 {
+		usage, err := h.dockerManager.ServerUsage(r.Context(), serverID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		usage.Servers = 1
+		if req.Start {
+			usage.Running = 1
+		}
+		if err := h.dockerManager.CheckQuota(r.Context(), ownerID, usage); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	job, err := h.jobs.Start("clone_server", "", func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		cloneID, err := h.dockerManager.CloneServer(ctx, serverID, req.Name, ownerID, req.Start, progress)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Server %s cloned successfully as %s", serverID, cloneID)
+		return h.serverResult(cloneID, progress), nil
+	})
+	if err != nil {
+		log.Printf("Error starting clone job: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"status": "stopped"})
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":    docker.ContainerName(req.Name),
+		"jobId": job.ID,
+	})
+}
+
+func (h *ServerHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	backups, err := h.dockerManager.ListBackups(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(backups)
+}
+
+func (h *ServerHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req BackupRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := docker.ValidateBackupLabel(req.Label); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	server, err := h.dockerManager.ResolveServer(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if server.OwnerID != 0 {
+		usage, err := h.dockerManager.ServerUsage(r.Context(), server.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.dockerManager.CheckQuota(r.Context(), server.OwnerID, docker.QuotaRequest{DiskBytes: usage.DiskBytes}); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	job, err := h.jobs.Start("backup_server", server.ID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		// The server may have grown since the check above
+		usage, err := h.dockerManager.ServerUsage(ctx, server.ID)
+		if err != nil {
+			return nil, err
+		}
+		var backup *docker.Backup
+		err = h.dockerManager.WithQuota(ctx, server.OwnerID, docker.QuotaRequest{DiskBytes: usage.DiskBytes}, func() (err error) {
+			backup, err = h.dockerManager.BackupServer(ctx, server.ID, req.Label, progress)
+			return err
+		})
+		return backup, err
+	})
+	if err != nil {
+		log.Printf("Error starting backup job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+func (h *ServerHandler) UpgradeServer(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req UpgradeServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Version == "" {
+		http.Error(w, "Version is required", http.StatusBadRequest)
+		return
+	}
+
+	upgrade, err := h.dockerManager.PrepareUpgrade(r.Context(), serverID, req.Version)
+	if err != nil {
+		log.Printf("Error upgrading server %s: %v", serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	timeout := time.Duration(req.TimeoutSeconds) * time.Second
+	job, err := h.jobs.Start("upgrade_server", upgrade.ServerID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		if err := h.dockerManager.RunUpgrade(ctx, upgrade, timeout, progress); err != nil {
+			return nil, err
+		}
+		return upgrade, nil
+	})
+	if err != nil {
+		log.Printf("Error starting upgrade job: %v", err)
+		// Nothing will run the recorded upgrade
+		upgrade.Status = database.UpgradeFailed
+		upgrade.Error = fmt.Sprintf("failed to start upgrade job: %v", err)
+		if err := h.db.UpdateUpgrade(upgrade); err != nil {
+			log.Printf("Error marking upgrade %d failed: %v", upgrade.ID, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Upgrade %d of server %s to %s started", upgrade.ID, serverID, req.Version)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upgrade": upgrade,
+		"jobId":   job.ID,
+	})
+}
+
+func (h *ServerHandler) ListUpgrades(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	upgrades, err := h.dockerManager.ListUpgrades(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(upgrades)
+}
+
+func (h *ServerHandler) RollbackUpgrade(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+	if serverID == "" {
+		http.Error(w, "Server ID is required", http.StatusBadRequest)
+		return
+	}
+
+	upgradeID, err := strconv.ParseInt(vars["upgradeId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upgrade ID", http.StatusBadRequest)
+		return
+	}
+
+	upgrade, err := h.dockerManager.PrepareRollback(r.Context(), serverID, upgradeID)
+	if err != nil {
+		log.Printf("Error rolling back upgrade %d of server %s: %v", upgradeID, serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	job, err := h.jobs.Start("rollback_upgrade", upgrade.ServerID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		if err := h.dockerManager.RollbackUpgrade(ctx, upgrade, progress); err != nil {
+			return nil, err
+		}
+		return upgrade, nil
+	})
+	if err != nil {
+		log.Printf("Error starting rollback job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Rollback of upgrade %d of server %s started", upgradeID, serverID)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upgrade": upgrade,
+		"jobId":   job.ID,
+	})
 }
 
 func (h *ServerHandler) ExecuteCommand(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
+)
+
+type SettingsHandler struct {
+	db *database.DB
+}
+
+func NewSettingsHandler(db *database.DB) *SettingsHandler {
+	return &SettingsHandler{db: db}
+}
+
+func (h *SettingsHandler) RegisterRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin)
+	admin.HandleFunc("/settings", h.ListSettings).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/settings", h.UpdateSettings).Methods("PUT", "OPTIONS")
+	admin.HandleFunc("/settings/history", h.ListHistory).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/settings/{key}", h.GetSetting).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/settings/{key}/reset", h.ResetSetting).Methods("POST", "OPTIONS")
+}
+
+func (h *SettingsHandler) ListSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.db.ListSettingValues()
+	if err != nil {
+		log.Printf("Error listing settings: %v", err)
+		http.Error(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *SettingsHandler) GetSetting(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if _, ok := database.LookupSetting(key); !ok {
+		http.Error(w, "Unknown setting", http.StatusNotFound)
+		return
+	}
+
+	setting, err := h.db.GetSettingValueOf(key)
+	if err != nil {
+		log.Printf("Error fetching setting %s: %v", key, err)
+		http.Error(w, "Failed to fetch setting", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(setting)
+}
+
+// UpdateSettings takes an object of setting keys to new values. All values
+// are validated before any is stored.
+func (h *SettingsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var values map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(values) == 0 {
+		http.Error(w, "No settings given", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.ValidateSettings(values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.UpdateSettings(values, currentUserID(r)); err != nil {
+		log.Printf("Error updating settings: %v", err)
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Settings updated: %v", values)
+
+	h.ListSettings(w, r)
+}
+
+func (h *SettingsHandler) ResetSetting(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if _, ok := database.LookupSetting(key); !ok {
+		http.Error(w, "Unknown setting", http.StatusNotFound)
+		return
+	}
+
+	if err := h.db.ResetSetting(key, currentUserID(r)); err != nil {
+		log.Printf("Error resetting setting %s: %v", key, err)
+		http.Error(w, "Failed to reset setting", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Setting %s reset to default", key)
+
+	h.GetSetting(w, r)
+}
+
+// ListHistory returns recent changes, optionally filtered by ?key=.
+func (h *SettingsHandler) ListHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	history, err := h.db.ListSettingHistory(r.URL.Query().Get("key"), limit)
+	if err != nil {
+		log.Printf("Error listing settings history: %v", err)
+		http.Error(w, "Failed to fetch settings history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
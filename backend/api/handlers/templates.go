@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/middleware"
+)
+
+type TemplateHandler struct {
+	db *database.DB
+}
+
+type TemplateRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Type        string            `json:"type"`
+	Version     string            `json:"version"`
+	Memory      string            `json:"memory"`
+	Env         map[string]string `json:"env"`
+	Properties  map[string]string `json:"properties"`
+	Datapacks   []string          `json:"datapacks"`
+	Plugins     []string          `json:"plugins"`
+}
+
+func NewTemplateHandler(db *database.DB) *TemplateHandler {
+	return &TemplateHandler{db: db}
+}
+
+func (h *TemplateHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/templates", h.ListTemplates).Methods("GET", "OPTIONS")
+	r.HandleFunc("/templates/{id}", h.GetTemplate).Methods("GET", "OPTIONS")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin)
+	admin.HandleFunc("/templates", h.CreateTemplate).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/templates/{id}", h.UpdateTemplate).Methods("PUT", "OPTIONS")
+	admin.HandleFunc("/templates/{id}", h.DeleteTemplate).Methods("DELETE", "OPTIONS")
+}
+
+func (h *TemplateHandler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.db.ListTemplates()
+	if err != nil {
+		log.Printf("Error listing templates: %v", err)
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+func (h *TemplateHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	template, err := h.db.GetTemplate(id)
+	if err != nil {
+		log.Printf("Error fetching template %d: %v", id, err)
+		http.Error(w, "Failed to fetch template", http.StatusInternalServerError)
+		return
+	}
+	if template == nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TemplateHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	template := req.toTemplate()
+	if template.Name == "" {
+		http.Error(w, "Template name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.CreateTemplate(template); err != nil {
+		log.Printf("Error creating template: %v", err)
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TemplateHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	var req TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	template := req.toTemplate()
+	template.ID = id
+	if template.Name == "" {
+		http.Error(w, "Template name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.UpdateTemplate(template); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error updating template %d: %v", id, err)
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TemplateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteTemplate(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Template not found or built-in", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting template %d: %v", id, err)
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Template deleted successfully",
+	})
+}
+
+func (req TemplateRequest) toTemplate() *database.ServerTemplate {
+	template := &database.ServerTemplate{
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Type:        strings.ToUpper(req.Type),
+		Version:     req.Version,
+		Memory:      req.Memory,
+		Env:         req.Env,
+		Properties:  req.Properties,
+		Datapacks:   req.Datapacks,
+		Plugins:     req.Plugins,
+	}
+	if template.Type == "" {
+		template.Type = "VANILLA"
+	}
+	if template.Version == "" {
+		template.Version = "LATEST"
+	}
+	if template.Env == nil {
+		template.Env = map[string]string{}
+	}
+	if template.Properties == nil {
+		template.Properties = map[string]string{}
+	}
+	if template.Datapacks == nil {
+		template.Datapacks = []string{}
+	}
+	if template.Plugins == nil {
+		template.Plugins = []string{}
+	}
+	return template
+}
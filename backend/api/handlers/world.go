@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+	"github.com/mboxmini/mboxmini/backend/api/world"
+)
+
+// ImportWorld installs a world uploaded as the "file" field of a multipart
+// form into a stopped server. The zip or tar may hold a world folder or a
+// server directory; the optional levelName field names the installed world
+// and replace=true overwrites a world of the same name.
+func (h *ServerHandler) ImportWorld(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	server, err := h.dockerManager.ResolveServer(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	maxSize := h.dockerManager.MaxWorldSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+
+	// The job outlives the request, so the upload goes to a file of our own
+	upload, err := os.CreateTemp("", "mboxmini-world-*")
+	if err != nil {
+		log.Printf("Error creating world upload file: %v", err)
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			upload.Close()
+			os.Remove(upload.Name())
+		}
+	}()
+
+	var size int64
+	fields := map[string]string{}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			if size, err = io.Copy(upload, part); err != nil {
+				log.Printf("Error receiving world upload: %v", err)
+				http.Error(w, "Failed to read upload", http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, 4096))
+		if err != nil {
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		fields[part.FormName()] = string(value)
+	}
+	if size == 0 {
+		http.Error(w, "A world archive is required", http.StatusBadRequest)
+		return
+	}
+
+	found, err := world.Open(upload, size, maxSize)
+	if err != nil {
+		log.Printf("Invalid world upload for %s: %v", server.ID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	opts := docker.WorldImport{
+		LevelName: fields["levelName"],
+		Replace:   fields["replace"] == "true",
+	}
+	levelName, err := h.dockerManager.CheckWorldImport(r.Context(), server.ID, found, opts)
+	if err != nil {
+		log.Printf("World can't be imported into %s: %v", server.ID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if server.OwnerID != 0 {
+		if err := h.dockerManager.CheckQuota(r.Context(), server.OwnerID, docker.QuotaRequest{DiskBytes: found.Size}); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	job, err := h.jobs.Start("import_world", server.ID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		defer os.Remove(upload.Name())
+		defer upload.Close()
+		var imported *docker.ImportedWorld
+		err := h.dockerManager.WithQuota(ctx, server.OwnerID, docker.QuotaRequest{DiskBytes: found.Size}, func() (err error) {
+			imported, err = h.dockerManager.ImportWorld(ctx, server.ID, found, opts, progress)
+			return err
+		})
+		return imported, err
+	})
+	if err != nil {
+		log.Printf("Error starting world import job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keep = true
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobId":     job.ID,
+		"levelName": levelName,
+		"world":     found,
+	})
+}
+
+// countingWriter counts what has been written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// DownloadWorld streams the server's world as a zip that can be dropped into
+// a singleplayer saves folder. Every download is recorded in the audit log.
+func (h *ServerHandler) DownloadWorld(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	server, err := h.dockerManager.ResolveServer(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	export, err := h.dockerManager.ExportWorld(r.Context(), server.ID)
+	if err != nil {
+		log.Printf("Error exporting world of %s: %v", server.ID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": server.Name + "-" + export.LevelName + ".zip",
+	}))
+	out := &countingWriter{w: w}
+	err = h.dockerManager.WriteWorld(r.Context(), export, out)
+
+	details := map[string]interface{}{
+		"levelName": export.LevelName,
+		"files":     export.Files,
+		"bytes":     export.Bytes,
+		"completed": err == nil,
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	if auditErr := h.db.RecordAudit(&database.AuditEntry{
+		Action:     database.AuditWorldDownload,
+		UserID:     currentUserID(r),
+		ServerID:   server.ID,
+		Details:    details,
+		RemoteAddr: r.RemoteAddr,
+	}); auditErr != nil {
+		log.Printf("Error recording world download of %s: %v", server.ID, auditErr)
+	}
+
+	if err != nil {
+		log.Printf("Error streaming world of %s: %v", server.ID, err)
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		// The zip is already partly sent; drop the connection so the client
+		// doesn't take a truncated archive for a complete one
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
+)
+
+type WorldHandler struct {
+	dockerManager *docker.Manager
+	jobs          *jobs.Runner
+}
+
+func NewWorldHandler(dm *docker.Manager, runner *jobs.Runner) *WorldHandler {
+	return &WorldHandler{dockerManager: dm, jobs: runner}
+}
+
+func (h *WorldHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/servers/{id}/worlds", h.ListWorlds).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/worlds", h.CreateWorld).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/worlds/archives", h.ListArchives).Methods("GET", "OPTIONS")
+	r.HandleFunc("/servers/{id}/worlds/{name}/activate", h.ActivateWorld).Methods("POST", "OPTIONS")
+	r.HandleFunc("/servers/{id}/worlds/{name}/archive", h.ArchiveWorld).Methods("POST", "OPTIONS")
+}
+
+func (h *WorldHandler) ListWorlds(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	worlds, err := h.dockerManager.ListWorlds(r.Context(), serverID)
+	if err != nil {
+		log.Printf("Error listing worlds of server %s: %v", serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(worlds)
+}
+
+// CreateWorld makes a new world, generated from the optional seed and
+// levelType, the server's active world. The server is recreated, so this
+// runs as a job.
+func (h *WorldHandler) CreateWorld(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	var req docker.NewWorld
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.jobs.Start("create_world", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		progress.Step("create world")
+		info, err := h.dockerManager.CreateWorld(ctx, serverID, req)
+		if err != nil {
+			log.Printf("Error creating world %s on server %s: %v", req.Name, serverID, err)
+			return nil, err
+		}
+		return info, nil
+	})
+	if err != nil {
+		log.Printf("Error starting create world job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+// ActivateWorld switches the server to another of its worlds as a job,
+// restarting it if it is running.
+func (h *WorldHandler) ActivateWorld(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID, name := vars["id"], vars["name"]
+	job, err := h.jobs.Start("activate_world", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		progress.Step("switch world")
+		info, err := h.dockerManager.SetActiveWorld(ctx, serverID, name)
+		if err != nil {
+			log.Printf("Error activating world %s on server %s: %v", name, serverID, err)
+			return nil, err
+		}
+		return info, nil
+	})
+	if err != nil {
+		log.Printf("Error starting activate world job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
+
+func (h *WorldHandler) ListArchives(w http.ResponseWriter, r *http.Request) {
+	serverID := mux.Vars(r)["id"]
+	archives, err := h.dockerManager.ListWorldArchives(r.Context(), serverID)
+	if err != nil {
+		log.Printf("Error listing world archives of server %s: %v", serverID, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archives)
+}
+
+// ArchiveWorld moves an inactive world into backup storage as a job.
+func (h *WorldHandler) ArchiveWorld(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID, name := vars["id"], vars["name"]
+
+	worlds, err := h.dockerManager.ListWorlds(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	var found *docker.WorldInfo
+	for i := range worlds {
+		if worlds[i].Name == name && !worlds[i].Pending {
+			found = &worlds[i]
+		}
+	}
+	if found == nil {
+		http.Error(w, "World not found", http.StatusNotFound)
+		return
+	}
+	if found.Active {
+		http.Error(w, "Switch to another world before archiving the active one", http.StatusBadRequest)
+		return
+	}
+
+	// The archive is written before the world is removed
+	ownerID, err := h.dockerManager.ServerOwner(r.Context(), serverID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ownerID != 0 {
+		if err := h.dockerManager.CheckQuota(r.Context(), ownerID, docker.QuotaRequest{DiskBytes: found.Size}); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	job, err := h.jobs.Start("archive_world", serverID, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		var archived *docker.WorldArchive
+		err := h.dockerManager.WithQuota(ctx, ownerID, docker.QuotaRequest{DiskBytes: found.Size}, func() (err error) {
+			archived, err = h.dockerManager.ArchiveWorld(ctx, serverID, name, progress)
+			return err
+		})
+		return archived, err
+	})
+	if err != nil {
+		log.Printf("Error starting world archive job: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": job.ID})
+}
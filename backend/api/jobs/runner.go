@@ -0,0 +1,250 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mboxmini/mboxmini/backend/api/database"
+)
+
+// Func is the body of a job. Its result is stored as JSON on success.
+type Func func(ctx context.Context, progress *Progress) (interface{}, error)
+
+// saveInterval is how often message-only progress updates are persisted.
+// Running jobs are read from memory, so polling still sees every update.
+const saveInterval = time.Second
+
+// Runner runs jobs in the background and persists their state so it can be
+// polled through the API. Jobs are cancelled through their context.
+type Runner struct {
+	db      *database.DB
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	running map[string]*Progress
+}
+
+func NewRunner(db *database.DB) (*Runner, error) {
+	// Anything still running belonged to a previous process
+	if err := db.FailInterruptedJobs(); err != nil {
+		return nil, fmt.Errorf("failed to recover interrupted jobs: %v", err)
+	}
+
+	return &Runner{
+		db:      db,
+		cancels: make(map[string]context.CancelFunc),
+		running: make(map[string]*Progress),
+	}, nil
+}
+
+// Start records a new job and runs fn in a goroutine.
+func (r *Runner) Start(kind, serverID string, fn Func) (*database.Job, error) {
+	id, err := database.NewJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &database.Job{
+		ID:       id,
+		Kind:     kind,
+		ServerID: serverID,
+		Status:   database.JobPending,
+	}
+	if err := r.db.CreateJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := &Progress{db: r.db, job: job}
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.running[id] = progress
+	r.mu.Unlock()
+
+	snapshot := *job
+	go r.run(ctx, progress, fn)
+	return &snapshot, nil
+}
+
+func (r *Runner) run(ctx context.Context, progress *Progress, fn Func) {
+	job := progress.job
+	defer func() {
+		r.mu.Lock()
+		if cancel, ok := r.cancels[job.ID]; ok {
+			cancel()
+			delete(r.cancels, job.ID)
+		}
+		delete(r.running, job.ID)
+		r.mu.Unlock()
+	}()
+
+	progress.setStatus(database.JobRunning)
+	log.Printf("Job %s (%s) started", job.ID, job.Kind)
+
+	result, err := fn(ctx, progress)
+	progress.finish(ctx, result, err)
+}
+
+// Cancel requests cancellation of a running job.
+func (r *Runner) Cancel(id string) error {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("job %s is not running", id)
+	}
+	cancel()
+	return nil
+}
+
+// Get returns the state of a job, from memory while it runs.
+func (r *Runner) Get(id string) (*database.Job, error) {
+	if progress := r.progress(id); progress != nil {
+		return progress.snapshot(), nil
+	}
+	return r.db.GetJob(id)
+}
+
+// List returns recent jobs, optionally for a single server.
+func (r *Runner) List(serverID string, limit int) ([]database.Job, error) {
+	list, err := r.db.ListJobs(serverID, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if progress := r.progress(list[i].ID); progress != nil {
+			list[i] = *progress.snapshot()
+		}
+	}
+	return list, nil
+}
+
+func (r *Runner) progress(id string) *Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running[id]
+}
+
+// Progress reports a job's current step and completion percentage. It is
+// safe for concurrent use.
+type Progress struct {
+	db  *database.DB
+	mu  sync.Mutex
+	job *database.Job
+	// saved is when the job was last persisted
+	saved time.Time
+}
+
+// Step finishes the current step and starts a new one.
+func (p *Progress) Step(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.finishStep(now)
+	p.job.Steps = append(p.job.Steps, database.JobStep{Name: name, StartedAt: now})
+	p.job.Step = name
+	p.job.Progress = 0
+	p.job.Message = ""
+	p.save()
+}
+
+// Update sets the completion percentage of the current step. Updates that
+// only change the message are persisted at most once per saveInterval.
+func (p *Progress) Update(percent int, message string) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if percent == p.job.Progress && message == p.job.Message {
+		return
+	}
+	changed := percent != p.job.Progress
+	p.job.Progress = percent
+	p.job.Message = message
+	if changed || time.Since(p.saved) >= saveInterval {
+		p.save()
+	}
+}
+
+// SetServer associates the job with a server once its ID is known.
+func (p *Progress) SetServer(serverID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.job.ServerID = serverID
+	p.save()
+}
+
+func (p *Progress) setStatus(status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.job.Status = status
+	p.save()
+}
+
+func (p *Progress) finish(ctx context.Context, result interface{}, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.finishStep(now)
+	p.job.FinishedAt = &now
+
+	switch {
+	case err != nil && (errors.Is(ctx.Err(), context.Canceled)):
+		p.job.Status = database.JobCancelled
+		p.job.Error = err.Error()
+	case err != nil:
+		p.job.Status = database.JobFailed
+		p.job.Error = err.Error()
+	default:
+		p.job.Status = database.JobSucceeded
+		p.job.Progress = 100
+		if result != nil {
+			if data, err := json.Marshal(result); err == nil {
+				p.job.Result = data
+			} else {
+				log.Printf("Error encoding result of job %s: %v", p.job.ID, err)
+			}
+		}
+	}
+
+	p.save()
+	log.Printf("Job %s (%s) finished with status %s", p.job.ID, p.job.Kind, p.job.Status)
+}
+
+func (p *Progress) finishStep(now time.Time) {
+	if n := len(p.job.Steps); n > 0 && p.job.Steps[n-1].FinishedAt == nil {
+		p.job.Steps[n-1].FinishedAt = &now
+	}
+}
+
+// snapshot returns a copy of the job's current state.
+func (p *Progress) snapshot() *database.Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job := *p.job
+	job.Steps = append([]database.JobStep(nil), p.job.Steps...)
+	return &job
+}
+
+func (p *Progress) save() {
+	p.saved = time.Now()
+	if err := p.db.SaveJob(p.job); err != nil {
+		log.Printf("Error saving job %s: %v", p.job.ID, err)
+	}
+}
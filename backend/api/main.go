@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
 	"github.com/mboxmini/mboxmini/backend/api/database"
 	"github.com/mboxmini/mboxmini/backend/api/docker"
 	"github.com/mboxmini/mboxmini/backend/api/handlers"
+	"github.com/mboxmini/mboxmini/backend/api/jobs"
 	"github.com/mboxmini/mboxmini/backend/api/middleware"
+	"github.com/mboxmini/mboxmini/backend/api/modpack"
+	"github.com/mboxmini/mboxmini/backend/api/schedule"
 
 	"github.com/gorilla/mux"
 )
@@ -31,17 +38,88 @@ func main() {
 	rateLimiter := middleware.NewRateLimiter()
 	authMiddleware := middleware.NewAuthMiddleware(db, jwtSecret)
 
+	// Initialize version catalog
+	catalogPath := os.Getenv("CATALOG_PATH")
+	if catalogPath == "" {
+		catalogPath = "catalog.json"
+	}
+	versions, err := catalog.New(catalogPath, os.Getenv("CATALOG_OFFLINE") == "true")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go versions.Watch(context.Background())
+
 	// Initialize Docker manager
 	dataPath := os.Getenv("DATA_PATH")
-	manager, err := docker.NewManager(dataPath, 25565, 25665)
+	manager, err := docker.NewManager(db, versions, dataPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Ownership for server data, e.g. 1000:1000 to match the Minecraft image's user
+	dataUID, dataGID := -1, -1
+	if value := os.Getenv("DATA_UID"); value != "" {
+		if dataUID, err = strconv.Atoi(value); err != nil {
+			log.Fatalf("Invalid DATA_UID: %v", err)
+		}
+	}
+	if value := os.Getenv("DATA_GID"); value != "" {
+		if dataGID, err = strconv.Atoi(value); err != nil {
+			log.Fatalf("Invalid DATA_GID: %v", err)
+		}
+	}
+	manager.SetDataOwner(dataUID, dataGID)
+
+	// Backups go to BACKUP_PATH, or a backups folder under DATA_PATH
+	if path := os.Getenv("BACKUP_PATH"); path != "" {
+		if err := manager.SetBackupPath(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Storage for new servers: "bind", "volume" or empty to pick automatically
+	if err := manager.SetDefaultStorage(os.Getenv("STORAGE_BACKEND")); err != nil {
+		log.Fatal(err)
+	}
+
+	// Modpack imports download listed files unless MODPACK_OFFLINE is set,
+	// in which case packs must bundle them
+	if os.Getenv("MODPACK_OFFLINE") == "true" {
+		manager.SetModpackDownloader(modpack.Offline{})
+	} else {
+		manager.SetModpackDownloader(modpack.NewHTTPDownloader(os.Getenv("CURSEFORGE_API_KEY")))
+	}
+
+	// Record player sessions from the servers' online player lists
+	go manager.TrackPlayers(context.Background(), time.Minute)
+
+	// Initialize background job runner
+	runner, err := jobs.NewRunner(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Run scheduled restarts, backups and commands
+	scheduler, err := schedule.NewScheduler(db, manager)
 	if err != nil {
 		log.Fatal(err)
 	}
+	go scheduler.Run(context.Background())
 
 	// Initialize handlers
-	serverHandler := handlers.NewServerHandler(manager)
+	serverHandler := handlers.NewServerHandler(manager, db, runner)
 	authHandler := handlers.NewAuthHandler(db, jwtSecret)
 	adminHandler := handlers.NewAdminHandler(db)
+	templateHandler := handlers.NewTemplateHandler(db)
+	catalogHandler := handlers.NewCatalogHandler(versions)
+	jobHandler := handlers.NewJobHandler(runner)
+	quotaHandler := handlers.NewQuotaHandler(manager, db)
+	settingsHandler := handlers.NewSettingsHandler(db)
+	networkHandler := handlers.NewNetworkHandler(manager, db, runner)
+	addonHandler := handlers.NewAddonHandler(manager)
+	worldHandler := handlers.NewWorldHandler(manager, runner)
+	playerHandler := handlers.NewPlayerHandler(manager, db)
+	scheduleHandler := handlers.NewScheduleHandler(manager, db, scheduler)
 
 	// Initialize router
 	r := mux.NewRouter()
@@ -67,6 +145,16 @@ func main() {
 	// Register routes
 	serverHandler.RegisterRoutes(api)
 	adminHandler.RegisterRoutes(api)
+	templateHandler.RegisterRoutes(api)
+	catalogHandler.RegisterRoutes(api)
+	jobHandler.RegisterRoutes(api)
+	quotaHandler.RegisterRoutes(api)
+	settingsHandler.RegisterRoutes(api)
+	networkHandler.RegisterRoutes(api)
+	addonHandler.RegisterRoutes(api)
+	worldHandler.RegisterRoutes(api)
+	playerHandler.RegisterRoutes(api)
+	scheduleHandler.RegisterRoutes(api)
 
 	// Start server
 	port := os.Getenv("API_PORT")
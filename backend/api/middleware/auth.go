@@ -17,6 +17,7 @@ type AuthMiddleware struct {
 type UserContext struct {
 	ID       int64
 	Username string
+	IsAdmin  bool
 }
 
 func NewAuthMiddleware(db *database.DB, jwtSecret string) *AuthMiddleware {
@@ -93,12 +94,25 @@ func (a *AuthMiddleware) validateJWT(tokenString string) (*UserContext, error) {
 		return nil, jwt.ErrInvalidKey
 	}
 
-	userID := int64(claims["user_id"].(float64))
-	username := claims["username"].(string)
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, jwt.ErrInvalidKey
+	}
+
+	// Admin rights can change after the token was issued, so they come
+	// from the database
+	user, err := a.db.GetUserByID(int64(userID))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
 
 	return &UserContext{
-		ID:       userID,
-		Username: username,
+		ID:       user.ID,
+		Username: user.Username,
+		IsAdmin:  user.IsAdmin,
 	}, nil
 }
 
@@ -115,9 +129,23 @@ func (a *AuthMiddleware) validateAPIKey(apiKey string) (*UserContext, error) {
 	return &UserContext{
 		ID:       user.ID,
 		Username: user.Username,
+		IsAdmin:  user.IsAdmin,
 	}, nil
 }
 
+// RequireAdmin rejects requests from users who aren't admins. It must run
+// after Authenticate.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUserFromContext(r.Context())
+		if user == nil || !user.IsAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Helper function to get user context from request
 func GetUserFromContext(ctx context.Context) *UserContext {
 	user, ok := ctx.Value("user").(*UserContext)
@@ -0,0 +1,201 @@
+package modpack
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const curseForgeFileURL = "https://api.curseforge.com/v1/mods/%d/files/%d"
+
+// maxFileSize caps a single downloaded file.
+const maxFileSize = 512 << 20
+
+// downloadHosts are the hosts the .mrpack format allows downloads from,
+// plus the CDN CurseForge serves files from.
+var downloadHosts = map[string]bool{
+	"cdn.modrinth.com":          true,
+	"github.com":                true,
+	"raw.githubusercontent.com": true,
+	"gitlab.com":                true,
+	"edge.forgecdn.net":         true,
+	"mediafilez.forgecdn.net":   true,
+}
+
+// Downloader fetches the files a pack lists but doesn't bundle.
+type Downloader interface {
+	// Check reports before any work starts whether f can be fetched.
+	Check(f File) error
+	// Fetch returns f with its path and hashes resolved, and its contents.
+	Fetch(ctx context.Context, f File) (File, []byte, error)
+}
+
+// Offline refuses every download, so packs must bundle all their files.
+type Offline struct{}
+
+func (Offline) Check(f File) error {
+	return fmt.Errorf("%s is not bundled in the pack and downloads are disabled", f)
+}
+
+func (o Offline) Fetch(ctx context.Context, f File) (File, []byte, error) {
+	return f, nil, o.Check(f)
+}
+
+// HTTPDownloader downloads Modrinth files from their listed URLs and
+// resolves CurseForge files through the CurseForge API, which needs a key.
+type HTTPDownloader struct {
+	client           *http.Client
+	curseForgeAPIKey string
+}
+
+func NewHTTPDownloader(curseForgeAPIKey string) *HTTPDownloader {
+	return &HTTPDownloader{
+		client:           &http.Client{Timeout: 10 * time.Minute},
+		curseForgeAPIKey: curseForgeAPIKey,
+	}
+}
+
+func (d *HTTPDownloader) Check(f File) error {
+	if f.Path == "" {
+		if d.curseForgeAPIKey == "" {
+			return fmt.Errorf("%s needs a CurseForge API key (CURSEFORGE_API_KEY) or a server pack that bundles it", f)
+		}
+		return nil
+	}
+	if len(allowedURLs(f.URLs)) == 0 {
+		return fmt.Errorf("%s has no download URL on an allowed host", f)
+	}
+	return nil
+}
+
+func (d *HTTPDownloader) Fetch(ctx context.Context, f File) (File, []byte, error) {
+	if err := d.Check(f); err != nil {
+		return f, nil, err
+	}
+	if f.Path == "" {
+		resolved, err := d.resolveCurseForge(ctx, f)
+		if err != nil {
+			return f, nil, err
+		}
+		f = resolved
+	}
+
+	var lastErr error
+	for _, u := range allowedURLs(f.URLs) {
+		data, err := d.get(ctx, u, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return f, data, nil
+	}
+	return f, nil, fmt.Errorf("failed to download %s: %v", f, lastErr)
+}
+
+// resolveCurseForge looks up the file name, download URL and hash of a
+// CurseForge file.
+func (d *HTTPDownloader) resolveCurseForge(ctx context.Context, f File) (File, error) {
+	data, err := d.get(ctx, fmt.Sprintf(curseForgeFileURL, f.ProjectID, f.FileID), map[string]string{"x-api-key": d.curseForgeAPIKey})
+	if err != nil {
+		return f, fmt.Errorf("failed to look up %s: %v", f, err)
+	}
+
+	var resp struct {
+		Data struct {
+			FileName    string `json:"fileName"`
+			DownloadURL string `json:"downloadUrl"`
+			FileLength  int64  `json:"fileLength"`
+			Hashes      []struct {
+				Value string `json:"value"`
+				Algo  int    `json:"algo"`
+			} `json:"hashes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return f, fmt.Errorf("invalid CurseForge response for %s: %v", f, err)
+	}
+	if resp.Data.DownloadURL == "" {
+		return f, fmt.Errorf("%s (%s) can't be downloaded outside CurseForge; use a server pack that bundles it", f, resp.Data.FileName)
+	}
+	if !safePath("mods/" + resp.Data.FileName) {
+		return f, fmt.Errorf("invalid file name %q for %s", resp.Data.FileName, f)
+	}
+
+	f.Path = "mods/" + resp.Data.FileName
+	f.URLs = []string{resp.Data.DownloadURL}
+	f.Size = resp.Data.FileLength
+	for _, hash := range resp.Data.Hashes {
+		if hash.Algo == 1 {
+			f.SHA1 = hash.Value
+		}
+	}
+	return f, nil
+}
+
+func (d *HTTPDownloader) get(ctx context.Context, u string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "mboxmini")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("%s is larger than %d MB", u, maxFileSize>>20)
+	}
+	return data, nil
+}
+
+// allowedURLs keeps the https URLs on downloadHosts.
+func allowedURLs(urls []string) []string {
+	var allowed []string
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.Scheme == "https" && downloadHosts[u.Hostname()] {
+			allowed = append(allowed, raw)
+		}
+	}
+	return allowed
+}
+
+// Verify checks downloaded contents against the hashes the pack or
+// CurseForge listed.
+func (f File) Verify(data []byte) error {
+	if f.SHA512 != "" {
+		sum := sha512.Sum512(data)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(f.SHA512) {
+			return fmt.Errorf("%s failed its SHA-512 check", f)
+		}
+	} else if f.SHA1 != "" {
+		sum := sha1.Sum(data)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(f.SHA1) {
+			return fmt.Errorf("%s failed its SHA-1 check", f)
+		}
+	}
+	if f.Size > 0 && int64(len(data)) != f.Size {
+		return fmt.Errorf("%s is %d bytes, expected %d", f, len(data), f.Size)
+	}
+	return nil
+}
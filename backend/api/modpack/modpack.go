@@ -0,0 +1,340 @@
+// Package modpack reads Modrinth (.mrpack) and CurseForge modpack archives
+// so servers can be created from them.
+package modpack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Pack formats
+const (
+	FormatModrinth   = "modrinth"
+	FormatCurseForge = "curseforge"
+)
+
+// Mod loaders a pack can target
+const (
+	LoaderFabric   = "fabric"
+	LoaderQuilt    = "quilt"
+	LoaderForge    = "forge"
+	LoaderNeoForge = "neoforge"
+)
+
+// Pack is a parsed modpack archive. Files lists what has to be downloaded;
+// overrides are copied from the archive itself.
+type Pack struct {
+	Format           string `json:"format"`
+	Name             string `json:"name"`
+	Version          string `json:"version,omitempty"`
+	MinecraftVersion string `json:"minecraftVersion"`
+	Loader           string `json:"loader"`
+	LoaderVersion    string `json:"loaderVersion,omitempty"`
+	Files            []File `json:"files"`
+
+	archive   *zip.Reader
+	overrides []string
+}
+
+// File is a file a pack lists instead of bundling. Modrinth files carry
+// their path, URLs and hashes; CurseForge files only name a project and file
+// that a Downloader has to resolve.
+type File struct {
+	Path      string   `json:"path,omitempty"`
+	URLs      []string `json:"urls,omitempty"`
+	SHA1      string   `json:"sha1,omitempty"`
+	SHA512    string   `json:"sha512,omitempty"`
+	Size      int64    `json:"size,omitempty"`
+	ProjectID int      `json:"projectId,omitempty"`
+	FileID    int      `json:"fileId,omitempty"`
+}
+
+func (f File) String() string {
+	if f.Path != "" {
+		return f.Path
+	}
+	return fmt.Sprintf("CurseForge project %d file %d", f.ProjectID, f.FileID)
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Open parses a modpack archive. The reader must stay open while the pack
+// is used.
+func Open(r io.ReaderAt, size int64) (*Pack, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, invalid("not a modpack archive: %v", err)
+	}
+
+	var pack *Pack
+	if f := findFile(archive, "modrinth.index.json"); f != nil {
+		pack, err = parseModrinth(f)
+	} else if f := findFile(archive, "manifest.json"); f != nil {
+		pack, err = parseCurseForge(f)
+	} else {
+		return nil, invalid("archive has neither a modrinth.index.json nor a CurseForge manifest.json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	pack.archive = archive
+
+	if pack.MinecraftVersion == "" {
+		return nil, invalid("modpack doesn't name a Minecraft version")
+	}
+	if pack.Loader == "" {
+		return nil, invalid("modpack doesn't use Fabric, Quilt, Forge or NeoForge")
+	}
+	for _, f := range pack.Files {
+		if f.Path != "" && !safePath(f.Path) {
+			return nil, invalid("modpack file path %q leaves the server directory", f.Path)
+		}
+	}
+
+	if pack.Format == FormatCurseForge {
+		// Server packs often keep their files next to the manifest
+		for _, f := range archive.File {
+			if strings.HasPrefix(f.Name, "mods/") && strings.HasSuffix(f.Name, ".jar") {
+				pack.overrides = append([]string{""}, pack.overrides...)
+				break
+			}
+		}
+		// Server packs bundle their mods; the listed files are then already there
+		if pack.bundlesMods() {
+			pack.Files = []File{}
+		}
+	}
+	return pack, nil
+}
+
+func findFile(archive *zip.Reader, name string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func readJSON(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return invalid("failed to read %s: %v", f.Name, err)
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return invalid("invalid %s: %v", f.Name, err)
+	}
+	return nil
+}
+
+func parseModrinth(f *zip.File) (*Pack, error) {
+	var index struct {
+		Game      string `json:"game"`
+		Name      string `json:"name"`
+		VersionID string `json:"versionId"`
+		Files     []struct {
+			Path   string            `json:"path"`
+			Hashes map[string]string `json:"hashes"`
+			Env    map[string]string `json:"env"`
+			URLs   []string          `json:"downloads"`
+			Size   int64             `json:"fileSize"`
+		} `json:"files"`
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if err := readJSON(f, &index); err != nil {
+		return nil, err
+	}
+	if index.Game != "" && index.Game != "minecraft" {
+		return nil, invalid("modpack is for %s, not Minecraft", index.Game)
+	}
+
+	pack := &Pack{
+		Format:           FormatModrinth,
+		Name:             index.Name,
+		Version:          index.VersionID,
+		MinecraftVersion: index.Dependencies["minecraft"],
+		Files:            []File{},
+		// Server overrides are applied last so they win
+		overrides: []string{"overrides", "server-overrides"},
+	}
+	for dependency, loader := range map[string]string{
+		"fabric-loader": LoaderFabric,
+		"quilt-loader":  LoaderQuilt,
+		"forge":         LoaderForge,
+		"neoforge":      LoaderNeoForge,
+	} {
+		if version, ok := index.Dependencies[dependency]; ok {
+			pack.Loader, pack.LoaderVersion = loader, version
+		}
+	}
+
+	for _, file := range index.Files {
+		if file.Env["server"] == "unsupported" {
+			continue
+		}
+		pack.Files = append(pack.Files, File{
+			Path:   file.Path,
+			URLs:   file.URLs,
+			SHA1:   file.Hashes["sha1"],
+			SHA512: file.Hashes["sha512"],
+			Size:   file.Size,
+		})
+	}
+	return pack, nil
+}
+
+func parseCurseForge(f *zip.File) (*Pack, error) {
+	var manifest struct {
+		ManifestType string `json:"manifestType"`
+		Name         string `json:"name"`
+		Version      string `json:"version"`
+		Minecraft    struct {
+			Version    string `json:"version"`
+			ModLoaders []struct {
+				ID      string `json:"id"`
+				Primary bool   `json:"primary"`
+			} `json:"modLoaders"`
+		} `json:"minecraft"`
+		Files []struct {
+			ProjectID int  `json:"projectID"`
+			FileID    int  `json:"fileID"`
+			Required  bool `json:"required"`
+		} `json:"files"`
+		Overrides string `json:"overrides"`
+	}
+	if err := readJSON(f, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.ManifestType != "" && manifest.ManifestType != "minecraftModpack" {
+		return nil, invalid("manifest.json is a %s, not a modpack", manifest.ManifestType)
+	}
+
+	pack := &Pack{
+		Format:           FormatCurseForge,
+		Name:             manifest.Name,
+		Version:          manifest.Version,
+		MinecraftVersion: manifest.Minecraft.Version,
+		Files:            []File{},
+		overrides:        []string{manifest.Overrides},
+	}
+	if manifest.Overrides == "" {
+		pack.overrides = []string{"overrides"}
+	}
+
+	// IDs look like forge-47.2.0 or fabric-0.15.7
+	for _, loader := range manifest.Minecraft.ModLoaders {
+		name, version, _ := strings.Cut(loader.ID, "-")
+		switch name {
+		case LoaderFabric, LoaderQuilt, LoaderForge, LoaderNeoForge:
+		default:
+			continue
+		}
+		if pack.Loader == "" || loader.Primary {
+			pack.Loader, pack.LoaderVersion = name, version
+		}
+	}
+
+	for _, file := range manifest.Files {
+		if !file.Required {
+			continue
+		}
+		pack.Files = append(pack.Files, File{ProjectID: file.ProjectID, FileID: file.FileID})
+	}
+	return pack, nil
+}
+
+// bundlesMods reports whether the archive carries mod jars, as CurseForge
+// server packs do.
+func (p *Pack) bundlesMods() bool {
+	for _, f := range p.archive.File {
+		if _, rel, ok := p.dataPath(f.Name); ok && strings.HasPrefix(rel, "mods/") && strings.HasSuffix(rel, ".jar") {
+			return true
+		}
+	}
+	return false
+}
+
+// dataPath maps an archive entry to its path under the server directory.
+// Entries inside an overrides folder, which is "" for the archive root, are
+// unpacked; other entries, such as the manifest, are not part of the server.
+func (p *Pack) dataPath(name string) (order int, rel string, ok bool) {
+	for i := len(p.overrides) - 1; i >= 0; i-- {
+		dir := p.overrides[i]
+		if dir == "" {
+			if name != "manifest.json" && name != "modlist.html" && safePath(name) {
+				return i, name, true
+			}
+			continue
+		}
+		if rel, found := strings.CutPrefix(name, dir+"/"); found && rel != "" && safePath(rel) {
+			return i, rel, true
+		}
+	}
+	return 0, "", false
+}
+
+// Bundled reports whether the archive already contains a listed file in one
+// of its overrides folders.
+func (p *Pack) Bundled(f File) bool {
+	if f.Path == "" {
+		return false
+	}
+	for _, dir := range p.overrides {
+		if findFile(p.archive, path.Join(dir, f.Path)) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Override is a file copied from the archive into the server directory.
+type Override struct {
+	Path string
+	Size int64
+	Mode int64
+	Open func() (io.ReadCloser, error)
+}
+
+// Overrides lists the files to copy from the archive, with later overrides
+// folders replacing files from earlier ones.
+func (p *Pack) Overrides() []Override {
+	type choice struct{ index, order int }
+	chosen := map[string]choice{}
+	var overrides []Override
+	for _, f := range p.archive.File {
+		order, rel, ok := p.dataPath(f.Name)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		override := Override{Path: rel, Size: int64(f.UncompressedSize64), Mode: int64(f.Mode().Perm()), Open: f.Open}
+		if c, seen := chosen[rel]; seen {
+			if order > c.order {
+				overrides[c.index] = override
+				chosen[rel] = choice{c.index, order}
+			}
+			continue
+		}
+		chosen[rel] = choice{len(overrides), order}
+		overrides = append(overrides, override)
+	}
+	return overrides
+}
+
+// safePath accepts relative slash-separated paths that stay inside the
+// server directory.
+func safePath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean == p && clean != ".." && !strings.HasPrefix(clean, "../")
+}
@@ -0,0 +1,92 @@
+// Package mojang resolves Java Edition player names to the UUIDs servers
+// store in whitelist.json, ops.json and banned-players.json.
+package mojang
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const profileURL = "https://api.mojang.com/users/profiles/minecraft/"
+
+// ErrNotFound is returned for names no account has.
+var ErrNotFound = errors.New("no Minecraft account has that name")
+
+// Profile is a player's current name and UUID.
+type Profile struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+type Client struct {
+	client  *http.Client
+	baseURL string
+}
+
+func NewClient() *Client {
+	return &Client{client: &http.Client{Timeout: 10 * time.Second}, baseURL: profileURL}
+}
+
+// Lookup asks Mojang for the account with a name.
+func (c *Client) Lookup(ctx context.Context, name string) (*Profile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "mboxmini")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("failed to look up %s: Mojang returned %s", name, resp.Status)
+	}
+
+	var body struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid profile for %s: %v", name, err)
+	}
+	uuid, err := FormatUUID(body.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile for %s: %v", name, err)
+	}
+	return &Profile{Name: body.Name, UUID: uuid}, nil
+}
+
+// OfflineProfile is the profile an offline-mode server gives a name: a
+// version 3 UUID of "OfflinePlayer:<name>".
+func OfflineProfile(name string) *Profile {
+	sum := md5.Sum([]byte("OfflinePlayer:" + name))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	uuid, _ := FormatUUID(hex.EncodeToString(sum[:]))
+	return &Profile{Name: name, UUID: uuid}
+}
+
+// FormatUUID adds dashes to the 32 hex digits Mojang returns.
+func FormatUUID(id string) (string, error) {
+	if len(id) != 32 {
+		return "", fmt.Errorf("malformed UUID %q", id)
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", fmt.Errorf("malformed UUID %q", id)
+	}
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:32], nil
+}
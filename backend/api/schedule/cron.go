@@ -0,0 +1,190 @@
+// Package schedule runs per-server tasks such as restarts, backups and
+// console commands on cron schedules.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a day field starting with *. As in cron, when both day
+	// fields are restricted a day matching either one matches.
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: dayNames},
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// ParseCron parses a cron expression such as "0 4 * * *" or "@daily".
+// Fields take *, numbers, ranges, lists and steps; months and weekdays also
+// take three-letter names, and 7 is Sunday like 0.
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, invalid("cron expression %q must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	var bits [5]uint64
+	for i, field := range cronFields {
+		var err error
+		if bits[i], err = parseCronField(parts[i], field); err != nil {
+			return nil, err
+		}
+	}
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*") || parts[2] == "?",
+		dowAny: strings.HasPrefix(parts[4], "*") || parts[4] == "?",
+	}, nil
+}
+
+func parseCronField(s string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, invalid("invalid step %q in %s field", stepPart, field.name)
+			}
+		}
+
+		lo, hi := field.min, field.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			if field.name == "day of week" {
+				hi = 6
+			}
+		default:
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = cronValue(first, field); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(last, field); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, invalid("invalid range %q in %s field", rangePart, field.name)
+				}
+			} else if hasStep {
+				hi = field.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, field cronField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(s, name) {
+			if field.name == "month" {
+				return i + 1, nil
+			}
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < field.min || v > field.max {
+		return 0, invalid("invalid value %q in %s field: use %d-%d", s, field.name, field.min, field.max)
+	}
+	return v, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// maxCronSearch bounds the search for the next match; an expression such
+// as "0 0 31 2 *" never matches.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t that matches, in t's location, or the
+// zero time if there is none. Times skipped by a daylight saving change
+// don't match, and repeated ones match once.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.Add(maxCronSearch)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		// The hour repeated when daylight saving ends was already matched
+		if c.minute&(1<<uint(t.Minute())) == 0 || t.Add(-time.Hour).Hour() == t.Hour() {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
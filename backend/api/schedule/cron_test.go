@@ -0,0 +1,135 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr     string
+		errorMsg string
+	}{
+		{expr: "0 4 * * *"},
+		{expr: "@daily"},
+		{expr: "@WEEKLY"},
+		{expr: "  */15 * * * *  "},
+		{expr: "0 9-17/2 * * mon-fri"},
+		{expr: "0 0 1,15 jan,JUL ?"},
+		{expr: "0 0 * * 7"},
+		{expr: "", errorMsg: "must have 5 fields"},
+		{expr: "* * * *", errorMsg: "must have 5 fields"},
+		{expr: "* * * * * *", errorMsg: "must have 5 fields"},
+		{expr: "@often", errorMsg: "must have 5 fields"},
+		{expr: "60 * * * *", errorMsg: "minute field"},
+		{expr: "* 24 * * *", errorMsg: "hour field"},
+		{expr: "* * 0 * *", errorMsg: "day of month field"},
+		{expr: "* * 32 * *", errorMsg: "day of month field"},
+		{expr: "* * * 13 *", errorMsg: "month field"},
+		{expr: "* * * * 8", errorMsg: "day of week field"},
+		{expr: "* * * * sunday", errorMsg: "day of week field"},
+		{expr: "*/0 * * * *", errorMsg: "invalid step"},
+		{expr: "*/x * * * *", errorMsg: "invalid step"},
+		{expr: "5-1 * * * *", errorMsg: "invalid range"},
+		{expr: "a * * * *", errorMsg: "minute field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Errorf("ParseCron(%q): %v", tt.expr, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("ParseCron(%q) error = %v, want one containing %q", tt.expr, err, tt.errorMsg)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"later today", "0 4 * * *", utc(2024, 1, 1, 3, 59), utc(2024, 1, 1, 4, 0)},
+		{"strictly after", "0 4 * * *", utc(2024, 1, 1, 4, 0), utc(2024, 1, 2, 4, 0)},
+		{"seconds dropped", "* * * * *", time.Date(2024, 1, 1, 4, 0, 30, 0, time.UTC), utc(2024, 1, 1, 4, 1)},
+		{"step", "*/15 * * * *", utc(2024, 1, 1, 4, 16), utc(2024, 1, 1, 4, 30)},
+		{"hour range step", "0 9-17/4 * * *", utc(2024, 1, 1, 13, 1), utc(2024, 1, 1, 17, 0)},
+		{"next month", "0 0 1 * *", utc(2024, 1, 15, 0, 0), utc(2024, 2, 1, 0, 0)},
+		{"next year", "@yearly", utc(2024, 6, 1, 0, 0), utc(2025, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", utc(2023, 3, 1, 0, 0), utc(2024, 2, 29, 0, 0)},
+		{"never", "0 0 31 2 *", utc(2024, 1, 1, 0, 0), time.Time{}},
+		{"sunday as 0", "0 0 * * 0", utc(2024, 1, 3, 0, 0), utc(2024, 1, 7, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", utc(2024, 1, 3, 0, 0), utc(2024, 1, 7, 0, 0)},
+		{"sunday in range", "0 0 * * 5-7", utc(2024, 1, 6, 0, 0), utc(2024, 1, 7, 0, 0)},
+		{"sunday by name", "0 0 * * SUN", utc(2024, 1, 3, 0, 0), utc(2024, 1, 7, 0, 0)},
+		{"weekdays", "0 0 * * mon-fri", utc(2024, 1, 5, 0, 0), utc(2024, 1, 8, 0, 0)},
+		{"day of month only", "0 0 13 * *", utc(2024, 1, 1, 0, 0), utc(2024, 1, 13, 0, 0)},
+		{"day of week only", "0 0 * * 5", utc(2024, 1, 1, 0, 0), utc(2024, 1, 5, 0, 0)},
+		{"day of month step from star", "0 0 */1 * 5", utc(2024, 1, 1, 0, 0), utc(2024, 1, 5, 0, 0)},
+		{"either day field, weekday first", "0 0 13 * 5", utc(2024, 1, 1, 0, 0), utc(2024, 1, 5, 0, 0)},
+		{"either day field, next weekday", "0 0 13 * 5", utc(2024, 1, 6, 0, 0), utc(2024, 1, 12, 0, 0)},
+		{"either day field, day of month", "0 0 13 * 5", utc(2024, 1, 12, 0, 0), utc(2024, 1, 13, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cron.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	local := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+	// Instants in the repeated hour are ambiguous in local time
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// 02:00-03:00 doesn't exist on 10 March 2024
+		{"skipped time", "30 2 * * *", local(2024, 3, 10, 0, 0), local(2024, 3, 11, 2, 30)},
+		{"after skipped hour", "0 3 * * *", local(2024, 3, 10, 0, 0), local(2024, 3, 10, 3, 0)},
+		{"hourly over skipped hour", "0 * * * *", local(2024, 3, 10, 1, 30), local(2024, 3, 10, 3, 0)},
+		// 01:00-02:00 happens twice on 3 November 2024, first in EDT (UTC-4)
+		{"repeated time", "30 1 * * *", local(2024, 11, 3, 0, 0), utc(2024, 11, 3, 5, 30)},
+		{"repeated time once", "30 1 * * *", utc(2024, 11, 3, 5, 30).In(loc), local(2024, 11, 4, 1, 30)},
+		{"hourly over repeated hour", "0 * * * *", utc(2024, 11, 3, 5, 0).In(loc), local(2024, 11, 3, 2, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cron.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
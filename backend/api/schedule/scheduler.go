@@ -0,0 +1,378 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+	"unicode"
+
+	"github.com/mboxmini/mboxmini/backend/api/database"
+	"github.com/mboxmini/mboxmini/backend/api/docker"
+)
+
+// Actions a schedule can run
+const (
+	ActionRestart = "restart"
+	ActionStop    = "stop"
+	ActionStart   = "start"
+	ActionBackup  = "backup"
+	ActionCommand = "command"
+)
+
+// Catch-up policies for runs missed while the API was down: skip them and
+// wait for the next one, or run once as soon as the API is back.
+const (
+	CatchUpSkip    = "skip"
+	CatchUpRunOnce = "run_once"
+)
+
+const (
+	// tickInterval is how often due schedules are looked for.
+	tickInterval = 15 * time.Second
+	// misfireGrace is how late a run can start before it counts as missed.
+	misfireGrace = 2 * time.Minute
+	// maxCommands caps the commands of a command schedule.
+	maxCommands = 20
+	// maxOutput caps the console output kept for a run.
+	maxOutput = 64 << 10
+)
+
+// Scheduler runs due schedules in the background. A schedule never runs
+// twice at the same time.
+type Scheduler struct {
+	db            *database.DB
+	dockerManager *docker.Manager
+	mu            sync.Mutex
+	running       map[int64]bool
+}
+
+func NewScheduler(db *database.DB, dm *docker.Manager) (*Scheduler, error) {
+	// Anything still running belonged to a previous process
+	if err := db.FailInterruptedScheduleRuns(); err != nil {
+		return nil, fmt.Errorf("failed to recover interrupted schedule runs: %v", err)
+	}
+	return &Scheduler{db: db, dockerManager: dm, running: make(map[int64]bool)}, nil
+}
+
+// Prepare checks a schedule's settings, fills in defaults and sets its next
+// run.
+func Prepare(s *database.Schedule) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if len(s.Name) > 64 {
+		return invalid("name must be at most 64 characters")
+	}
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return invalid("unknown timezone %q", s.Timezone)
+	}
+
+	switch s.Action {
+	case ActionCommand:
+		if len(s.Commands) == 0 || len(s.Commands) > maxCommands {
+			return invalid("command schedules need 1 to %d commands", maxCommands)
+		}
+		for i, command := range s.Commands {
+			command = strings.TrimPrefix(strings.TrimSpace(command), "/")
+			if command == "" || len(command) > 256 || strings.IndexFunc(command, unicode.IsControl) >= 0 {
+				return invalid("command %d must be 1 to 256 printable characters", i+1)
+			}
+			s.Commands[i] = command
+		}
+	case ActionRestart, ActionStop, ActionStart, ActionBackup:
+		if len(s.Commands) > 0 {
+			return invalid("only command schedules take commands")
+		}
+	default:
+		return invalid("unknown action %q: use restart, stop, start, backup or command", s.Action)
+	}
+	if s.Commands == nil {
+		s.Commands = []string{}
+	}
+
+	if s.Warning < 0 || s.Warning > docker.MaxStopWarning {
+		return invalid("warning must be between 0 and %d seconds", docker.MaxStopWarning)
+	}
+	if s.Warning > 0 && s.Action != ActionRestart && s.Action != ActionStop {
+		return invalid("warnings are only given before restarts and stops")
+	}
+
+	switch s.CatchUp {
+	case "":
+		s.CatchUp = CatchUpSkip
+	case CatchUpSkip, CatchUpRunOnce:
+	default:
+		return invalid("unknown catch-up policy %q: use skip or run_once", s.CatchUp)
+	}
+
+	s.NextRunAt = nil
+	next := cron.Next(time.Now().In(loc))
+	if next.IsZero() {
+		return invalid("cron expression %q never matches", s.Cron)
+	}
+	if s.Enabled {
+		s.NextRunAt = &next
+	}
+	return nil
+}
+
+// nextRun returns the schedule's first run after t.
+func nextRun(s *database.Schedule, t time.Time) *time.Time {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	next := cron.Next(t.In(loc))
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// Run starts due schedules until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		s.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue starts every enabled schedule whose next run has come. A run due
+// more than misfireGrace ago was missed, normally because the API was down,
+// and is handled by the schedule's catch-up policy.
+func (s *Scheduler) runDue(ctx context.Context) {
+	schedules, err := s.db.ListSchedules("")
+	if err != nil {
+		log.Printf("Error listing schedules: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range schedules {
+		sch := &schedules[i]
+		if !sch.Enabled || sch.NextRunAt == nil || sch.NextRunAt.After(now) {
+			continue
+		}
+
+		due := *sch.NextRunAt
+		var lastRun *time.Time
+		if now.Sub(due) <= misfireGrace {
+			s.start(ctx, sch, database.RunScheduled, due)
+			lastRun = &now
+		} else {
+			// Find the last run that was missed
+			missed := 1
+			for next := nextRun(sch, due); next != nil && !next.After(now) && missed < 10000; next = nextRun(sch, *next) {
+				due = *next
+				missed++
+			}
+			if sch.CatchUp == CatchUpRunOnce {
+				log.Printf("Catching up schedule %d after %d missed runs", sch.ID, missed)
+				s.start(ctx, sch, database.RunCatchUp, due)
+				lastRun = &now
+			} else {
+				reason := "missed a run while the API was down"
+				if missed > 1 {
+					reason = fmt.Sprintf("missed %d runs while the API was down", missed)
+				}
+				s.skip(sch, database.RunCatchUp, due, reason)
+			}
+		}
+
+		if err := s.db.AdvanceSchedule(sch.ID, lastRun, nextRun(sch, now)); err != nil {
+			log.Printf("Error advancing schedule %d: %v", sch.ID, err)
+		}
+	}
+}
+
+// RunNow starts a schedule immediately, outside its cron schedule. The run
+// carries on after the request that started it.
+func (s *Scheduler) RunNow(sch *database.Schedule) (*database.ScheduleRun, error) {
+	run := s.start(context.Background(), sch, database.RunManual, time.Now())
+	if run == nil {
+		return nil, fmt.Errorf("failed to start schedule %d", sch.ID)
+	}
+	return run, nil
+}
+
+// skip records a run that didn't happen.
+func (s *Scheduler) skip(sch *database.Schedule, source string, scheduledAt time.Time, reason string) *database.ScheduleRun {
+	now := time.Now()
+	run := &database.ScheduleRun{
+		ScheduleID:  sch.ID,
+		ServerID:    sch.ServerID,
+		Source:      source,
+		ScheduledAt: scheduledAt,
+		FinishedAt:  &now,
+		Status:      database.RunSkipped,
+		Error:       reason,
+	}
+	if err := s.db.CreateScheduleRun(run); err != nil {
+		log.Printf("Error recording skipped run of schedule %d: %v", sch.ID, err)
+		return nil
+	}
+	log.Printf("Skipped schedule %d (%s) on server %s: %s", sch.ID, sch.Action, sch.ServerID, reason)
+	return run
+}
+
+// start records a run and carries it out in the background.
+func (s *Scheduler) start(ctx context.Context, sch *database.Schedule, source string, scheduledAt time.Time) *database.ScheduleRun {
+	s.mu.Lock()
+	if s.running[sch.ID] {
+		s.mu.Unlock()
+		return s.skip(sch, source, scheduledAt, "previous run is still in progress")
+	}
+	s.running[sch.ID] = true
+	s.mu.Unlock()
+
+	now := time.Now()
+	run := &database.ScheduleRun{
+		ScheduleID:  sch.ID,
+		ServerID:    sch.ServerID,
+		Source:      source,
+		ScheduledAt: scheduledAt,
+		StartedAt:   &now,
+		Status:      database.RunRunning,
+	}
+	if err := s.db.CreateScheduleRun(run); err != nil {
+		log.Printf("Error recording run of schedule %d: %v", sch.ID, err)
+		s.mu.Lock()
+		delete(s.running, sch.ID)
+		s.mu.Unlock()
+		return nil
+	}
+	log.Printf("Running schedule %d (%s) on server %s", sch.ID, sch.Action, sch.ServerID)
+
+	snapshot := *run
+	schedule := *sch
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, schedule.ID)
+			s.mu.Unlock()
+		}()
+
+		var output strings.Builder
+		skipped, err := s.execute(ctx, &schedule, &output)
+		finished := time.Now()
+		run.FinishedAt = &finished
+		run.Output = output.String()
+		if len(run.Output) > maxOutput {
+			run.Output = run.Output[:maxOutput]
+		}
+		switch {
+		case err != nil:
+			run.Status, run.Error = database.RunFailed, err.Error()
+		case skipped != "":
+			run.Status, run.Error = database.RunSkipped, skipped
+		default:
+			run.Status = database.RunSucceeded
+		}
+		if err := s.db.FinishScheduleRun(run); err != nil {
+			log.Printf("Error recording outcome of schedule %d: %v", schedule.ID, err)
+		}
+		log.Printf("Schedule %d (%s) on server %s finished with status %s", schedule.ID, schedule.Action, schedule.ServerID, run.Status)
+	}()
+	return &snapshot
+}
+
+// execute carries out a schedule's action. A run that has nothing to do,
+// such as restarting a stopped server, returns why it was skipped.
+func (s *Scheduler) execute(ctx context.Context, sch *database.Schedule, output *strings.Builder) (string, error) {
+	dm := s.dockerManager
+	running, err := dm.ServerRunning(ctx, sch.ServerID)
+	if err != nil {
+		return "", err
+	}
+
+	switch sch.Action {
+	case ActionRestart, ActionStop:
+		if !running {
+			return "server is not running", nil
+		}
+		opts := docker.StopOptions{Warning: sch.Warning}
+		var result *docker.StopResult
+		if sch.Action == ActionRestart {
+			result, err = dm.RestartServer(ctx, sch.ServerID, opts, nil)
+		} else {
+			result, err = dm.GracefulStop(ctx, sch.ServerID, opts, nil)
+		}
+		if result != nil {
+			for _, phase := range result.Phases {
+				fmt.Fprintf(output, "%s: %s %s\n", phase.Name, phase.Status, strings.TrimSpace(phase.Message))
+			}
+		}
+		return "", err
+
+	case ActionStart:
+		if running {
+			return "server is already running", nil
+		}
+		ownerID, err := dm.ServerOwner(ctx, sch.ServerID)
+		if err != nil {
+			return "", err
+		}
+		return "", dm.WithQuota(ctx, ownerID, docker.QuotaRequest{Running: 1}, func() error {
+			return dm.StartServer(sch.ServerID)
+		})
+
+	case ActionBackup:
+		ownerID, err := dm.ServerOwner(ctx, sch.ServerID)
+		if err != nil {
+			return "", err
+		}
+		var req docker.QuotaRequest
+		if ownerID != 0 {
+			usage, err := dm.ServerUsage(ctx, sch.ServerID)
+			if err != nil {
+				return "", err
+			}
+			req.DiskBytes = usage.DiskBytes
+		}
+		// Schedule names are free text, so they never reach the file name
+		label := fmt.Sprintf("scheduled-%d", sch.ID)
+		var backup *docker.Backup
+		if err := dm.WithQuota(ctx, ownerID, req, func() (err error) {
+			backup, err = dm.BackupServer(ctx, sch.ServerID, label, nil)
+			return err
+		}); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(output, "created backup %s\n", backup.Name)
+		return "", nil
+
+	case ActionCommand:
+		if !running {
+			return "server is not running", nil
+		}
+		for _, command := range sch.Commands {
+			result, err := dm.ExecuteCommand(ctx, sch.ServerID, command)
+			fmt.Fprintf(output, "> %s\n%s\n", command, strings.TrimSpace(result))
+			if err != nil {
+				return "", fmt.Errorf("command %q failed: %v", command, err)
+			}
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown action %q", sch.Action)
+}
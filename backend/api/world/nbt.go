@@ -0,0 +1,217 @@
+package world
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// NBT tag types
+const (
+	tagEnd byte = iota
+	tagByte
+	tagShort
+	tagInt
+	tagLong
+	tagFloat
+	tagDouble
+	tagByteArray
+	tagString
+	tagList
+	tagCompound
+	tagIntArray
+	tagLongArray
+)
+
+// maxLevelDat caps level.dat before and after decompression; real ones are
+// a few kilobytes.
+const maxLevelDat = 16 << 20
+
+// maxNBTDepth bounds nesting so a crafted file can't exhaust the stack.
+const maxNBTDepth = 64
+
+var errNBT = errors.New("malformed NBT data")
+
+// Level is what we read from a Java Edition level.dat.
+type Level struct {
+	LevelName   string
+	Version     string
+	DataVersion int
+	LastPlayed  time.Time
+}
+
+// ReadLevel parses a gzipped level.dat, which must hold a Data compound.
+func ReadLevel(data []byte) (*Level, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("level.dat is not gzip-compressed; only Java Edition worlds can be imported")
+	}
+	raw, err := io.ReadAll(io.LimitReader(gz, maxLevelDat+1))
+	if err != nil {
+		return nil, errors.New("level.dat is corrupt: " + err.Error())
+	}
+	if len(raw) > maxLevelDat {
+		return nil, errors.New("level.dat is too large")
+	}
+
+	r := &nbtReader{buf: raw}
+	if tag, err := r.byte(); err != nil || tag != tagCompound {
+		return nil, errors.New("level.dat does not start with a compound tag")
+	}
+	if _, err := r.string(); err != nil {
+		return nil, errNBT
+	}
+	root, err := r.value(tagCompound, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := root.(map[string]interface{})["Data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("level.dat has no Data compound")
+	}
+	level := &Level{}
+	level.LevelName, _ = fields["LevelName"].(string)
+	if v, ok := fields["DataVersion"].(int32); ok {
+		level.DataVersion = int(v)
+	}
+	if v, ok := fields["LastPlayed"].(int64); ok && v > 0 {
+		level.LastPlayed = time.UnixMilli(v)
+	}
+	if version, ok := fields["Version"].(map[string]interface{}); ok {
+		level.Version, _ = version["Name"].(string)
+	}
+	return level, nil
+}
+
+// nbtReader decodes big-endian NBT. Strings, ints, longs and compounds are kept;
+// other values are read past.
+type nbtReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *nbtReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return nil, errNBT
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *nbtReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *nbtReader) int32() (int32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *nbtReader) string() (string, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return "", err
+	}
+	s, err := r.next(int(binary.BigEndian.Uint16(b)))
+	return string(s), err
+}
+
+// skipArray reads past a length-prefixed array of size-byte elements.
+func (r *nbtReader) skipArray(size int) error {
+	n, err := r.int32()
+	if err != nil {
+		return err
+	}
+	if n < 0 || int64(n)*int64(size) > math.MaxInt32 {
+		return errNBT
+	}
+	_, err = r.next(int(n) * size)
+	return err
+}
+
+func (r *nbtReader) value(tag byte, depth int) (interface{}, error) {
+	if depth > maxNBTDepth {
+		return nil, errNBT
+	}
+	switch tag {
+	case tagByte:
+		_, err := r.next(1)
+		return nil, err
+	case tagShort:
+		_, err := r.next(2)
+		return nil, err
+	case tagInt:
+		return r.int32()
+	case tagLong:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case tagDouble:
+		_, err := r.next(8)
+		return nil, err
+	case tagFloat:
+		_, err := r.next(4)
+		return nil, err
+	case tagByteArray:
+		return nil, r.skipArray(1)
+	case tagIntArray:
+		return nil, r.skipArray(4)
+	case tagLongArray:
+		return nil, r.skipArray(8)
+	case tagString:
+		return r.string()
+	case tagList:
+		elem, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := r.int32()
+		if err != nil || n < 0 {
+			return nil, errNBT
+		}
+		for i := int32(0); i < n; i++ {
+			if _, err := r.value(elem, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case tagCompound:
+		values := map[string]interface{}{}
+		for {
+			child, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			if child == tagEnd {
+				return values, nil
+			}
+			name, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.value(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				values[name] = v
+			}
+		}
+	}
+	return nil, errNBT
+}
@@ -0,0 +1,156 @@
+package world
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nbt builds NBT data for tests.
+type nbt struct {
+	bytes.Buffer
+}
+
+func (b *nbt) tag(tag byte, name string) *nbt {
+	b.WriteByte(tag)
+	b.str(name)
+	return b
+}
+
+func (b *nbt) str(s string) *nbt {
+	binary.Write(&b.Buffer, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+	return b
+}
+
+func (b *nbt) int32(v int32) *nbt {
+	binary.Write(&b.Buffer, binary.BigEndian, v)
+	return b
+}
+
+func (b *nbt) int64(v int64) *nbt {
+	binary.Write(&b.Buffer, binary.BigEndian, v)
+	return b
+}
+
+func (b *nbt) end() *nbt {
+	b.WriteByte(tagEnd)
+	return b
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// levelDat is a level.dat with the fields ReadLevel keeps and one of every
+// tag type it reads past.
+func levelDat() []byte {
+	b := &nbt{}
+	b.tag(tagCompound, "")
+	b.tag(tagCompound, "Data")
+	b.tag(tagString, "LevelName").str("My World")
+	b.tag(tagInt, "DataVersion").int32(3700)
+	b.tag(tagLong, "LastPlayed").int64(1700000000000)
+	b.tag(tagCompound, "Version").tag(tagString, "Name").str("1.20.4").end()
+	b.tag(tagByte, "hardcore").WriteByte(1)
+	b.tag(tagShort, "Difficulty").Write([]byte{0, 2})
+	b.tag(tagFloat, "BorderSize").int32(0)
+	b.tag(tagDouble, "BorderCenterX").int64(0)
+	b.tag(tagByteArray, "Bytes").int32(3).Write([]byte{1, 2, 3})
+	b.tag(tagIntArray, "Ints").int32(2).int32(1).int32(2)
+	b.tag(tagLongArray, "Longs").int32(1).int64(1)
+	b.tag(tagList, "Players").WriteByte(tagCompound)
+	b.int32(2)
+	b.tag(tagString, "Name").str("Steve").end()
+	b.tag(tagInt, "Score").int32(7).end()
+	b.end()
+	b.end()
+	return b.Bytes()
+}
+
+// nested is a level.dat whose Data compound holds depth nested lists.
+func nested(depth int) []byte {
+	b := &nbt{}
+	b.tag(tagCompound, "")
+	b.tag(tagCompound, "Data")
+	b.tag(tagList, "deep")
+	for i := 1; i < depth; i++ {
+		b.WriteByte(tagList)
+		b.int32(1)
+	}
+	b.WriteByte(tagEnd)
+	b.int32(0)
+	b.end()
+	b.end()
+	return b.Bytes()
+}
+
+func TestReadLevel(t *testing.T) {
+	level, err := ReadLevel(gzipped(t, levelDat()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Level{LevelName: "My World", Version: "1.20.4", DataVersion: 3700, LastPlayed: time.UnixMilli(1700000000000)}
+	if *level != want {
+		t.Errorf("ReadLevel() = %+v, want %+v", *level, want)
+	}
+}
+
+func TestReadLevelErrors(t *testing.T) {
+	noData := (&nbt{}).tag(tagCompound, "").tag(tagString, "LevelName").str("x").end().end()
+	hugeArray := (&nbt{}).tag(tagCompound, "").tag(tagByteArray, "a").int32(1 << 30).end()
+	overflowArray := (&nbt{}).tag(tagCompound, "").tag(tagLongArray, "a").int32(1 << 29).end()
+	negativeList := (&nbt{}).tag(tagCompound, "").tag(tagList, "a")
+	negativeList.WriteByte(tagInt)
+	negativeList.int32(-1).end()
+	longString := (&nbt{}).tag(tagCompound, "").tag(tagString, "a")
+	longString.Write([]byte{0xff, 0xff, 'x'})
+	unknownTag := (&nbt{}).tag(tagCompound, "").tag(13, "a").end()
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not gzipped", levelDat(), "not gzip-compressed"},
+		{"too large", gzipped(t, make([]byte, maxLevelDat+1)), "too large"},
+		{"empty", gzipped(t, nil), "does not start with a compound"},
+		{"not a compound", gzipped(t, (&nbt{}).tag(tagString, "").str("x").Bytes()), "does not start with a compound"},
+		{"no Data", gzipped(t, noData.Bytes()), "no Data compound"},
+		{"truncated", gzipped(t, levelDat()[:40]), errNBT.Error()},
+		{"missing end", gzipped(t, levelDat()[:len(levelDat())-1]), errNBT.Error()},
+		{"array past the end", gzipped(t, hugeArray.Bytes()), errNBT.Error()},
+		{"array size overflow", gzipped(t, overflowArray.Bytes()), errNBT.Error()},
+		{"negative list length", gzipped(t, negativeList.Bytes()), errNBT.Error()},
+		{"string past the end", gzipped(t, longString.Bytes()), errNBT.Error()},
+		{"unknown tag", gzipped(t, unknownTag.Bytes()), errNBT.Error()},
+		{"too deep", gzipped(t, nested(maxNBTDepth)), errNBT.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadLevel(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadLevel() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLevelDepth(t *testing.T) {
+	// Data is at depth 1, so its lists reach maxNBTDepth
+	if _, err := ReadLevel(gzipped(t, nested(maxNBTDepth-1))); err != nil {
+		t.Errorf("ReadLevel() at the depth limit: %v", err)
+	}
+}
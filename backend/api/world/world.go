@@ -0,0 +1,346 @@
+// Package world reads Java Edition world archives uploaded from singleplayer
+// saves or other servers so they can be installed into a server.
+package world
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mboxmini/mboxmini/backend/api/catalog"
+)
+
+// Archive formats
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// World layouts. Vanilla keeps the Nether and End in DIM-1 and DIM1 inside
+// the world folder; Bukkit-based servers split them into <name>_nether and
+// <name>_the_end next to it.
+const (
+	LayoutVanilla = "vanilla"
+	LayoutBukkit  = "bukkit"
+)
+
+// maxEntries caps the number of files an archive may hold.
+const maxEntries = 1 << 20
+
+// World is a world found in an uploaded archive.
+type World struct {
+	Format      string `json:"format"`
+	Folder      string `json:"folder"`
+	Layout      string `json:"layout"`
+	LevelName   string `json:"levelName,omitempty"`
+	Version     string `json:"version,omitempty"`
+	DataVersion int    `json:"dataVersion,omitempty"`
+	Files       int    `json:"files"`
+	Size        int64  `json:"size"`
+
+	r      io.ReaderAt
+	size   int64
+	nether string
+	end    string
+}
+
+// entry is a regular file or directory in an archive.
+type entry struct {
+	name string
+	dir  bool
+	mode int64
+	size int64
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &catalog.ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Open finds the world in a zip, tar or gzipped tar archive. The archive
+// may hold the world folder's contents, the folder itself, or a server
+// directory with Bukkit's split Nether and End folders. Archives with links,
+// paths leaving the archive, or more than maxSize bytes of content are
+// rejected. The reader must stay open while the world is used.
+func Open(r io.ReaderAt, size, maxSize int64) (*World, error) {
+	w := &World{r: r, size: size}
+	var err error
+	if w.Format, err = detectFormat(r, size); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	levels := map[string][]byte{}
+	var total int64
+	err = w.walk(func(e entry, rc io.Reader) error {
+		if len(entries) == maxEntries {
+			return invalid("archive has more than %d files", maxEntries)
+		}
+		total += e.size
+		if total > maxSize {
+			return invalid("world is larger than %d MB unpacked", maxSize>>20)
+		}
+		entries = append(entries, e)
+		if !e.dir && path.Base(e.name) == "level.dat" {
+			data, err := io.ReadAll(io.LimitReader(rc, maxLevelDat+1))
+			if err != nil {
+				return invalid("failed to read %s: %v", e.name, err)
+			}
+			levels[path.Dir(e.name)] = data
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Folder, err = worldFolder(levels); err != nil {
+		return nil, err
+	}
+	level, err := ReadLevel(levels[w.Folder])
+	if err != nil {
+		return nil, invalid("%s", err)
+	}
+	w.LevelName, w.Version, w.DataVersion = level.LevelName, level.Version, level.DataVersion
+
+	w.Layout = LayoutVanilla
+	if w.Folder != "." {
+		w.nether = w.Folder + "_nether"
+		w.end = w.Folder + "_the_end"
+		for _, e := range entries {
+			if strings.HasPrefix(e.name, w.nether+"/DIM-1/") || strings.HasPrefix(e.name, w.end+"/DIM1/") {
+				w.Layout = LayoutBukkit
+				break
+			}
+		}
+	}
+	if w.Layout == LayoutVanilla {
+		w.nether, w.end = "", ""
+	}
+
+	for _, e := range entries {
+		if _, ok := w.target(e.name, "world", w.Layout == LayoutBukkit); ok && !e.dir {
+			w.Files++
+			w.Size += e.size
+		}
+	}
+	return w, nil
+}
+
+// detectFormat tells archives apart by their magic bytes.
+func detectFormat(r io.ReaderAt, size int64) (string, error) {
+	header := make([]byte, 512)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case len(header) == 512 && bytes.Equal(header[257:262], []byte("ustar")):
+		return FormatTar, nil
+	}
+	return "", invalid("upload is not a zip or tar archive")
+}
+
+// worldFolder picks the shallowest folder holding a level.dat, leaving out
+// Bukkit's Nether and End folders, which have one of their own.
+func worldFolder(levels map[string][]byte) (string, error) {
+	var folders []string
+	for dir := range levels {
+		base := path.Base(dir)
+		if strings.HasSuffix(base, "_nether") || strings.HasSuffix(base, "_the_end") {
+			continue
+		}
+		folders = append(folders, dir)
+	}
+	if len(folders) == 0 {
+		return "", invalid("archive has no level.dat; upload a zip or tar of a world folder")
+	}
+
+	depth := func(dir string) int {
+		if dir == "." {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if depth(folders[i]) != depth(folders[j]) {
+			return depth(folders[i]) < depth(folders[j])
+		}
+		return folders[i] < folders[j]
+	})
+	if len(folders) > 1 && depth(folders[0]) == depth(folders[1]) {
+		return "", invalid("archive holds several worlds (%s and %s); upload one at a time", folders[0], folders[1])
+	}
+	return folders[0], nil
+}
+
+// Name is the folder name the world had, if it had one.
+func (w *World) Name() string {
+	if w.Folder == "." {
+		return ""
+	}
+	return path.Base(w.Folder)
+}
+
+// target maps an archive entry to its path under the server directory for a
+// world named levelName. With split, Bukkit's Nether and End folders are
+// kept as <levelName>_nether and <levelName>_the_end; otherwise only their
+// dimension data is moved into the world folder. Other entries, such as a
+// server's plugins or configuration, aren't part of the world.
+func (w *World) target(name, levelName string, split bool) (string, bool) {
+	if path.Base(name) == "session.lock" {
+		return "", false
+	}
+	if rel, ok := within(name, w.Folder); ok {
+		return path.Join(levelName, rel), true
+	}
+	for _, dim := range []struct{ folder, suffix, data string }{
+		{w.nether, "_nether", "DIM-1"},
+		{w.end, "_the_end", "DIM1"},
+	} {
+		if dim.folder == "" {
+			continue
+		}
+		rel, ok := within(name, dim.folder)
+		if !ok {
+			continue
+		}
+		if split {
+			return path.Join(levelName+dim.suffix, rel), true
+		}
+		if rel == dim.data || strings.HasPrefix(rel, dim.data+"/") {
+			return path.Join(levelName, rel), true
+		}
+	}
+	return "", false
+}
+
+// within returns name relative to dir if it is inside it.
+func within(name, dir string) (string, bool) {
+	if dir == "." {
+		return name, true
+	}
+	rel, ok := strings.CutPrefix(name, dir+"/")
+	return rel, ok && rel != ""
+}
+
+// Walk calls fn for each file of the world with its path under the server
+// directory, as placed by target.
+func (w *World) Walk(levelName string, split bool, fn func(name string, mode, size int64, r io.Reader) error) error {
+	return w.walk(func(e entry, r io.Reader) error {
+		if e.dir {
+			return nil
+		}
+		name, ok := w.target(e.name, levelName, split)
+		if !ok {
+			return nil
+		}
+		return fn(name, e.mode, e.size, r)
+	})
+}
+
+// walk calls fn for every entry of the archive in order, rejecting links,
+// devices and unsafe paths.
+func (w *World) walk(fn func(e entry, r io.Reader) error) error {
+	if w.Format == FormatZip {
+		archive, err := zip.NewReader(w.r, w.size)
+		if err != nil {
+			return invalid("invalid zip archive: %v", err)
+		}
+		for _, f := range archive.File {
+			mode := f.Mode()
+			if !mode.IsRegular() && !mode.IsDir() {
+				return invalid("archive entry %q is not a regular file", f.Name)
+			}
+			e, skip, err := newEntry(f.Name, mode.IsDir(), int64(mode.Perm()), int64(f.UncompressedSize64))
+			if err != nil {
+				return err
+			}
+			if skip {
+				continue
+			}
+			var rc io.ReadCloser
+			if !e.dir {
+				if rc, err = f.Open(); err != nil {
+					return invalid("failed to read %s: %v", f.Name, err)
+				}
+			}
+			err = fn(e, rc)
+			if rc != nil {
+				rc.Close()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = io.NewSectionReader(w.r, 0, w.size)
+	if w.Format == FormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return invalid("invalid gzip archive: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalid("invalid tar archive: %v", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return invalid("archive entry %q is not a regular file", hdr.Name)
+		}
+		e, skip, err := newEntry(hdr.Name, hdr.Typeflag == tar.TypeDir, hdr.Mode&0777, hdr.Size)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		if err := fn(e, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// newEntry normalises an archive path such as ./world/level.dat and checks
+// that it stays inside the archive. The archive root itself is skipped.
+func newEntry(name string, dir bool, mode, size int64) (entry, bool, error) {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if name == "" || name == "." {
+		return entry{}, true, nil
+	}
+	clean := path.Clean(name)
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || clean != name || clean == ".." || strings.HasPrefix(clean, "../") {
+		return entry{}, false, invalid("archive entry %q has an unsafe path", name)
+	}
+	if size < 0 {
+		return entry{}, false, invalid("archive entry %q has an invalid size", name)
+	}
+	if mode == 0 {
+		mode = 0644
+		if dir {
+			mode = 0755
+		}
+	}
+	return entry{name: name, dir: dir, mode: mode, size: size}, false, nil
+}
@@ -0,0 +1,154 @@
+package world
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		dir      bool
+		mode     int64
+		size     int64
+		want     entry
+		skip     bool
+		errorMsg string
+	}{
+		{name: "file", in: "world/level.dat", mode: 0600, size: 10, want: entry{name: "world/level.dat", mode: 0600, size: 10}},
+		{name: "dot prefix", in: "./world/level.dat", mode: 0644, want: entry{name: "world/level.dat", mode: 0644}},
+		{name: "directory", in: "world/region/", dir: true, mode: 0755, want: entry{name: "world/region", dir: true, mode: 0755}},
+		{name: "default file mode", in: "level.dat", want: entry{name: "level.dat", mode: 0644}},
+		{name: "default directory mode", in: "region", dir: true, want: entry{name: "region", dir: true, mode: 0755}},
+		{name: "root", in: "./", dir: true, skip: true},
+		{name: "dot", in: ".", dir: true, skip: true},
+		{name: "empty", in: "", skip: true},
+		{name: "parent", in: "../level.dat", errorMsg: "unsafe path"},
+		{name: "parent only", in: "..", dir: true, errorMsg: "unsafe path"},
+		{name: "parent inside", in: "world/../../level.dat", errorMsg: "unsafe path"},
+		{name: "uncleaned", in: "world/../level.dat", errorMsg: "unsafe path"},
+		{name: "absolute", in: "/etc/passwd", errorMsg: "unsafe path"},
+		{name: "backslash", in: "world\\..\\level.dat", errorMsg: "unsafe path"},
+		{name: "double slash", in: "world//level.dat", errorMsg: "unsafe path"},
+		{name: "negative size", in: "level.dat", size: -1, errorMsg: "invalid size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skip, err := newEntry(tt.in, tt.dir, tt.mode, tt.size)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("newEntry(%q) error = %v, want one containing %q", tt.in, err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newEntry(%q): %v", tt.in, err)
+			}
+			if skip != tt.skip || got != tt.want {
+				t.Errorf("newEntry(%q) = %+v, %v, want %+v, %v", tt.in, got, skip, tt.want, tt.skip)
+			}
+		})
+	}
+}
+
+func TestWorldFolder(t *testing.T) {
+	tests := []struct {
+		name     string
+		folders  []string
+		want     string
+		errorMsg string
+	}{
+		{name: "archive root", folders: []string{"."}, want: "."},
+		{name: "world folder", folders: []string{"world"}, want: "world"},
+		{name: "bukkit server", folders: []string{"world", "world_nether", "world_the_end"}, want: "world"},
+		{name: "shallowest", folders: []string{"server/world", "server/world/backup"}, want: "server/world"},
+		{name: "root before folders", folders: []string{".", "old"}, want: "."},
+		{name: "several worlds", folders: []string{"a", "b"}, errorMsg: "several worlds"},
+		{name: "only dimensions", folders: []string{"world_nether", "world_the_end"}, errorMsg: "no level.dat"},
+		{name: "none", errorMsg: "no level.dat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels := map[string][]byte{}
+			for _, folder := range tt.folders {
+				levels[folder] = nil
+			}
+			got, err := worldFolder(levels)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("worldFolder(%v) error = %v, want one containing %q", tt.folders, err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("worldFolder(%v): %v", tt.folders, err)
+			}
+			if got != tt.want {
+				t.Errorf("worldFolder(%v) = %q, want %q", tt.folders, got, tt.want)
+			}
+		})
+	}
+}
+
+func zipArchive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write(make([]byte, hdr.Size))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenRejectsUnsafeArchives(t *testing.T) {
+	tests := []struct {
+		name     string
+		archive  []byte
+		errorMsg string
+	}{
+		{"zip parent path", zipArchive(t, "world/level.dat", "../escape.txt"), "unsafe path"},
+		{"zip absolute path", zipArchive(t, "/world/level.dat"), "unsafe path"},
+		{"tar parent path", tarArchive(t, &tar.Header{Name: "world/../../escape", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}), "unsafe path"},
+		{"tar symlink", tarArchive(t, &tar.Header{Name: "world/region", Typeflag: tar.TypeSymlink, Linkname: "/etc"}), "not a regular file"},
+		{"tar hard link", tarArchive(t, &tar.Header{Name: "world/level.dat", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}), "not a regular file"},
+		{"too large", tarArchive(t, &tar.Header{Name: "world/region/r.0.0.mca", Typeflag: tar.TypeReg, Mode: 0644, Size: 2048}), "larger than"},
+		{"not an archive", []byte("hello"), "not a zip or tar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(bytes.NewReader(tt.archive), int64(len(tt.archive)), 1024)
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Open() error = %v, want one containing %q", err, tt.errorMsg)
+			}
+		})
+	}
+}